@@ -22,6 +22,7 @@ import (
 	"github.com/hpcng/singularity/internal/pkg/client/shub"
 	"github.com/hpcng/singularity/internal/pkg/util/uri"
 	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/singularityconf"
 	"github.com/spf13/cobra"
 )
 
@@ -30,9 +31,15 @@ const (
 )
 
 func getCacheHandle(cfg cache.Config) *cache.Handle {
+	systemDir := ""
+	if conf := singularityconf.GetCurrentConfig(); conf != nil {
+		systemDir = conf.SystemCacheDir
+	}
+
 	h, err := cache.New(cache.Config{
 		ParentDir: os.Getenv(cache.DirEnv),
 		Disable:   cfg.Disable,
+		SystemDir: systemDir,
 	})
 	if err != nil {
 		sylog.Fatalf("Failed to create an image cache handle: %s", err)
@@ -69,7 +76,7 @@ func handleOCI(ctx context.Context, imgCache *cache.Handle, cmd *cobra.Command,
 	if err != nil {
 		sylog.Fatalf("While creating Docker credentials: %v", err)
 	}
-	return oci.Pull(ctx, imgCache, pullFrom, tmpDir, ociAuth, noHTTPS, false)
+	return oci.Pull(ctx, imgCache, pullFrom, runtime.GOARCH, tmpDir, ociAuth, noHTTPS, false)
 }
 
 func handleOras(ctx context.Context, imgCache *cache.Handle, cmd *cobra.Command, pullFrom string) (string, error) {
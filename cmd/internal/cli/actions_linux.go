@@ -518,6 +518,42 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			}
 			generator.SetProcessArgs([]string{"/sbin/init"})
 		}
+
+		policy, err := instance.ParseRestartPolicy(instanceStartRestart)
+		if err != nil {
+			sylog.Fatalf("Invalid --restart value: %s", err)
+		}
+		if policy.Enabled() {
+			if IsBoot {
+				sylog.Fatalf("--restart option is not supported with --boot")
+			}
+			engineConfig.SetRestartPolicy(policy.String())
+		}
+
+		interval, err := time.ParseDuration(instanceStartHealthInterval)
+		if err != nil || interval <= 0 {
+			sylog.Fatalf("Invalid --health-interval value %q, must be a positive duration", instanceStartHealthInterval)
+		}
+		if instanceStartHealthRetries <= 0 {
+			sylog.Fatalf("Invalid --health-retries value %d, must be a positive number", instanceStartHealthRetries)
+		}
+		if IsBoot && instanceStartHealthCmd != "" {
+			sylog.Fatalf("--health-cmd option is not supported with --boot")
+		}
+		engineConfig.SetHealthCmd(instanceStartHealthCmd)
+		engineConfig.SetHealthInterval(interval)
+		engineConfig.SetHealthRetries(instanceStartHealthRetries)
+
+		logMaxSize, err := fs.ParseSize(instanceStartLogMaxSize)
+		if err != nil || logMaxSize < 0 {
+			sylog.Fatalf("Invalid --log-max-size value %q", instanceStartLogMaxSize)
+		}
+		if instanceStartLogMaxFiles < 0 {
+			sylog.Fatalf("Invalid --log-max-files value %d, must be a positive number or zero", instanceStartLogMaxFiles)
+		}
+		engineConfig.SetLogMaxSize(logMaxSize)
+		engineConfig.SetLogMaxFiles(instanceStartLogMaxFiles)
+
 		pwd, err := user.GetPwUID(uint32(os.Getuid()))
 		if err != nil {
 			sylog.Fatalf("failed to retrieve user information for UID %d: %s", os.Getuid(), err)
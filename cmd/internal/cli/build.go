@@ -7,10 +7,12 @@
 package cli
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
 
 	ocitypes "github.com/containers/image/v5/types"
 	"github.com/hpcng/singularity/docs"
@@ -32,19 +34,31 @@ var buildArgs struct {
 	sections      []string
 	bindPaths     []string
 	mounts        []string
+	buildArgs     []string
+	buildArgFile  string
+	buildLogSize  string
+	cgroupsPath   string
+	cpus          string
+	memory        string
+	secrets       []string
 	arch          string
 	builderURL    string
 	libraryURL    string
 	keyServerURL  string
 	webURL        string
+	jobs          int
+	buildLog      bool
 	detached      bool
 	encrypt       bool
 	fakeroot      bool
 	fixPerms      bool
 	isJSON        bool
 	noCleanUp     bool
+	noSBOM        bool
+	noStageCache  bool
 	noTest        bool
 	remote        bool
+	reproducible  bool
 	sandbox       bool
 	update        bool
 	nvidia        bool
@@ -123,7 +137,7 @@ var buildArchFlag = cmdline.Flag{
 	Value:        &buildArgs.arch,
 	DefaultValue: runtime.GOARCH,
 	Name:         "arch",
-	Usage:        "architecture for remote build",
+	Usage:        "architecture for remote build, or to select from multi-architecture OCI images",
 	EnvKeys:      []string{"BUILD_ARCH"},
 }
 
@@ -178,6 +192,97 @@ var buildNoCleanupFlag = cmdline.Flag{
 	EnvKeys:      []string{"NO_CLEANUP"},
 }
 
+// --jobs
+var buildJobsFlag = cmdline.Flag{
+	ID:           "buildJobsFlag",
+	Value:        &buildArgs.jobs,
+	DefaultValue: 0,
+	Name:         "jobs",
+	Usage:        "maximum number of independent stages of a multi-stage build to build concurrently, defaults to the number of CPUs (ignored with cgroups limits)",
+	EnvKeys:      []string{"BUILD_JOBS"},
+}
+
+// --no-stage-cache
+var buildNoStageCacheFlag = cmdline.Flag{
+	ID:           "buildNoStageCacheFlag",
+	Value:        &buildArgs.noStageCache,
+	DefaultValue: false,
+	Name:         "no-stage-cache",
+	Usage:        "rebuild all stages of a multi-stage build rather than using cached stages",
+	EnvKeys:      []string{"NO_STAGE_CACHE"},
+}
+
+// --no-sbom
+var buildNoSBOMFlag = cmdline.Flag{
+	ID:           "buildNoSBOMFlag",
+	Value:        &buildArgs.noSBOM,
+	DefaultValue: false,
+	Name:         "no-sbom",
+	Usage:        "do not embed a software bill of materials in the SIF image",
+	EnvKeys:      []string{"NO_SBOM"},
+}
+
+// --reproducible
+var buildReproducibleFlag = cmdline.Flag{
+	ID:           "buildReproducibleFlag",
+	Value:        &buildArgs.reproducible,
+	DefaultValue: false,
+	Name:         "reproducible",
+	Usage:        "build a deterministic image, using the time set by the SOURCE_DATE_EPOCH environment variable (or the Unix epoch) for all timestamps",
+	EnvKeys:      []string{"REPRODUCIBLE"},
+}
+
+// --build-log
+var buildBuildLogFlag = cmdline.Flag{
+	ID:           "buildBuildLogFlag",
+	Value:        &buildArgs.buildLog,
+	DefaultValue: false,
+	Name:         "build-log",
+	Usage:        "store the compressed output of the build scripts in the SIF image, shown with 'singularity inspect --build-log'",
+	EnvKeys:      []string{"BUILD_LOG"},
+}
+
+// --build-log-size
+var buildBuildLogSizeFlag = cmdline.Flag{
+	ID:           "buildBuildLogSizeFlag",
+	Value:        &buildArgs.buildLogSize,
+	DefaultValue: "1MiB",
+	Name:         "build-log-size",
+	Usage:        "maximum size of the build scripts output stored with --build-log, the remaining output is discarded",
+	EnvKeys:      []string{"BUILD_LOG_SIZE"},
+}
+
+// --apply-cgroups
+var buildApplyCgroupsFlag = cmdline.Flag{
+	ID:           "buildApplyCgroupsFlag",
+	Value:        &buildArgs.cgroupsPath,
+	DefaultValue: "",
+	Name:         "apply-cgroups",
+	Usage:        "apply cgroups from file for the %post and %test scripts (root only)",
+	EnvKeys:      []string{"APPLY_CGROUPS"},
+}
+
+// --memory
+var buildMemoryFlag = cmdline.Flag{
+	ID:           "buildMemoryFlag",
+	Value:        &buildArgs.memory,
+	DefaultValue: "",
+	Name:         "memory",
+	Usage:        "maximum amount of memory the %post and %test scripts can use (e.g. 2GiB), overrides --apply-cgroups memory limit (root only)",
+	Tag:          "<size>",
+	EnvKeys:      []string{"BUILD_MEMORY"},
+}
+
+// --cpus
+var buildCPUsFlag = cmdline.Flag{
+	ID:           "buildCPUsFlag",
+	Value:        &buildArgs.cpus,
+	DefaultValue: "",
+	Name:         "cpus",
+	Usage:        "number of CPUs the %post and %test scripts can use (e.g. 1.5, at least 0.01), overrides --apply-cgroups CPU quota (root only)",
+	EnvKeys:      []string{"BUILD_CPUS"},
+}
+
 // --fakeroot
 var buildFakerootFlag = cmdline.Flag{
 	ID:           "buildFakerootFlag",
@@ -274,23 +379,65 @@ var buildWritableTmpfsFlag = cmdline.Flag{
 	EnvKeys:      []string{"WRITABLE_TMPFS"},
 }
 
+// --build-arg
+var buildBuildArgFlag = cmdline.Flag{
+	ID:           "buildBuildArgFlag",
+	Value:        &buildArgs.buildArgs,
+	DefaultValue: cmdline.StringArray{}, // to allow commas in values
+	Name:         "build-arg",
+	Usage:        "set the value of a build argument used by {{ name }} placeholders of the definition file, in the name=value format",
+	Tag:          "<name=value>",
+}
+
+// --build-arg-file
+var buildBuildArgFileFlag = cmdline.Flag{
+	ID:           "buildBuildArgFileFlag",
+	Value:        &buildArgs.buildArgFile,
+	DefaultValue: "",
+	Name:         "build-arg-file",
+	Usage:        "read build arguments from a file with one name=value per line, --build-arg values take precedence",
+	Tag:          "<path>",
+}
+
+// --secret
+var buildSecretFlag = cmdline.Flag{
+	ID:           "buildSecretFlag",
+	Value:        &buildArgs.secrets,
+	DefaultValue: cmdline.StringArray{}, // to allow commas in values
+	Name:         "secret",
+	Usage:        "make a host file available read-only under /run/secrets/<id> during the %post section only, it is never stored in the image",
+	Tag:          "<id=...,src=...>",
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(buildCmd)
 
+		cmdManager.RegisterFlagForCmd(&buildApplyCgroupsFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildArchFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildBuildArgFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildBuildArgFileFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildBuilderFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildBuildLogFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildBuildLogSizeFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildCPUsFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildDetachedFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildDisableCacheFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildEncryptFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildFakerootFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildFixPermsFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildJobsFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildJSONFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildLibraryFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildMemoryFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildNoCleanupFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildNoSBOMFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildNoStageCacheFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildNoTestFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildRemoteFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildReproducibleFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildSandboxFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildSecretFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildSectionFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildUpdateFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&commonForceFlag, buildCmd)
@@ -403,9 +550,38 @@ func checkBuildTarget(path string) error {
 	return nil
 }
 
+// buildArguments returns the build arguments set with --build-arg-file
+// and --build-arg.
+func buildArguments() (map[string]string, error) {
+	args := make(map[string]string)
+
+	if buildArgs.buildArgFile != "" {
+		f, err := os.Open(buildArgs.buildArgFile)
+		if err != nil {
+			return nil, fmt.Errorf("while opening build arguments file: %v", err)
+		}
+		defer f.Close()
+
+		args, err = parser.ParseArguments(f)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing build arguments file %s: %v", buildArgs.buildArgFile, err)
+		}
+	}
+
+	for _, arg := range buildArgs.buildArgs {
+		split := strings.SplitN(arg, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("invalid build argument %q, must be in the name=value format", arg)
+		}
+		args[split[0]] = split[1]
+	}
+
+	return args, nil
+}
+
 // definitionFromSpec is specifically for parsing specs for the remote builder
 // it uses a different version the the definition struct and parser
-func definitionFromSpec(spec string) (types.Definition, error) {
+func definitionFromSpec(spec string, args map[string]string) (types.Definition, error) {
 	// Try spec as URI first
 	def, err := types.NewDefinitionFromURI(spec)
 	if err == nil {
@@ -413,28 +589,35 @@ func definitionFromSpec(spec string) (types.Definition, error) {
 	}
 
 	// Try spec as local file
-	var isValid bool
-	isValid, err = parser.IsValidDefinition(spec)
+	fi, err := os.Stat(spec)
 	if err != nil {
 		return types.Definition{}, err
 	}
 
-	if isValid {
-		sylog.Debugf("Found valid definition: %s\n", spec)
-		// File exists and contains valid definition
-		var defFile *os.File
-		defFile, err = os.Open(spec)
+	if !fi.IsDir() {
+		raw, err := ioutil.ReadFile(spec)
+		if err != nil {
+			return types.Definition{}, err
+		}
+		// the build arguments are resolved before parsing the definition,
+		// as arguments without default value must be provided by args
+		if unused := parser.UnusedArguments(raw, args); len(unused) > 0 {
+			sylog.Warningf("Build argument(s) not used by %s: %s", spec, strings.Join(unused, ", "))
+		}
+		raw, err = parser.ResolveArguments(raw, args)
 		if err != nil {
 			return types.Definition{}, err
 		}
 
-		defer defFile.Close()
-
-		return parser.ParseDefinitionFile(defFile)
+		def, err := parser.ParseDefinitionFile(bytes.NewReader(raw))
+		if err != nil {
+			return types.Definition{}, err
+		}
+		sylog.Debugf("Found valid definition: %s\n", spec)
+		return def, nil
 	}
 
-	// File exists and does NOT contain a valid definition
-	// local image or sandbox
+	// Directory is a local sandbox
 	def = types.Definition{
 		Header: map[string]string{
 			"bootstrap": "localimage",
@@ -26,6 +26,7 @@ import (
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/internal/pkg/util/interactive"
 	"github.com/hpcng/singularity/internal/pkg/util/starter"
+	"github.com/hpcng/singularity/internal/pkg/util/uri"
 	"github.com/hpcng/singularity/internal/pkg/util/user"
 	"github.com/hpcng/singularity/pkg/build/types"
 	"github.com/hpcng/singularity/pkg/image"
@@ -139,14 +140,36 @@ func runBuild(cmd *cobra.Command, args []string) {
 		}
 		os.Setenv("SINGULARITY_WRITABLE_TMPFS", "1")
 	}
-
-	if buildArgs.arch != runtime.GOARCH && !buildArgs.remote {
-		sylog.Fatalf("Requested architecture (%s) does not match host (%s). Cannot build locally.", buildArgs.arch, runtime.GOARCH)
+	if len(buildArgs.secrets) > 0 && buildArgs.remote {
+		sylog.Fatalf("--secret option is not supported for remote build")
+	}
+	if buildArgs.reproducible && buildArgs.remote {
+		sylog.Fatalf("--reproducible option is not supported for remote build")
+	}
+	if buildArgs.buildLog && buildArgs.remote {
+		sylog.Fatalf("--build-log option is not supported for remote build")
+	}
+	for _, f := range []string{buildApplyCgroupsFlag.Name, buildMemoryFlag.Name, buildCPUsFlag.Name} {
+		if !cmd.Flags().Changed(f) {
+			continue
+		}
+		if buildArgs.remote {
+			sylog.Fatalf("--%s option is not supported for remote build", f)
+		}
+		if buildArgs.fakeroot {
+			sylog.Fatalf("--%s option is not supported for fakeroot build", f)
+		}
 	}
 
 	dest := args[0]
 	spec := args[1]
 
+	// images for another architecture can be built locally from OCI
+	// sources only, as there is no definition to execute
+	if buildArgs.arch != runtime.GOARCH && !buildArgs.remote && !isOCISpec(spec) {
+		sylog.Fatalf("Requested architecture (%s) does not match host (%s). Cannot build locally.", buildArgs.arch, runtime.GOARCH)
+	}
+
 	// check if target collides with existing file
 	if err := checkBuildTarget(dest); err != nil {
 		sylog.Fatalf("While checking build target: %s", err)
@@ -188,7 +211,12 @@ func runBuildRemote(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 		sylog.Fatalf("Unable to submit build job: %v", remoteWarning)
 	}
 
-	def, err := definitionFromSpec(spec)
+	args, err := buildArguments()
+	if err != nil {
+		sylog.Fatalf("While reading build arguments: %v", err)
+	}
+
+	def, err := definitionFromSpec(spec, args)
 	if err != nil {
 		sylog.Fatalf("Unable to build from %s: %v", spec, err)
 	}
@@ -282,6 +310,47 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 		}
 	}
 
+	var sourceDateEpoch int64
+	if buildArgs.reproducible {
+		if keyInfo != nil {
+			sylog.Fatalf("--reproducible option is not supported for encrypted containers")
+		}
+		epoch, err := build.SourceDateEpoch()
+		if err != nil {
+			sylog.Fatalf("While checking reproducible build time: %v", err)
+		}
+		sourceDateEpoch = epoch
+	}
+
+	var buildLogSize int64
+	if buildArgs.buildLog {
+		if buildArgs.sandbox {
+			sylog.Fatalf("--build-log option is not supported for sandbox build")
+		}
+		size, err := fs.ParseSize(buildArgs.buildLogSize)
+		if err != nil {
+			sylog.Fatalf("Invalid --build-log-size value: %v", err)
+		}
+		buildLogSize = size
+	}
+
+	var memory int64
+	if buildArgs.memory != "" {
+		size, err := fs.ParseSize(buildArgs.memory)
+		if err != nil || size <= 0 {
+			sylog.Fatalf("Invalid --memory value %q", buildArgs.memory)
+		}
+		memory = size
+	}
+	var cpus float64
+	if buildArgs.cpus != "" {
+		n, err := strconv.ParseFloat(buildArgs.cpus, 64)
+		if err != nil || n <= 0 {
+			sylog.Fatalf("Invalid --cpus value %q", buildArgs.cpus)
+		}
+		cpus = n
+	}
+
 	imgCache := getCacheHandle(cache.Config{Disable: disableCache})
 	if imgCache == nil {
 		sylog.Fatalf("Failed to create an image cache handle")
@@ -301,8 +370,18 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 		sylog.Fatalf("While creating Docker credentials: %v", err)
 	}
 
+	args, err := buildArguments()
+	if err != nil {
+		sylog.Fatalf("While reading build arguments: %v", err)
+	}
+
+	secrets, err := buildSecrets()
+	if err != nil {
+		sylog.Fatalf("While reading build secrets: %v", err)
+	}
+
 	// parse definition to determine build source
-	defs, err := build.MakeAllDefs(spec)
+	defs, err := build.MakeAllDefs(spec, args)
 	if err != nil {
 		sylog.Fatalf("Unable to build from %s: %v", spec, err)
 	}
@@ -341,16 +420,32 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 
 	}
 
+	jobs := buildArgs.jobs
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	} else if jobs < 0 {
+		sylog.Fatalf("Invalid number of build jobs: %d", jobs)
+	}
+
 	b, err := build.New(
 		defs,
 		build.Config{
-			Dest:      dst,
-			Format:    buildFormat,
-			NoCleanUp: buildArgs.noCleanUp,
+			Dest:         dst,
+			Format:       buildFormat,
+			Spec:         spec,
+			NoCleanUp:    buildArgs.noCleanUp,
+			NoSBOM:       buildArgs.noSBOM,
+			Jobs:         jobs,
+			BuildLog:     buildArgs.buildLog,
+			BuildLogSize: buildLogSize,
+			CgroupsPath:  buildArgs.cgroupsPath,
+			Memory:       memory,
+			CPUs:         cpus,
 			Opts: types.Options{
 				ImgCache:          imgCache,
 				TmpDir:            tmpDir,
 				NoCache:           disableCache,
+				NoStageCache:      buildArgs.noStageCache,
 				Update:            buildArgs.update,
 				Force:             forceOverwrite,
 				Sections:          buildArgs.sections,
@@ -363,6 +458,11 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 				EncryptionKeyInfo: keyInfo,
 				FixPerms:          buildArgs.fixPerms,
 				SandboxTarget:     sandboxTarget,
+				Arch:              buildArgs.arch,
+				BuildArgs:         args,
+				Secrets:           secrets,
+				Reproducible:      buildArgs.reproducible,
+				SourceDateEpoch:   sourceDateEpoch,
 			},
 		})
 	if err != nil {
@@ -395,6 +495,15 @@ func checkSections() error {
 	return nil
 }
 
+// isOCISpec returns whether the build spec is an OCI image URI.
+func isOCISpec(spec string) bool {
+	switch transport, _ := uri.Split(spec); transport {
+	case "docker", "docker-archive", "docker-daemon", "oci", "oci-archive":
+		return true
+	}
+	return false
+}
+
 func isImage(spec string) bool {
 	i, err := image.Init(spec, false)
 	if i != nil {
@@ -484,3 +593,23 @@ func getEncryptionMaterial(cmd *cobra.Command) (cryptkey.KeyInfo, error) {
 
 	return cryptkey.KeyInfo{}, nil
 }
+
+// buildSecrets returns the build secrets set with --secret.
+func buildSecrets() ([]types.Secret, error) {
+	var secrets []types.Secret
+
+	ids := make(map[string]bool)
+	for _, spec := range buildArgs.secrets {
+		secret, err := build.ParseSecret(spec)
+		if err != nil {
+			return nil, err
+		}
+		if ids[secret.ID] {
+			return nil, fmt.Errorf("duplicate secret id %s", secret.ID)
+		}
+		ids[secret.ID] = true
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, nil
+}
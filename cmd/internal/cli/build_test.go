@@ -0,0 +1,54 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefinitionFromSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-spec-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the only value of version comes from the build arguments
+	spec := filepath.Join(dir, "test.def")
+	content := "Bootstrap: docker\nFrom: alpine:{{ version }}\n\n%arguments\n    version\n\n%post\n    echo {{ version }}\n"
+	if err := ioutil.WriteFile(spec, []byte(content), 0o644); err != nil {
+		t.Fatalf("could not write %s: %s", spec, err)
+	}
+
+	def, err := definitionFromSpec(spec, map[string]string{"version": "3.14"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if from := def.Header["from"]; from != "alpine:3.14" {
+		t.Errorf("got from %q, want alpine:3.14", from)
+	}
+	if post := strings.TrimSpace(def.BuildData.Post.Script); post != "echo 3.14" {
+		t.Errorf("got %%post %q, want echo 3.14", post)
+	}
+
+	if _, err := definitionFromSpec(spec, nil); err == nil {
+		t.Errorf("unexpected success without build argument")
+	} else if !strings.Contains(err.Error(), "no value for build argument(s): version") {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	def, err = definitionFromSpec(dir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if def.Header["bootstrap"] != "localimage" || def.Header["from"] != dir {
+		t.Errorf("unexpected header for sandbox: %v", def.Header)
+	}
+}
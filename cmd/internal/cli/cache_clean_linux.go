@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -14,6 +14,7 @@ import (
 	"github.com/hpcng/singularity/docs"
 	"github.com/hpcng/singularity/internal/app/singularity"
 	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/pkg/cmdline"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/spf13/cobra"
@@ -25,14 +26,16 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&cacheCleanDaysFlag, cacheCleanCmd)
 		cmdManager.RegisterFlagForCmd(&cacheCleanDryFlag, cacheCleanCmd)
 		cmdManager.RegisterFlagForCmd(&cacheCleanForceFlag, cacheCleanCmd)
+		cmdManager.RegisterFlagForCmd(&cacheCleanMaxSizeFlag, cacheCleanCmd)
 	})
 }
 
 var (
-	cacheCleanTypes []string
-	cacheCleanDays  int
-	cacheCleanDry   bool
-	cacheCleanForce bool
+	cacheCleanTypes   []string
+	cacheCleanDays    int
+	cacheCleanDry     bool
+	cacheCleanForce   bool
+	cacheCleanMaxSize string
 
 	// -T|--type
 	cacheCleanTypesFlag = cmdline.Flag{
@@ -41,7 +44,7 @@ var (
 		DefaultValue: []string{"all"},
 		Name:         "type",
 		ShortHand:    "T",
-		Usage:        "a list of cache types to clean (possible values: library, oci, shub, blob, net, oras, all)",
+		Usage:        "a list of cache types to clean (possible values: library, oci, shub, blob, net, oras, stage, all)",
 	}
 
 	// -D|--days
@@ -74,11 +77,20 @@ var (
 		Usage:        "suppress any prompts and clean the cache",
 	}
 
+	// --max-size
+	cacheCleanMaxSizeFlag = cmdline.Flag{
+		ID:           "cacheCleanMaxSizeFlag",
+		Value:        &cacheCleanMaxSize,
+		DefaultValue: "",
+		Name:         "max-size",
+		Usage:        "remove least recently used cache entries until the cache fits within the specified size (e.g. 10GiB)",
+	}
+
 	// cacheCleanCmd is 'singularity cache clean' and will clear your local singularity cache
 	cacheCleanCmd = &cobra.Command{
 		DisableFlagsInUseLine: true,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := cleanCache(); err != nil {
+			if err := cleanCache(cmd); err != nil {
 				sylog.Fatalf("Handle clean failed: %v", err)
 			}
 		},
@@ -90,12 +102,24 @@ var (
 	}
 )
 
-func cleanCache() error {
+func cleanCache(cmd *cobra.Command) error {
+	maxSize := int64(-1)
+	if cacheCleanMaxSize != "" {
+		if cmd.Flags().Changed(cacheCleanDaysFlag.Name) {
+			return fmt.Errorf("--days and --max-size options cannot be used together")
+		}
+		size, err := fs.ParseSize(cacheCleanMaxSize)
+		if err != nil {
+			return fmt.Errorf("invalid --max-size value: %v", err)
+		}
+		maxSize = size
+	}
+
 	if cacheCleanDry {
 		fmt.Println("User requested a dry run. Not actually deleting any data!")
 	}
 	if !cacheCleanForce && !cacheCleanDry {
-		ok, err := cleanCachePrompt()
+		ok, err := cleanCachePrompt(maxSize)
 		if err != nil {
 			return fmt.Errorf("could not prompt user: %v", err)
 		}
@@ -107,16 +131,20 @@ func cleanCache() error {
 
 	// create a handle to access the current image cache
 	imgCache := getCacheHandle(cache.Config{})
-	err := singularity.CleanSingularityCache(imgCache, cacheCleanDry, cacheCleanTypes, cacheCleanDays)
+	err := singularity.CleanSingularityCache(imgCache, cacheCleanDry, cacheCleanTypes, cacheCleanDays, maxSize)
 	if err != nil {
 		return fmt.Errorf("could not clean cache: %v", err)
 	}
 	return nil
 }
 
-func cleanCachePrompt() (bool, error) {
-	fmt.Print(`This will delete everything in your cache (containers from all sources and OCI blobs). 
-Hint: You can see exactly what would be deleted by canceling and using the --dry-run option.
+func cleanCachePrompt(maxSize int64) (bool, error) {
+	if maxSize >= 0 {
+		fmt.Printf("This will delete the least recently used entries in your cache until it uses at most %s.\n", fs.FindSize(maxSize))
+	} else {
+		fmt.Print("This will delete everything in your cache (containers from all sources and OCI blobs). \n")
+	}
+	fmt.Print(`Hint: You can see exactly what would be deleted by canceling and using the --dry-run option.
 Do you want to continue? [N/y] `)
 
 	r := bufio.NewReader(os.Stdin)
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -19,6 +19,8 @@ import (
 var (
 	cacheListTypes   []string
 	cacheListVerbose bool
+	cacheListSystem  bool
+	cacheListJSON    bool
 )
 
 // -T|--type
@@ -28,7 +30,7 @@ var cacheListTypesFlag = cmdline.Flag{
 	DefaultValue: []string{"all"},
 	Name:         "type",
 	ShortHand:    "T",
-	Usage:        "a list of cache types to display, possible entries: library, oci, shub, blob(s), all",
+	Usage:        "a list of cache types to display, possible entries: library, oci, shub, blob(s), stage, all",
 }
 
 // -s|--summary
@@ -41,10 +43,31 @@ var cacheListVerboseFlag = cmdline.Flag{
 	Usage:        "include cache entries in the output",
 }
 
+// --system
+var cacheListSystemFlag = cmdline.Flag{
+	ID:           "cacheListSystem",
+	Value:        &cacheListSystem,
+	DefaultValue: false,
+	Name:         "system",
+	Usage:        "list the read-only system cache configured in singularity.conf instead of your cache",
+}
+
+// -j|--json
+var cacheListJSONFlag = cmdline.Flag{
+	ID:           "cacheListJSON",
+	Value:        &cacheListJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print every cache entry, with its metadata, in JSON format",
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterFlagForCmd(&cacheListTypesFlag, CacheListCmd)
 		cmdManager.RegisterFlagForCmd(&cacheListVerboseFlag, CacheListCmd)
+		cmdManager.RegisterFlagForCmd(&cacheListSystemFlag, CacheListCmd)
+		cmdManager.RegisterFlagForCmd(&cacheListJSONFlag, CacheListCmd)
 	})
 }
 
@@ -70,7 +93,19 @@ func cacheListCmd() error {
 		sylog.Fatalf("failed to create image cache handle")
 	}
 
-	err := singularity.ListSingularityCache(imgCache, cacheListTypes, cacheListVerbose)
+	if cacheListSystem {
+		imgCache = imgCache.System()
+		if imgCache == nil {
+			sylog.Fatalf("No system cache is available, check 'system cache dir' in singularity.conf")
+		}
+	}
+
+	var err error
+	if cacheListJSON {
+		err = singularity.ListSingularityCacheJSON(imgCache, cacheListTypes)
+	} else {
+		err = singularity.ListSingularityCache(imgCache, cacheListTypes, cacheListVerbose)
+	}
 	if err != nil {
 		sylog.Fatalf("An error occurred while listing cache: %v", err)
 		return err
@@ -0,0 +1,63 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&cacheVerifyTypesFlag, cacheVerifyCmd)
+		cmdManager.RegisterFlagForCmd(&cacheVerifyRemoveFlag, cacheVerifyCmd)
+	})
+}
+
+var (
+	cacheVerifyTypes  []string
+	cacheVerifyRemove bool
+
+	// -T|--type
+	cacheVerifyTypesFlag = cmdline.Flag{
+		ID:           "cacheVerifyTypes",
+		Value:        &cacheVerifyTypes,
+		DefaultValue: []string{"all"},
+		Name:         "type",
+		ShortHand:    "T",
+		Usage:        "a list of cache types to verify (possible values: library, oci-tmp, shub, blob, net, oras, stage, all)",
+	}
+
+	// -r|--remove
+	cacheVerifyRemoveFlag = cmdline.Flag{
+		ID:           "cacheVerifyRemove",
+		Value:        &cacheVerifyRemove,
+		DefaultValue: false,
+		Name:         "remove",
+		ShortHand:    "r",
+		Usage:        "remove corrupted entries and temporary files left behind by interrupted downloads",
+	}
+
+	// cacheVerifyCmd is 'singularity cache verify' and will check the integrity of your local singularity cache
+	cacheVerifyCmd = &cobra.Command{
+		DisableFlagsInUseLine: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			imgCache := getCacheHandle(cache.Config{})
+			if err := singularity.VerifySingularityCache(imgCache, cacheVerifyTypes, cacheVerifyRemove); err != nil {
+				sylog.Fatalf("Cache verification failed: %v", err)
+			}
+		},
+
+		Use:     docs.CacheVerifyUse,
+		Short:   docs.CacheVerifyShort,
+		Long:    docs.CacheVerifyLong,
+		Example: docs.CacheVerifyExample,
+	}
+)
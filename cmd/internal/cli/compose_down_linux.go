@@ -0,0 +1,79 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/internal/pkg/util/signal"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&composeDownSignalFlag, composeDownCmd)
+		cmdManager.RegisterFlagForCmd(&composeDownTimeoutFlag, composeDownCmd)
+	})
+}
+
+// -s|--signal
+var composeDownSignal string
+
+var composeDownSignalFlag = cmdline.Flag{
+	ID:           "composeDownSignalFlag",
+	Value:        &composeDownSignal,
+	DefaultValue: "",
+	Name:         "signal",
+	ShortHand:    "s",
+	Usage:        "signal sent to the instances",
+	Tag:          "<signal>",
+	EnvKeys:      []string{"SIGNAL"},
+}
+
+// -t|--timeout
+var composeDownTimeout int
+
+var composeDownTimeoutFlag = cmdline.Flag{
+	ID:           "composeDownTimeoutFlag",
+	Value:        &composeDownTimeout,
+	DefaultValue: 10,
+	Name:         "timeout",
+	ShortHand:    "t",
+	Usage:        "force kill each non stopped instance after X seconds",
+}
+
+// singularity compose down
+var composeDownCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(0),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadComposeFile()
+
+		sig := syscall.SIGINT
+		if composeDownSignal != "" {
+			var err error
+			sig, err = signal.Convert(composeDownSignal)
+			if err != nil {
+				sylog.Fatalf("Could not convert stop signal: %s", err)
+			}
+		}
+
+		timeout := time.Duration(composeDownTimeout) * time.Second
+		if err := singularity.ComposeDown(c, sig, timeout); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+
+	Use:     docs.ComposeDownUse,
+	Short:   docs.ComposeDownShort,
+	Long:    docs.ComposeDownLong,
+	Example: docs.ComposeDownExample,
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/pkg/compose"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(composeCmd)
+		cmdManager.RegisterSubCmd(composeCmd, composeUpCmd)
+		cmdManager.RegisterSubCmd(composeCmd, composeDownCmd)
+		cmdManager.RegisterSubCmd(composeCmd, composePsCmd)
+
+		cmdManager.RegisterFlagForCmd(&composeFileFlag, composeUpCmd, composeDownCmd, composePsCmd)
+	})
+}
+
+// -f|--file
+var composeFile string
+
+var composeFileFlag = cmdline.Flag{
+	ID:           "composeFileFlag",
+	Value:        &composeFile,
+	DefaultValue: compose.DefaultFile,
+	Name:         "file",
+	ShortHand:    "f",
+	Usage:        "path of the compose file",
+	Tag:          "<path>",
+	EnvKeys:      []string{"COMPOSE_FILE"},
+}
+
+// loadComposeFile loads the compose file set with --file.
+func loadComposeFile() *compose.Config {
+	c, err := compose.Load(composeFile)
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	return c
+}
+
+// singularity compose
+var composeCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.ComposeUse,
+	Short:         docs.ComposeShort,
+	Long:          docs.ComposeLong,
+	Example:       docs.ComposeExample,
+	SilenceErrors: true,
+}
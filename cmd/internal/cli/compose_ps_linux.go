@@ -0,0 +1,51 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&composePsJSONFlag, composePsCmd)
+	})
+}
+
+// -j|--json
+var composePsJSON bool
+
+var composePsJSONFlag = cmdline.Flag{
+	ID:           "composePsJSONFlag",
+	Value:        &composePsJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print structured json instead of a table",
+	EnvKeys:      []string{"JSON"},
+}
+
+// singularity compose ps
+var composePsCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(0),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := singularity.PrintComposeInstances(os.Stdout, loadComposeFile(), composePsJSON); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+
+	Use:     docs.ComposePsUse,
+	Short:   docs.ComposePsShort,
+	Long:    docs.ComposePsLong,
+	Example: docs.ComposePsExample,
+}
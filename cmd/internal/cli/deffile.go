@@ -0,0 +1,40 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(DeffileCmd)
+		cmdManager.RegisterSubCmd(DeffileCmd, DeffileLintCmd)
+		cmdManager.RegisterSubCmd(DeffileCmd, DeffileFmtCmd)
+		cmdManager.RegisterSubCmd(DeffileCmd, DeffileFromDockerfileCmd)
+
+		cmdManager.RegisterFlagForCmd(&deffileLintJSONFlag, DeffileLintCmd)
+		cmdManager.RegisterFlagForCmd(&deffileFmtCheckFlag, DeffileFmtCmd)
+		cmdManager.RegisterFlagForCmd(&deffileFmtWriteFlag, DeffileFmtCmd)
+	})
+}
+
+// DeffileCmd is the 'deffile' command that allows to manage definition files.
+var DeffileCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.DeffileUse,
+	Short:   docs.DeffileShort,
+	Long:    docs.DeffileLong,
+	Example: docs.DeffileExample,
+}
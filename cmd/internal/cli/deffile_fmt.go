@@ -0,0 +1,96 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/pkg/build/types/parser"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	deffileFmtCheck bool
+	deffileFmtWrite bool
+)
+
+// --check
+var deffileFmtCheckFlag = cmdline.Flag{
+	ID:           "deffileFmtCheckFlag",
+	Value:        &deffileFmtCheck,
+	DefaultValue: false,
+	Name:         "check",
+	Usage:        "print the files which are not formatted and exit with a non-zero status if any",
+}
+
+// -w|--write
+var deffileFmtWriteFlag = cmdline.Flag{
+	ID:           "deffileFmtWriteFlag",
+	Value:        &deffileFmtWrite,
+	DefaultValue: false,
+	Name:         "write",
+	ShortHand:    "w",
+	Usage:        "write the formatted definition to the files instead of printing it",
+}
+
+// DeffileFmtCmd is the 'deffile fmt' command that formats definition files.
+var DeffileFmtCmd = &cobra.Command{
+	Args:                  cobra.MinimumNArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if deffileFmtCheck && deffileFmtWrite {
+			sylog.Fatalf("--check and --write options are mutually exclusive")
+		}
+
+		unformatted := 0
+		for _, path := range args {
+			raw, err := ioutil.ReadFile(path)
+			if err != nil {
+				sylog.Fatalf("While reading definition file: %v", err)
+			}
+			formatted, err := parser.Format(raw)
+			if err != nil {
+				sylog.Fatalf("While formatting %s: %v", path, err)
+			}
+
+			switch {
+			case deffileFmtCheck:
+				if !bytes.Equal(raw, formatted) {
+					fmt.Println(path)
+					unformatted++
+				}
+			case deffileFmtWrite:
+				if bytes.Equal(raw, formatted) {
+					continue
+				}
+				fi, err := os.Stat(path)
+				if err != nil {
+					sylog.Fatalf("While formatting %s: %v", path, err)
+				}
+				if err := ioutil.WriteFile(path, formatted, fi.Mode()); err != nil {
+					sylog.Fatalf("While writing %s: %v", path, err)
+				}
+			default:
+				os.Stdout.Write(formatted)
+			}
+		}
+
+		if unformatted > 0 {
+			sylog.Fatalf("%d definition file(s) not formatted", unformatted)
+		}
+	},
+
+	Use:     docs.DeffileFmtUse,
+	Short:   docs.DeffileFmtShort,
+	Long:    docs.DeffileFmtLong,
+	Example: docs.DeffileFmtExample,
+}
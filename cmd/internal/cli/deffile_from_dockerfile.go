@@ -0,0 +1,50 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/pkg/build/types/parser"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+// DeffileFromDockerfileCmd is the 'deffile from-dockerfile' command that
+// converts a Dockerfile into a definition file.
+var DeffileFromDockerfileCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			sylog.Fatalf("While opening Dockerfile: %v", err)
+		}
+		defer f.Close()
+
+		defs, err := parser.ParseDockerfile(f)
+		if err != nil {
+			sylog.Fatalf("While converting %s: %v", args[0], err)
+		}
+
+		var raw bytes.Buffer
+		for _, d := range defs {
+			raw.Write(d.Raw)
+		}
+		formatted, err := parser.Format(raw.Bytes())
+		if err != nil {
+			sylog.Fatalf("While formatting converted definition: %v", err)
+		}
+		os.Stdout.Write(formatted)
+	},
+
+	Use:     docs.DeffileFromDockerfileUse,
+	Short:   docs.DeffileFromDockerfileShort,
+	Long:    docs.DeffileFromDockerfileLong,
+	Example: docs.DeffileFromDockerfileExample,
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/pkg/build/types/parser"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+var deffileLintJSON bool
+
+// -j|--json
+var deffileLintJSONFlag = cmdline.Flag{
+	ID:           "deffileLintJSONFlag",
+	Value:        &deffileLintJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print the diagnostics in JSON format",
+}
+
+// deffileDiagnostic is a diagnostic reported for a definition file.
+type deffileDiagnostic struct {
+	File string `json:"file"`
+	parser.Diagnostic
+}
+
+// DeffileLintCmd is the 'deffile lint' command that checks definition files.
+var DeffileLintCmd = &cobra.Command{
+	Args:                  cobra.MinimumNArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		diagnostics := []deffileDiagnostic{}
+		errCount := 0
+
+		for _, path := range args {
+			raw, err := ioutil.ReadFile(path)
+			if err != nil {
+				sylog.Fatalf("While reading definition file: %v", err)
+			}
+			for _, d := range parser.Lint(raw) {
+				if d.Severity == parser.SeverityError {
+					errCount++
+				}
+				diagnostics = append(diagnostics, deffileDiagnostic{File: path, Diagnostic: d})
+			}
+		}
+
+		if deffileLintJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(diagnostics); err != nil {
+				sylog.Fatalf("While encoding diagnostics: %v", err)
+			}
+		} else {
+			for _, d := range diagnostics {
+				fmt.Printf("%s:%s\n", d.File, d.Diagnostic)
+			}
+		}
+
+		if errCount > 0 {
+			sylog.Fatalf("%d error(s) found", errCount)
+		}
+	},
+
+	Use:     docs.DeffileLintUse,
+	Short:   docs.DeffileLintShort,
+	Long:    docs.DeffileLintLong,
+	Example: docs.DeffileLintExample,
+}
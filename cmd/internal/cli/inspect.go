@@ -7,6 +7,7 @@ package cli
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -38,6 +39,7 @@ var (
 	allData     bool
 	runscript   bool
 	startscript bool
+	healthcheck bool
 	testfile    bool
 	environment bool
 	helpfile    bool
@@ -45,6 +47,9 @@ var (
 	labels      bool
 	deffile     bool
 	jsonfmt     bool
+	sbomfmt     bool
+	provenance  bool
+	buildLog    bool
 )
 
 // -l|--labels
@@ -116,6 +121,15 @@ var inspectStartscriptFlag = cmdline.Flag{
 	Usage:        "show the startscript for the image",
 }
 
+// --healthcheck
+var inspectHealthcheckFlag = cmdline.Flag{
+	ID:           "inspectHealthcheckFlag",
+	Value:        &healthcheck,
+	DefaultValue: false,
+	Name:         "healthcheck",
+	Usage:        "show the healthcheck for the image",
+}
+
 // -t|--test
 var inspectTestFlag = cmdline.Flag{
 	ID:           "inspectTestFlag",
@@ -146,6 +160,33 @@ var inspectHelpfileFlag = cmdline.Flag{
 	Usage:        "inspect the runscript helpfile, if it exists",
 }
 
+// --sbom
+var inspectSBOMFlag = cmdline.Flag{
+	ID:           "inspectSBOMFlag",
+	Value:        &sbomfmt,
+	DefaultValue: false,
+	Name:         "sbom",
+	Usage:        "show the CycloneDX software bill of materials generated when the SIF image was built",
+}
+
+// --provenance
+var inspectProvenanceFlag = cmdline.Flag{
+	ID:           "inspectProvenanceFlag",
+	Value:        &provenance,
+	DefaultValue: false,
+	Name:         "provenance",
+	Usage:        "show the in-toto provenance statement recording the sources the SIF image was built from",
+}
+
+// --build-log
+var inspectBuildLogFlag = cmdline.Flag{
+	ID:           "inspectBuildLogFlag",
+	Value:        &buildLog,
+	DefaultValue: false,
+	Name:         "build-log",
+	Usage:        "show the output of the build scripts stored in the SIF image when it was built with --build-log",
+}
+
 // --all
 var inspectAllFlag = cmdline.Flag{
 	ID:           "inspectAllFlag",
@@ -167,9 +208,13 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&inspectLabelsFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectRunscriptFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectStartscriptFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectHealthcheckFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectTestFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectAppsListFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectAllFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectSBOMFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectProvenanceFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectBuildLogFlag, InspectCmd)
 	})
 }
 
@@ -310,6 +355,8 @@ func (c *command) setAttribute(section, value, file string) error {
 		}
 	case "startscript":
 		c.metadata.Data.Attributes.Startscript = value
+	case "healthcheck":
+		c.metadata.Data.Attributes.Healthcheck = value
 	case "environment":
 		if app != "" {
 			c.metadata.Data.Attributes.Apps[app].Environment[file] = value
@@ -463,6 +510,17 @@ func (c *command) addStartscriptCommand() {
 	}
 }
 
+func (c *command) addHealthcheckCommand() {
+	if c.sifMetadata == nil {
+		c.addSingleFileCommand("healthcheck", "healthcheck")
+		return
+	}
+
+	if c.appName == "" {
+		c.metadata.Attributes.Healthcheck = c.sifMetadata.Attributes.Healthcheck
+	}
+}
+
 func (c *command) addTestCommand() {
 	if c.sifMetadata == nil {
 		c.addSingleFileCommand("test", "test")
@@ -588,6 +646,54 @@ func inspectDeffilePartition(img *image.Image) (string, error) {
 	return string(data), nil
 }
 
+// printSIFJSONObject prints the JSON data object name of a SIF image,
+// what describes the object in error messages.
+func printSIFJSONObject(img *image.Image, name, what string) {
+	if img.Type != image.SIF {
+		sylog.Fatalf("Could not inspect %s: the %s is only available for SIF images", img.Path, what)
+	}
+
+	r, err := image.NewSectionReader(img, name, -1)
+	if err == image.ErrNoSection {
+		sylog.Fatalf("No %s found in %s", what, img.Path)
+	} else if err != nil {
+		sylog.Fatalf("While reading %s: %s", what, err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		sylog.Fatalf("While reading %s: %s", what, err)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "\t"); err != nil {
+		sylog.Fatalf("Could not format %s: %s", what, err)
+	}
+	fmt.Printf("%s\n", out.String())
+}
+
+// printSIFBuildLog prints the build log stored in a SIF image.
+func printSIFBuildLog(img *image.Image) {
+	if img.Type != image.SIF {
+		sylog.Fatalf("Could not inspect %s: the build log is only available for SIF images", img.Path)
+	}
+
+	r, err := image.NewSectionReader(img, image.SIFDescBuildLog, -1)
+	if err == image.ErrNoSection {
+		sylog.Fatalf("No build log found in %s, the image must be built with --build-log", img.Path)
+	} else if err != nil {
+		sylog.Fatalf("While reading build log: %s", err)
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		sylog.Fatalf("While reading build log: %s", err)
+	}
+	defer zr.Close()
+
+	if _, err := io.Copy(os.Stdout, zr); err != nil {
+		sylog.Fatalf("While reading build log: %s", err)
+	}
+}
+
 func printSortedApp(m map[string]*inspect.AppAttributes) {
 	sorted := make([]string, 0, len(m))
 	for k := range m {
@@ -612,7 +718,7 @@ func printSortedMap(m map[string]string, fn func(key string)) {
 
 // returns true if flags for other forms of information are unset.
 func defaultToLabels() bool {
-	return !(helpfile || deffile || runscript || startscript || testfile || environment || listApps)
+	return !(helpfile || deffile || runscript || startscript || healthcheck || testfile || environment || listApps)
 }
 
 // InspectCmd represents the 'inspect' command.
@@ -632,6 +738,19 @@ var InspectCmd = &cobra.Command{
 			sylog.Fatalf("Failed to open image %s: %s", args[0], err)
 		}
 
+		if sbomfmt {
+			printSIFJSONObject(img, image.SIFDescSBOMJSON, "SBOM")
+			return
+		}
+		if provenance {
+			printSIFJSONObject(img, image.SIFDescProvenanceJSON, "provenance")
+			return
+		}
+		if buildLog {
+			printSIFBuildLog(img)
+			return
+		}
+
 		if allData {
 			// display all data in JSON format only
 			jsonfmt = true
@@ -672,6 +791,13 @@ var InspectCmd = &cobra.Command{
 			}
 		}
 
+		if healthcheck || allData {
+			if AppName == "" {
+				sylog.Debugf("Inspection of healthcheck selected.")
+				inspectCmd.addHealthcheckCommand()
+			}
+		}
+
 		if testfile || allData {
 			sylog.Debugf("Inspection of test selected.")
 			inspectCmd.addTestCommand()
@@ -722,6 +848,9 @@ var InspectCmd = &cobra.Command{
 			if inspectData.Data.Attributes.Startscript != "" {
 				fmt.Printf("%s\n", inspectData.Data.Attributes.Startscript)
 			}
+			if inspectData.Data.Attributes.Healthcheck != "" {
+				fmt.Printf("%s\n", inspectData.Data.Attributes.Healthcheck)
+			}
 			if inspectData.Data.Attributes.Test != "" {
 				fmt.Printf("%s\n", inspectData.Data.Attributes.Test)
 			} else if appAttr != nil && appAttr.Test != "" {
@@ -0,0 +1,100 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&instanceLogsFollowFlag, instanceLogsCmd)
+		cmdManager.RegisterFlagForCmd(&instanceLogsTailFlag, instanceLogsCmd)
+		cmdManager.RegisterFlagForCmd(&instanceLogsSinceFlag, instanceLogsCmd)
+	})
+}
+
+// -f|--follow
+var instanceLogsFollow bool
+
+var instanceLogsFollowFlag = cmdline.Flag{
+	ID:           "instanceLogsFollowFlag",
+	Value:        &instanceLogsFollow,
+	DefaultValue: false,
+	Name:         "follow",
+	ShortHand:    "f",
+	Usage:        "print the lines appended to the logs until interrupted",
+}
+
+// --tail
+var instanceLogsTail int
+
+var instanceLogsTailFlag = cmdline.Flag{
+	ID:           "instanceLogsTailFlag",
+	Value:        &instanceLogsTail,
+	DefaultValue: -1,
+	Name:         "tail",
+	Usage:        "number of lines to print from the end of the logs, -1 prints all lines",
+	Tag:          "<lines>",
+}
+
+// --since
+var instanceLogsSince string
+
+var instanceLogsSinceFlag = cmdline.Flag{
+	ID:           "instanceLogsSinceFlag",
+	Value:        &instanceLogsSince,
+	DefaultValue: "",
+	Name:         "since",
+	Usage:        "print the lines written since a timestamp (e.g. 2021-06-01T15:04:05Z) or a relative duration (e.g. 30m)",
+	Tag:          "<time>",
+}
+
+// parseSince returns the time from which instance logs are printed,
+// s being either a RFC 3339 timestamp or a duration before now.
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// singularity instance logs
+var instanceLogsCmd = &cobra.Command{
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		since, err := parseSince(instanceLogsSince)
+		if err != nil {
+			sylog.Fatalf("Invalid --since value %q, must be a timestamp or a duration", instanceLogsSince)
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		err = singularity.PrintInstanceLogs(ctx, os.Stdout, args[0], instanceLogsTail, since, instanceLogsFollow)
+		if err != nil {
+			sylog.Fatalf("Could not show instance logs: %v", err)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.InstanceLogsUse,
+	Short:   docs.InstanceLogsShort,
+	Long:    docs.InstanceLogsLong,
+	Example: docs.InstanceLogsExample,
+}
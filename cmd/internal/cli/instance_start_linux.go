@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -8,6 +8,7 @@ package cli
 import (
 	"github.com/hpcng/singularity/docs"
 	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/internal/pkg/instance"
 	"github.com/hpcng/singularity/pkg/cmdline"
 	"github.com/hpcng/singularity/pkg/sylog"
 	"github.com/spf13/cobra"
@@ -16,6 +17,12 @@ import (
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterFlagForCmd(&instanceStartPidFileFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartRestartFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartHealthCmdFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartHealthIntervalFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartHealthRetriesFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartLogMaxSizeFlag, instanceStartCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStartLogMaxFilesFlag, instanceStartCmd)
 	})
 }
 
@@ -31,6 +38,78 @@ var instanceStartPidFileFlag = cmdline.Flag{
 	EnvKeys:      []string{"PID_FILE"},
 }
 
+// --restart
+var instanceStartRestart string
+
+var instanceStartRestartFlag = cmdline.Flag{
+	ID:           "instanceStartRestartFlag",
+	Value:        &instanceStartRestart,
+	DefaultValue: instance.RestartNo,
+	Name:         "restart",
+	Usage:        "restart policy of the instance start script: no, on-failure[:max] or always",
+	EnvKeys:      []string{"RESTART"},
+}
+
+// --health-cmd
+var instanceStartHealthCmd string
+
+var instanceStartHealthCmdFlag = cmdline.Flag{
+	ID:           "instanceStartHealthCmdFlag",
+	Value:        &instanceStartHealthCmd,
+	DefaultValue: "",
+	Name:         "health-cmd",
+	Usage:        "command run in the instance to check its health, overriding the image healthcheck",
+	EnvKeys:      []string{"HEALTH_CMD"},
+}
+
+// --health-interval
+var instanceStartHealthInterval string
+
+var instanceStartHealthIntervalFlag = cmdline.Flag{
+	ID:           "instanceStartHealthIntervalFlag",
+	Value:        &instanceStartHealthInterval,
+	DefaultValue: instance.DefaultHealthInterval.String(),
+	Name:         "health-interval",
+	Usage:        "interval between two health checks of the instance (e.g. 30s, 5m)",
+	EnvKeys:      []string{"HEALTH_INTERVAL"},
+}
+
+// --health-retries
+var instanceStartHealthRetries int
+
+var instanceStartHealthRetriesFlag = cmdline.Flag{
+	ID:           "instanceStartHealthRetriesFlag",
+	Value:        &instanceStartHealthRetries,
+	DefaultValue: instance.DefaultHealthRetries,
+	Name:         "health-retries",
+	Usage:        "number of consecutive health check failures before the instance is unhealthy",
+	EnvKeys:      []string{"HEALTH_RETRIES"},
+}
+
+// --log-max-size
+var instanceStartLogMaxSize string
+
+var instanceStartLogMaxSizeFlag = cmdline.Flag{
+	ID:           "instanceStartLogMaxSizeFlag",
+	Value:        &instanceStartLogMaxSize,
+	DefaultValue: instance.DefaultLogMaxSize,
+	Name:         "log-max-size",
+	Usage:        "size from which the instance log files are rotated (e.g. 512KiB, 10MiB), 0 disables the rotation",
+	EnvKeys:      []string{"LOG_MAX_SIZE"},
+}
+
+// --log-max-files
+var instanceStartLogMaxFiles int
+
+var instanceStartLogMaxFilesFlag = cmdline.Flag{
+	ID:           "instanceStartLogMaxFilesFlag",
+	Value:        &instanceStartLogMaxFiles,
+	DefaultValue: instance.DefaultLogMaxFiles,
+	Name:         "log-max-files",
+	Usage:        "number of rotated log files kept for each instance log file",
+	EnvKeys:      []string{"LOG_MAX_FILES"},
+}
+
 // singularity instance start
 var instanceStartCmd = &cobra.Command{
 	Args:                  cobra.MinimumNArgs(2),
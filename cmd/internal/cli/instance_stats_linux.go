@@ -0,0 +1,95 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hpcng/singularity/docs"
+	"github.com/hpcng/singularity/internal/app/singularity"
+	"github.com/hpcng/singularity/pkg/cmdline"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&instanceStatsUserFlag, instanceStatsCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStatsJSONFlag, instanceStatsCmd)
+		cmdManager.RegisterFlagForCmd(&instanceStatsWatchFlag, instanceStatsCmd)
+	})
+}
+
+// -u|--user
+var instanceStatsUser string
+
+var instanceStatsUserFlag = cmdline.Flag{
+	ID:           "instanceStatsUserFlag",
+	Value:        &instanceStatsUser,
+	DefaultValue: "",
+	Name:         "user",
+	ShortHand:    "u",
+	Usage:        `if running as root, show the resource usage of instances from "<username>"`,
+	Tag:          "<username>",
+	EnvKeys:      []string{"USER"},
+}
+
+// -j|--json
+var instanceStatsJSON bool
+
+var instanceStatsJSONFlag = cmdline.Flag{
+	ID:           "instanceStatsJSONFlag",
+	Value:        &instanceStatsJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print structured json instead of a table",
+	EnvKeys:      []string{"JSON"},
+}
+
+// -w|--watch
+var instanceStatsWatch bool
+
+var instanceStatsWatchFlag = cmdline.Flag{
+	ID:           "instanceStatsWatchFlag",
+	Value:        &instanceStatsWatch,
+	DefaultValue: false,
+	Name:         "watch",
+	ShortHand:    "w",
+	Usage:        "refresh the resource usage every second until interrupted",
+}
+
+// singularity instance stats
+var instanceStatsCmd = &cobra.Command{
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := "*"
+		if len(args) > 0 {
+			name = args[0]
+		}
+
+		uid := os.Getuid()
+		if instanceStatsUser != "" && uid != 0 {
+			sylog.Fatalf("Only root user can show user's instances resource usage")
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		err := singularity.PrintInstanceStats(ctx, os.Stdout, name, instanceStatsUser, instanceStatsJSON, instanceStatsWatch)
+		if err != nil {
+			sylog.Fatalf("Could not show instance stats: %v", err)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.InstanceStatsUse,
+	Short:   docs.InstanceStatsShort,
+	Long:    docs.InstanceStatsLong,
+	Example: docs.InstanceStatsExample,
+}
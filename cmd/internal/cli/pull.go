@@ -50,7 +50,7 @@ var (
 	// pullDir is the path that the containers will be pulled to, if set.
 	pullDir string
 	// pullArch is the architecture for which containers will be pulled from the
-	// SCS library.
+	// SCS library, or selected from multi-architecture OCI images.
 	pullArch string
 )
 
@@ -60,7 +60,7 @@ var pullArchFlag = cmdline.Flag{
 	Value:        &pullArch,
 	DefaultValue: runtime.GOARCH,
 	Name:         "arch",
-	Usage:        "architecture to pull from library",
+	Usage:        "architecture to pull from library or to select from multi-architecture OCI images",
 	EnvKeys:      []string{"PULL_ARCH"},
 }
 
@@ -266,7 +266,7 @@ func pullRun(cmd *cobra.Command, args []string) {
 			sylog.Fatalf("While creating Docker credentials: %v", err)
 		}
 
-		_, err = oci.PullToFile(ctx, imgCache, pullTo, pullFrom, tmpDir, ociAuth, noHTTPS, buildArgs.noCleanUp)
+		_, err = oci.PullToFile(ctx, imgCache, pullTo, pullFrom, pullArch, tmpDir, ociAuth, noHTTPS, buildArgs.noCleanUp)
 		if err != nil {
 			sylog.Fatalf("While making image from oci registry: %v", err)
 		}
@@ -45,6 +45,11 @@ Enterprise Performance Computing (EPC)`
   container, and then build it as a default Singularity image for production 
   use. The default format is immutable.
 
+  Default images embed a software bill of materials (SBOM) listing the packages
+  installed in the container, unless --no-sbom is set. rpm packages are listed
+  with the rpm command of the host, they are missing from the SBOM when rpm is
+  not installed on the host or can't read the rpm database of the container.
+
   BUILD SPEC:
 
   The build spec target is a definition (def) file, local image, or URI that can 
@@ -127,6 +132,10 @@ Enterprise Performance Computing (EPC)`
       %startscript
           echo "Define actions for container to perform when started as an instance."
 
+      %healthcheck
+          echo "Define a command checking the health of an instance, a zero exit"
+          echo "status means that the instance is healthy."
+
       %labels
           HELLO MOTO
           KEY VALUE
@@ -144,6 +153,12 @@ Enterprise Performance Computing (EPC)`
       %help
           This is a text file to be displayed with the run-help command.
 
+      %arguments
+          # Default values of the {{ name }} placeholders used in the header and
+          # sections, set other values with --build-arg name=value
+          VERSION=1.2.3
+          BASE="debian:10"
+
   COMMANDS:
 
       Build a sif file from a Singularity recipe file:
@@ -155,7 +170,26 @@ Enterprise Performance Computing (EPC)`
       Build a base sandbox from DockerHub, make changes to it, then build sif
           $ singularity build --sandbox /tmp/debian docker://debian:latest
           $ singularity exec --writable /tmp/debian apt-get install python
-          $ singularity build /tmp/debian2.sif /tmp/debian`
+          $ singularity build /tmp/debian2.sif /tmp/debian
+
+      Build a sif file from a Singularity recipe file with build arguments:
+          $ singularity build --build-arg VERSION=1.2.4 /tmp/app.sif /path/to/app.def
+
+      Build a sif file with a secret available at /run/secrets/npmrc during %post:
+          $ singularity build --secret id=npmrc,src=~/.npmrc /tmp/app.sif /path/to/app.def
+
+      Build the same sif file from a Singularity recipe file each time:
+          $ SOURCE_DATE_EPOCH=$(git log -1 --format=%ct) singularity build --reproducible /tmp/app.sif /path/to/app.def
+
+      Build a sif file storing the output of its build scripts, then show it:
+          $ singularity build --build-log /tmp/app.sif /path/to/app.def
+          $ singularity inspect --build-log /tmp/app.sif
+
+      Build a sif file without software bill of materials:
+          $ singularity build --no-sbom /tmp/app.sif /path/to/app.def
+
+      Build a sif file limiting the %post and %test scripts to 4GiB of memory and 2 CPUs:
+          $ singularity build --memory 4GiB --cpus 2 /tmp/app.sif /path/to/app.def`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Cache
@@ -179,7 +213,11 @@ Enterprise Performance Computing (EPC)`
 	CacheCleanLong  string = `
   This will clean your local cache (stored at $HOME/.singularity/cache if
   SINGULARITY_CACHEDIR is not set). By default the entire cache is cleaned, use
-  --days and --type flags to override this behavior. Note: if you use Singularity
+  --days and --type flags to override this behavior, or --max-size to only remove
+  the least recently used entries until the cache fits within a given size. The
+  cache can also be kept within a size limit automatically by setting
+  SINGULARITY_CACHE_MAXSIZE (e.g. 20GiB) and/or SINGULARITY_CACHE_TYPE_MAXSIZE
+  (e.g. library=5GiB,blob=10GiB). Note: if you use Singularity
   as root, cache will be stored in '/root/.singularity/.cache', to clean that
   cache, you will need to run 'cache clean' as root, or with 'sudo'.`
 	CacheCleanExample string = `
@@ -187,6 +225,7 @@ Enterprise Performance Computing (EPC)`
 
   $ singularity help cache clean --days 30
   $ singularity help cache clean --type=library,oci
+  $ singularity help cache clean --max-size 10GiB
   $ singularity cache clean --help`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -196,14 +235,135 @@ Enterprise Performance Computing (EPC)`
 	CacheListShort string = `List your local Singularity cache`
 	CacheListLong  string = `
   This will list your local cache (stored at $HOME/.singularity/cache if
-  SINGULARITY_CACHEDIR is not set).`
+  SINGULARITY_CACHEDIR is not set). Use --system to list the read-only system
+  cache shared by all users, when one is configured in singularity.conf.
+  Use --json to get every entry with its type, key, size, modification and
+  last access times, and the source it was pulled from, in JSON format.`
 	CacheListExample string = `
   All group commands have their own help output:
 
   $ singularity help cache list
   $ singularity help cache list --type=library,oci
+  $ singularity help cache list --system
+  $ singularity help cache list --json
   $ singularity cache list --help`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Cache Verify
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	CacheVerifyUse   string = `verify [verify options...]`
+	CacheVerifyShort string = `Verify the integrity of your local Singularity cache`
+	CacheVerifyLong  string = `
+  This will verify the integrity of your local cache (stored at
+  $HOME/.singularity/cache if SINGULARITY_CACHEDIR is not set). Library and
+  oras images are re-hashed against their cache key, OCI blobs against their
+  digest, and other images are checked for a complete SIF structure. Temporary
+  files left behind by interrupted downloads are reported as orphaned. Use
+  --remove to remove corrupted and orphaned files from the cache.
+
+  Cache entries can also be verified each time they are used, by setting
+  SINGULARITY_CACHE_VERIFY=1. Corrupted entries are then discarded and
+  downloaded again.`
+	CacheVerifyExample string = `
+  All group commands have their own help output:
+
+  $ singularity help cache verify
+  $ singularity help cache verify --type=library,blob
+  $ singularity cache verify --remove`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// deffile
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	DeffileUse   string = `deffile`
+	DeffileShort string = `Manage definition files`
+	DeffileLong  string = `
+  The deffile command allows checking and formatting definition files before
+  building them, and converting Dockerfiles into definition files.`
+	DeffileExample string = `
+  All deffile commands have their own help output:
+
+  $ singularity help deffile lint
+  $ singularity deffile fmt --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// deffile lint
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	DeffileLintUse   string = `lint [lint options...] <definition file>...`
+	DeffileLintShort string = `Check definition files for errors`
+	DeffileLintLong  string = `
+  The deffile lint command checks definition files without building them and
+  reports the problems found, one per line, as:
+
+    <file>:<line>: <severity>: <message>
+
+  Errors are problems which would make the build fail, like unknown sections
+  or header keywords, header keywords required by the bootstrap agent which
+  are missing, duplicate app sections, %files sources which don't exist on the
+  host, %files copied from undefined stages, placeholders of build arguments
+  not declared in %arguments, and shell syntax errors in scripts. Warnings are problems which don't prevent the build, like header
+  keywords ignored by the bootstrap agent or sections defined more than once.
+
+  %files sources are resolved relative to the current directory, like during
+  the build. The command exits with a non-zero status if errors are found.`
+	DeffileLintExample string = `
+  $ singularity deffile lint image.def
+  image.def:5: error: unknown section %postinstall
+
+  To print the diagnostics in JSON:
+  $ singularity deffile lint --json image.def`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// deffile fmt
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	DeffileFmtUse   string = `fmt [fmt options...] <definition file>...`
+	DeffileFmtShort string = `Format definition files`
+	DeffileFmtLong  string = `
+  The deffile fmt command formats definition files in canonical format and
+  prints the result. The values of the header keywords are aligned, the
+  sections of each stage are sorted in the order in which the build uses
+  them, followed by the sections of each app, and are separated by a blank
+  line. Comments and the contents of the sections are preserved, comments
+  just above a section move with it unless they end a %help section, where
+  they are part of the help text.
+
+  With --check, the names of the files which are not formatted are printed
+  instead, and the command exits with a non-zero status if there are any.
+  With --write, the files are formatted in place.`
+	DeffileFmtExample string = `
+  $ singularity deffile fmt image.def
+
+  To format definition files in place:
+  $ singularity deffile fmt --write image.def build.def
+
+  To check that definition files are formatted:
+  $ singularity deffile fmt --check *.def`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// deffile from-dockerfile
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	DeffileFromDockerfileUse   string = `from-dockerfile <Dockerfile>`
+	DeffileFromDockerfileShort string = `Convert a Dockerfile into a definition file`
+	DeffileFromDockerfileLong  string = `
+  The deffile from-dockerfile command converts a Dockerfile into a definition
+  file, printed in canonical format. Each FROM instruction starts a stage
+  bootstrapped from the docker image, named after its AS name:
+
+    RUN                 commands of %post
+    ENV                 exported in %environment and %post
+    ARG                 default values set in %post
+    COPY, ADD           %files, --from copies files from a previous stage
+    LABEL, MAINTAINER   %labels
+    WORKDIR             directory created in %post, and current directory of
+                        %post and %runscript
+    ENTRYPOINT, CMD     %runscript, CMD holding the default arguments
+
+  Instructions without equivalent, like USER, EXPOSE, VOLUME or HEALTHCHECK,
+  are ignored with a warning. Note that %files are copied before %post runs,
+  whatever the order of the COPY and RUN instructions.`
+	DeffileFromDockerfileExample string = `
+  $ singularity deffile from-dockerfile Dockerfile > image.def
+  $ singularity build image.sif image.def`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// key
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -534,6 +694,31 @@ Enterprise Performance Computing (EPC)`
   will be executed with the instance start command as well. You can optionally
   pass arguments to startscript
 
+  With --restart, the startscript is restarted once it exits, depending on the
+  restart policy:
+
+    no                  never restart the startscript (default)
+    on-failure[:max]    restart the startscript when it exits with a non zero
+                        status, at most max times if specified
+    always              always restart the startscript
+
+  Restarts are delayed with an exponential backoff, starting at 100ms and
+  capped at one minute. The number of restarts and the last exit status of
+  the startscript are reported by instance list.
+
+  If the container has a healthcheck, defined by the %healthcheck section of
+  its definition file, or with --health-cmd, the health of the instance is
+  checked every --health-interval by executing it in the instance. The
+  instance is healthy once the health check exits with a zero status, and
+  unhealthy after --health-retries consecutive failures, a health check still
+  running after --health-interval is killed and considered failed. The health
+  status (starting, healthy or unhealthy) is reported by instance list.
+
+  The standard output and error of the startscript are written, with a
+  timestamp for each line, to the instance log files shown by instance list
+  --logs and printed by instance logs. A log file is rotated before it exceeds
+  --log-max-size, keeping --log-max-files rotated log files.
+
   singularity instance start accepts the following container formats` + formats
 	InstanceStartExample string = `
   $ singularity instance start /tmp/my-sql.sif mysql
@@ -549,7 +734,13 @@ Enterprise Performance Computing (EPC)`
   Singularity my-sql.sif>
 
   $ singularity instance stop /tmp/my-sql.sif mysql
-  Stopping /tmp/my-sql.sif mysql`
+  Stopping /tmp/my-sql.sif mysql
+
+  $ singularity instance start --restart=on-failure:5 /tmp/my-sql.sif mysql
+
+  $ singularity instance start --health-cmd "mysqladmin ping" --health-interval 10s /tmp/my-sql.sif mysql
+
+  $ singularity instance start --log-max-size 1MiB --log-max-files 5 /tmp/my-sql.sif mysql`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// instance stop
@@ -576,6 +767,137 @@ Enterprise Performance Computing (EPC)`
   $ singularity instance stop -s TERM mysql1
   $ singularity instance stop -s 15 mysql1`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// instance stats
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	InstanceStatsUse   string = `stats [stats options...] [<instance name glob>]`
+	InstanceStatsShort string = `Show the resource usage of running instances`
+	InstanceStatsLong  string = `
+  The instance stats command shows the CPU, memory, processes and block I/O
+  usage of named instances started with --apply-cgroups, as reported by their
+  cgroup. The CPU usage is measured over one second. With --watch, the usage is
+  refreshed every second until interrupted.`
+	InstanceStatsExample string = `
+  $ sudo singularity instance stats mysql
+  INSTANCE NAME    PID      CPU %    MEM USAGE / LIMIT        MEM %    PIDS         BLOCK I/O
+  mysql            11963    2.35%    212.40 MiB / 1.00 GiB    20.74%   37 / 1024    1.20 MiB / 0.00 KiB
+
+  $ sudo singularity instance stats --watch
+
+  $ sudo singularity instance stats --json mysql`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// instance logs
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	InstanceLogsUse   string = `logs [logs options...] <instance name>`
+	InstanceLogsShort string = `Show the logs of a named instance`
+	InstanceLogsLong  string = `
+  The instance logs command prints the lines written by the startscript of a
+  named instance on its standard output and error streams, including the
+  rotated log files, merged in time order. Each line is printed with its
+  timestamp and its stream. The logs are kept once the instance is stopped.
+  With --follow, the lines written afterwards are printed until interrupted.`
+	InstanceLogsExample string = `
+  $ singularity instance logs mysql
+  2021-06-01T15:04:05.123456789+02:00 stdout mysqld is ready for connections
+  2021-06-01T15:04:05.234567891+02:00 stderr [Warning] insecure configuration
+
+  $ singularity instance logs --tail 10 -f mysql
+
+  $ singularity instance logs --since 1h mysql
+
+  $ singularity instance logs --since 2021-06-01T15:00:00Z mysql`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// compose
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	ComposeUse   string = `compose`
+	ComposeShort string = `Manage a set of instances described by a compose file`
+	ComposeLong  string = `
+  The compose commands start, stop and list together the instances described
+  by a YAML compose file (compose.yaml in the current directory by default):
+
+    name: myapp                 # prefix of the instance names, defaults to
+                                # the compose file directory name
+    instances:
+      db:                       # instance started as myapp-db
+        image: mysql.sif        # container image
+        args: [--port, "3306"]  # startscript arguments
+        binds:                  # bind paths, as with --bind
+          - data:/var/lib/mysql
+        env:                    # environment variables of the instance
+          MYSQL_DATABASE: app
+        network: bridge         # network types, as with --network
+        network-args:           # network arguments, as with --network-args
+          - portmap=3306:3306/tcp
+        cgroups:
+          file: db.toml         # cgroups configuration, as with --apply-cgroups
+          memory: 1GiB          # memory limit
+          cpus: 1.5             # number of CPUs
+          pids: 1024            # maximum number of processes
+        restart: on-failure:5   # restart policy, as with --restart
+        health-cmd: mysqladmin ping  # health check, as with --health-cmd
+        options: [--contain]    # additional instance start options
+      web:
+        image: nginx.sif
+        depends-on: [db]        # instances started before this one
+
+  Relative paths are relative to the directory of the compose file. Instances
+  are started after the instances they depend on, and stopped in the reverse
+  order.`
+	ComposeExample string = `
+  All group commands have their own help output:
+
+  $ singularity help compose up
+  $ singularity compose up --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// compose up
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	ComposeUpUse   string = `up [up options...]`
+	ComposeUpShort string = `Start the instances of a compose file`
+	ComposeUpLong  string = `
+  The compose up command starts the instances of the compose file which are not
+  already running, each instance being started once the instances it depends on
+  are started. If an instance fails to start, the instances started by the
+  command are stopped in the reverse order, instances which were already
+  running are left untouched.`
+	ComposeUpExample string = `
+  $ singularity compose up
+
+  $ sudo singularity compose up -f /srv/myapp/compose.yaml`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// compose down
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	ComposeDownUse   string = `down [down options...]`
+	ComposeDownShort string = `Stop the instances of a compose file`
+	ComposeDownLong  string = `
+  The compose down command stops the running instances of the compose file in
+  the reverse start order, so that an instance is stopped before the instances
+  it depends on.`
+	ComposeDownExample string = `
+  $ singularity compose down
+
+  Send SIGTERM to the instances and kill them after 30 seconds
+  $ singularity compose down -s TERM -t 30`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// compose ps
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	ComposePsUse   string = `ps [ps options...]`
+	ComposePsShort string = `List the instances of a compose file`
+	ComposePsLong  string = `
+  The compose ps command lists the instances of the compose file in start
+  order, with their state and, for running instances, their PID and health.`
+	ComposePsExample string = `
+  $ singularity compose ps
+  NAME    INSTANCE     STATE      PID      HEALTH     IMAGE
+  db      myapp-db     running    11963    healthy    /srv/myapp/mysql.sif
+  web     myapp-web    stopped    -        -          nginx.sif
+
+  $ singularity compose ps --json`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// pull
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -785,6 +1107,18 @@ Enterprise Performance Computing (EPC)`
   `
 	InspectExample string = `
   $ singularity inspect ubuntu.sif
+
+  The software bill of materials of the packages installed in a SIF image,
+  recorded at build time in the CycloneDX JSON format, is shown with:
+  $ singularity inspect --sbom ubuntu.sif
+
+  The provenance of a SIF image, recording the definition file, bootstrap
+  sources, host files and build arguments it was built from, is shown with:
+  $ singularity inspect --provenance ubuntu.sif
+
+  The output of the build scripts of a SIF image built with --build-log is
+  shown with:
+  $ singularity inspect --build-log ubuntu.sif
   
   If you want to list the applications (apps) installed in a container (located at
   /scif/apps) you should run inspect command with --list-apps <container-image> flag.
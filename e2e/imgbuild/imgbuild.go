@@ -7,6 +7,7 @@ package imgbuild
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
@@ -1471,6 +1472,90 @@ func (c imgBuildTests) testWritableTmpfs(t *testing.T) {
 	)
 }
 
+// buildLocalOCI checks that images are built from local OCI layouts and
+// archives without network access, selecting the requested platform from
+// multi-architecture images.
+func (c imgBuildTests) buildLocalOCI(t *testing.T) {
+	tt := []struct {
+		name      string
+		buildSpec string
+		arch      string
+		wantArch  string
+		exit      int
+	}{
+		{
+			name:      "oci layout amd64",
+			buildSpec: "oci:testdata/oci-multiarch",
+			arch:      "amd64",
+			wantArch:  "amd64",
+		},
+		{
+			name:      "oci layout arm64",
+			buildSpec: "oci:testdata/oci-multiarch",
+			arch:      "arm64",
+			wantArch:  "arm64",
+		},
+		{
+			name:      "oci layout name",
+			buildSpec: "oci:testdata/oci-multiarch:arm64",
+			arch:      runtime.GOARCH,
+			wantArch:  "arm64",
+		},
+		{
+			name:      "oci layout unknown platform",
+			buildSpec: "oci:testdata/oci-multiarch",
+			arch:      "s390x",
+			exit:      255,
+		},
+		{
+			name:      "oci archive arm64",
+			buildSpec: "oci-archive:testdata/oci-multiarch.tar",
+			arch:      "arm64",
+			wantArch:  "arm64",
+		},
+		{
+			name:      "oci archive name",
+			buildSpec: "oci-archive:testdata/oci-multiarch.tar:amd64",
+			arch:      runtime.GOARCH,
+			wantArch:  "amd64",
+		},
+		{
+			name:      "docker archive",
+			buildSpec: "docker-archive:testdata/docker-archive.tar",
+			arch:      runtime.GOARCH,
+			wantArch:  "arm64",
+		},
+	}
+
+	for _, tc := range tt {
+		dn, cleanup := c.tempDir(t, "local-oci-build")
+		defer cleanup()
+
+		sandbox := filepath.Join(dn, "sandbox")
+
+		c.env.RunSingularity(
+			t,
+			e2e.AsSubtest(tc.name),
+			e2e.WithProfile(e2e.UserProfile),
+			e2e.WithCommand("build"),
+			e2e.WithArgs("--sandbox", "--arch", tc.arch, sandbox, tc.buildSpec),
+			e2e.PostRun(func(t *testing.T) {
+				if t.Failed() || tc.exit != 0 {
+					return
+				}
+				b, err := ioutil.ReadFile(filepath.Join(sandbox, "arch"))
+				if err != nil {
+					t.Fatalf("could not read image architecture: %v", err)
+				}
+				if got := strings.TrimSpace(string(b)); got != tc.wantArch {
+					t.Errorf("got image for %s, want %s", got, tc.wantArch)
+				}
+			}),
+			e2e.ExpectExit(tc.exit),
+		)
+	}
+}
+
 // E2ETests is the main func to trigger the test suite
 func E2ETests(env e2e.TestEnv) testhelper.Tests {
 	c := imgBuildTests{
@@ -1484,6 +1569,7 @@ func E2ETests(env e2e.TestEnv) testhelper.Tests {
 		"definition":                      c.buildDefinition,           // builds from definition template
 		"from local image":                c.buildLocalImage,           // build and image from an existing image
 		"from":                            c.buildFrom,                 // builds from definition file and URI
+		"from local oci":                  c.buildLocalOCI,             // builds from local OCI layouts and archives
 		"multistage":                      c.buildMultiStageDefinition, // multistage build from definition templates
 		"non-root build":                  c.nonRootBuild,              // build sifs from non-root
 		"build and update sandbox":        c.buildUpdateSandbox,        // build/update sandbox
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -8,6 +8,7 @@ package singularity
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/hpcng/singularity/internal/pkg/cache"
 	"github.com/hpcng/singularity/pkg/sylog"
@@ -30,8 +31,10 @@ func cleanCache(imgCache *cache.Handle, cacheType string, dryRun bool, days int)
 // provide a summary of what would have been done. If cacheCleanTypes
 // contains something, only clean that type. The special value "all" is
 // interpreted as "all types of entries". If cacheName contains
-// something, clean only cache entries matching that name.
-func CleanSingularityCache(imgCache *cache.Handle, dryRun bool, cacheCleanTypes []string, days int) error {
+// something, clean only cache entries matching that name. If maxSize is
+// positive or zero, only the least recently used entries are removed until
+// the cleaned types fit within maxSize bytes, and days is ignored.
+func CleanSingularityCache(imgCache *cache.Handle, dryRun bool, cacheCleanTypes []string, days int, maxSize int64) error {
 	if imgCache == nil {
 		return errInvalidCacheHandle
 	}
@@ -45,6 +48,11 @@ func CleanSingularityCache(imgCache *cache.Handle, dryRun bool, cacheCleanTypes
 		cachesToClean = cacheCleanTypes
 	}
 
+	if maxSize >= 0 {
+		sylog.Debugf("Trimming %s caches to %d bytes...", strings.Join(cachesToClean, ", "), maxSize)
+		return imgCache.TrimCache(cachesToClean, maxSize, dryRun)
+	}
+
 	for _, cacheType := range cachesToClean {
 		sylog.Debugf("Cleaning %s cache...", cacheType)
 		if err := cleanCache(imgCache, cacheType, dryRun, days); err != nil {
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,6 +6,7 @@
 package singularity
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -140,3 +141,31 @@ func ListSingularityCache(imgCache *cache.Handle, cacheListTypes []string, cache
 
 	return nil
 }
+
+// ListSingularityCacheJSON will output the entries of the local singularity
+// cache as JSON, for the types specified by cacheListTypes. If
+// cacheListTypes contains the value "all", all the cache entries are
+// considered.
+func ListSingularityCacheJSON(imgCache *cache.Handle, cacheListTypes []string) error {
+	if imgCache == nil {
+		return errInvalidCacheHandle
+	}
+
+	cacheTypes := append(cache.OciCacheTypes, cache.FileCacheTypes...)
+	if len(cacheListTypes) > 0 && !slice.ContainsString(cacheListTypes, "all") {
+		cacheTypes = cacheListTypes
+	}
+
+	entries := []cache.EntryInfo{}
+	for _, cacheType := range cacheTypes {
+		typeEntries, err := imgCache.ListEntries(cacheType)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, typeEntries...)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
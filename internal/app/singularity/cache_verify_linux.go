@@ -0,0 +1,68 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/slice"
+)
+
+// VerifySingularityCache verifies the integrity of the cache entries for
+// the types specified by cacheVerifyTypes. The special value "all" is
+// interpreted as "all types of entries". Corrupted entries and orphaned
+// temporary files are reported, and removed if remove is true. An error is
+// returned if problems were found and left in the cache.
+func VerifySingularityCache(imgCache *cache.Handle, cacheVerifyTypes []string, remove bool) error {
+	if imgCache == nil {
+		return errInvalidCacheHandle
+	}
+
+	cachesToVerify := append(cache.OciCacheTypes, cache.FileCacheTypes...)
+	if len(cacheVerifyTypes) > 0 && !slice.ContainsString(cacheVerifyTypes, "all") {
+		cachesToVerify = cacheVerifyTypes
+	}
+
+	results, err := imgCache.VerifyCache(cachesToVerify, remove)
+	if err != nil {
+		return err
+	}
+
+	checked, problems, remaining := 0, 0, 0
+	for _, r := range results {
+		switch r.Status {
+		case cache.VerifyOK:
+			checked++
+			sylog.Debugf("Verified %s cache entry: %s", r.CacheType, filepath.Base(r.Path))
+		case cache.VerifyUnchecked:
+			sylog.Verbosef("Cannot verify %s cache entry: %s", r.CacheType, filepath.Base(r.Path))
+		case cache.VerifyCorrupt, cache.VerifyOrphaned:
+			checked++
+			problems++
+			action := ""
+			if r.Removed {
+				action = " (removed)"
+			} else {
+				remaining++
+			}
+			if r.Reason != nil {
+				fmt.Printf("%-10s %-8s %s: %v%s\n", r.Status, r.CacheType, filepath.Base(r.Path), r.Reason, action)
+			} else {
+				fmt.Printf("%-10s %-8s %s%s\n", r.Status, r.CacheType, filepath.Base(r.Path), action)
+			}
+		}
+	}
+
+	fmt.Printf("Verified %d cache file(s), %d problem(s) found\n", checked, problems)
+
+	if remaining > 0 {
+		return fmt.Errorf("%d corrupted or orphaned cache file(s) remain, use --remove to remove them", remaining)
+	}
+	return nil
+}
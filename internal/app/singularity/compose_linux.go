@@ -0,0 +1,266 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
+	"github.com/hpcng/singularity/internal/pkg/compose"
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/util/env"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+type composeInstanceInfo struct {
+	Name     string `json:"name"`
+	Instance string `json:"instance"`
+	State    string `json:"state"`
+	Pid      int    `json:"pid,omitempty"`
+	Health   string `json:"health,omitempty"`
+	Image    string `json:"image"`
+}
+
+// composeRollbackTimeout is the grace period given to the instances
+// stopped when compose up fails.
+const composeRollbackTimeout = 10 * time.Second
+
+// ComposeUp starts the instances of the compose file c which are not
+// running yet, each instance being started once the instances it depends
+// on are started. If an instance fails to start, the instances started by
+// this call are stopped in the reverse start order, the instances already
+// running are left untouched.
+func ComposeUp(c *compose.Config) (err error) {
+	order, err := c.StartOrder()
+	if err != nil {
+		return err
+	}
+
+	var started []string
+	defer func() {
+		if err != nil {
+			rollbackComposeUp(started)
+		}
+	}()
+
+	for _, name := range order {
+		instanceName := c.InstanceName(name)
+
+		file, err := getComposeInstance(instanceName)
+		if err != nil {
+			return err
+		} else if file != nil {
+			sylog.Infof("Instance %s is already running", instanceName)
+			continue
+		}
+
+		if err := startComposeInstance(c, name); err != nil {
+			return fmt.Errorf("could not start instance %s: %v", instanceName, err)
+		}
+		started = append(started, instanceName)
+	}
+	return nil
+}
+
+// rollbackComposeUp stops the instances started by a failed compose up,
+// in the reverse start order.
+func rollbackComposeUp(started []string) {
+	for i := len(started) - 1; i >= 0; i-- {
+		sylog.Infof("Stopping instance %s started before the failure", started[i])
+		if err := StopInstance(started[i], "", syscall.SIGINT, composeRollbackTimeout); err != nil {
+			sylog.Warningf("Could not stop instance %s: %v", started[i], err)
+		}
+	}
+}
+
+// ComposeDown stops the running instances of the compose file c in the
+// reverse start order, so that instances are stopped before the instances
+// they depend on. Each instance is stopped like StopInstance does, with the
+// signal sig and a grace period defined by timeout.
+func ComposeDown(c *compose.Config, sig syscall.Signal, timeout time.Duration) error {
+	order, err := c.StartOrder()
+	if err != nil {
+		return err
+	}
+
+	for i := len(order) - 1; i >= 0; i-- {
+		instanceName := c.InstanceName(order[i])
+
+		file, err := getComposeInstance(instanceName)
+		if err != nil {
+			return err
+		} else if file == nil {
+			continue
+		}
+
+		if err := StopInstance(instanceName, "", sig, timeout); err != nil {
+			return fmt.Errorf("could not stop instance %s: %v", instanceName, err)
+		}
+	}
+	return nil
+}
+
+// PrintComposeInstances prints the state of the instances of the compose
+// file c, in start order, in a regular or a JSON format (if formatJSON is
+// true) to the passed writer.
+func PrintComposeInstances(w io.Writer, c *compose.Config, formatJSON bool) error {
+	order, err := c.StartOrder()
+	if err != nil {
+		return err
+	}
+
+	instances := make([]composeInstanceInfo, len(order))
+	for n, name := range order {
+		instanceName := c.InstanceName(name)
+
+		file, err := getComposeInstance(instanceName)
+		if err != nil {
+			return err
+		}
+
+		instances[n] = composeInstanceInfo{
+			Name:     name,
+			Instance: instanceName,
+			State:    "stopped",
+			Image:    c.Instances[name].Image,
+		}
+		if file != nil {
+			instances[n].State = "running"
+			instances[n].Pid = file.Pid
+			instances[n].Health = file.Health
+			instances[n].Image = file.Image
+		}
+	}
+
+	if formatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		err := enc.Encode(map[string][]composeInstanceInfo{"instances": instances})
+		if err != nil {
+			return fmt.Errorf("could not encode instance list: %v", err)
+		}
+		return nil
+	}
+
+	tabWriter := tabwriter.NewWriter(w, 0, 8, 4, ' ', 0)
+
+	_, err = fmt.Fprintln(tabWriter, "NAME\tINSTANCE\tSTATE\tPID\tHEALTH\tIMAGE")
+	if err != nil {
+		return fmt.Errorf("could not write list header: %v", err)
+	}
+
+	for _, i := range instances {
+		pid, health := "-", "-"
+		if i.Pid > 0 {
+			pid = fmt.Sprintf("%d", i.Pid)
+		}
+		if i.Health != "" {
+			health = i.Health
+		}
+		_, err = fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\t%s\t%s\n", i.Name, i.Instance, i.State, pid, health, i.Image)
+		if err != nil {
+			return fmt.Errorf("could not write instance info: %v", err)
+		}
+	}
+
+	return tabWriter.Flush()
+}
+
+// getComposeInstance returns the instance file of the running instance
+// name, or nil if it's not running.
+func getComposeInstance(name string) (*instance.File, error) {
+	ii, err := instance.List("", name, instance.SingSubDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	if len(ii) == 0 {
+		return nil, nil
+	}
+	return ii[0], nil
+}
+
+// startComposeInstance starts the instance name of the compose file c
+// with the instance start command, executed from the directory of the
+// compose file so that relative paths are relative to it.
+func startComposeInstance(c *compose.Config, name string) error {
+	i := c.Instances[name]
+
+	args := []string{"instance", "start"}
+	for _, bind := range i.Binds {
+		args = append(args, "--bind", bind)
+	}
+	if i.Network != "" || len(i.NetworkArgs) > 0 {
+		args = append(args, "--net")
+	}
+	if i.Network != "" {
+		args = append(args, "--network", i.Network)
+	}
+	for _, arg := range i.NetworkArgs {
+		args = append(args, "--network-args", arg)
+	}
+	if i.Restart != "" {
+		args = append(args, "--restart", i.Restart)
+	}
+	if i.HealthCmd != "" {
+		args = append(args, "--health-cmd", i.HealthCmd)
+	}
+
+	if i.Cgroups != nil {
+		config, err := i.Cgroups.Config(c.Dir)
+		if err != nil {
+			return err
+		}
+		// the configuration is only read while the instance starts
+		f, err := ioutil.TempFile("", "compose-cgroups-")
+		if err != nil {
+			return fmt.Errorf("could not create cgroups configuration: %v", err)
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+
+		if err := cgroups.PutConfig(*config, f.Name()); err != nil {
+			return fmt.Errorf("could not write cgroups configuration: %v", err)
+		}
+		args = append(args, "--apply-cgroups", f.Name())
+	}
+
+	args = append(args, i.Options...)
+	args = append(args, i.Image, c.InstanceName(name))
+	args = append(args, i.Args...)
+
+	// environment variables are passed with the SINGULARITYENV_ prefix
+	// to preserve their values as is
+	environ := os.Environ()
+	keys := make([]string, 0, len(i.Env))
+	for k := range i.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		environ = append(environ, env.SingularityEnvPrefix+k+"="+i.Env[k])
+	}
+
+	sylog.Debugf("Starting instance %s with arguments %v", c.InstanceName(name), args)
+
+	cmd := exec.Command(filepath.Join(buildcfg.BINDIR, "singularity"), args...)
+	cmd.Dir = c.Dir
+	cmd.Env = environ
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
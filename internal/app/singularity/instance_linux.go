@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -26,6 +26,10 @@ type instanceInfo struct {
 	IP         string `json:"ip"`
 	LogErrPath string `json:"logErrPath"`
 	LogOutPath string `json:"logOutPath"`
+	Restart    string `json:"restart,omitempty"`
+	Restarts   int    `json:"restarts"`
+	ExitCode   *int   `json:"exitCode,omitempty"`
+	Health     string `json:"health,omitempty"`
 }
 
 // PrintInstanceList fetches instance list, applying name and
@@ -61,13 +65,23 @@ func PrintInstanceList(w io.Writer, name, user string, formatJSON bool, showLogs
 	}
 
 	if !formatJSON {
-		_, err := fmt.Fprintln(tabWriter, "INSTANCE NAME\tPID\tIP\tIMAGE")
+		_, err := fmt.Fprintln(tabWriter, "INSTANCE NAME\tPID\tIP\tIMAGE\tRESTARTS\tLAST EXIT\tHEALTH")
 		if err != nil {
 			return fmt.Errorf("could not write list header: %v", err)
 		}
 
 		for _, i := range ii {
-			_, err = fmt.Fprintf(tabWriter, "%s\t%d\t%s\t%s\n", i.Name, i.Pid, i.IP, i.Image)
+			// the last exit status is only known once the start
+			// script exited
+			lastExit := "-"
+			if i.ExitCode != nil {
+				lastExit = fmt.Sprintf("%d", *i.ExitCode)
+			}
+			health := i.Health
+			if health == "" {
+				health = "-"
+			}
+			_, err = fmt.Fprintf(tabWriter, "%s\t%d\t%s\t%s\t%d\t%s\t%s\n", i.Name, i.Pid, i.IP, i.Image, i.Restarts, lastExit, health)
 			if err != nil {
 				return fmt.Errorf("could not write instance info: %v", err)
 			}
@@ -83,6 +97,10 @@ func PrintInstanceList(w io.Writer, name, user string, formatJSON bool, showLogs
 		instances[i].IP = ii[i].IP
 		instances[i].LogErrPath = ii[i].LogErrPath
 		instances[i].LogOutPath = ii[i].LogOutPath
+		instances[i].Restart = ii[i].Restart
+		instances[i].Restarts = ii[i].Restarts
+		instances[i].ExitCode = ii[i].ExitCode
+		instances[i].Health = ii[i].Health
 	}
 
 	enc := json.NewEncoder(w)
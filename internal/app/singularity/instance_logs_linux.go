@@ -0,0 +1,275 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+)
+
+// logsFollowInterval is the interval between two reads of the instance
+// log files when following them.
+const logsFollowInterval = 500 * time.Millisecond
+
+// logFollower reads the lines appended to an instance log file, across
+// its rotations.
+type logFollower struct {
+	path   string
+	stream string
+	file   *os.File
+	// partial is the last line read, not terminated yet.
+	partial  []byte
+	previous time.Time
+}
+
+// read returns the complete lines appended to the log file since the
+// previous read, or all the lines if flush is true.
+func (f *logFollower) read(flush bool) ([]instance.LogLine, error) {
+	var data []byte
+
+	for {
+		if f.file != nil {
+			b, err := ioutil.ReadAll(f.file)
+			if err != nil {
+				return nil, fmt.Errorf("while reading %s: %s", f.path, err)
+			}
+			data = append(data, b...)
+		}
+
+		// the log file was rotated or created since the previous read,
+		// continue with the new one once the previous one is read
+		fi, err := os.Stat(f.path)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if f.file != nil {
+			cfi, err := f.file.Stat()
+			if err == nil && os.SameFile(fi, cfi) {
+				break
+			}
+			f.file.Close()
+			// read the log files rotated after the one just read
+			if err == nil {
+				b, err := readRotatedSince(f.path, cfi)
+				if err != nil {
+					return nil, err
+				}
+				data = append(data, b...)
+			}
+		}
+		if f.file, err = os.Open(f.path); err != nil {
+			return nil, err
+		}
+	}
+
+	data = append(f.partial, data...)
+	f.partial = nil
+	if !flush {
+		i := bytes.LastIndexByte(data, '\n')
+		f.partial = append(f.partial, data[i+1:]...)
+		data = data[:i+1]
+	}
+
+	lines, err := instance.ReadLogLines(bytes.NewReader(data), f.stream, f.previous)
+	if len(lines) > 0 {
+		f.previous = lines[len(lines)-1].Time
+	}
+	return lines, err
+}
+
+// readRotatedSince returns the content of the rotated log files of the
+// log file path more recent than the rotated log file fi.
+func readRotatedSince(path string, fi os.FileInfo) ([]byte, error) {
+	paths := instance.LogFilePaths(path)
+
+	var data []byte
+	found := false
+	for _, p := range paths[:len(paths)-1] {
+		if !found {
+			rfi, err := os.Stat(p)
+			found = err == nil && os.SameFile(fi, rfi)
+			continue
+		}
+		b, err := ioutil.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+func (f *logFollower) close() {
+	if f.file != nil {
+		f.file.Close()
+	}
+}
+
+// newLogFollower returns a follower of the log file path of stream and the
+// lines of its rotated log files, from the oldest to the most recent.
+func newLogFollower(path, stream string) (*logFollower, []instance.LogLine, error) {
+	var lines []instance.LogLine
+
+	f := &logFollower{path: path, stream: stream}
+	// the current log file is opened first to not miss the lines
+	// written if it's rotated while the rotated log files are read
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	f.file = file
+
+	paths := instance.LogFilePaths(path)
+	for _, p := range paths[:len(paths)-1] {
+		r, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			f.close()
+			return nil, nil, err
+		}
+		l, err := instance.ReadLogLines(r, stream, f.previous)
+		r.Close()
+		if err != nil {
+			f.close()
+			return nil, nil, fmt.Errorf("while reading %s: %s", p, err)
+		}
+		if len(l) > 0 {
+			f.previous = l[len(l)-1].Time
+		}
+		lines = append(lines, l...)
+	}
+
+	return f, lines, nil
+}
+
+// PrintInstanceLogs prints the lines of the standard output and error log
+// files of the instance name, merged by time, to the passed writer. Only
+// the lines written from since, if not zero, are printed, and only the
+// last tail lines if tail is positive or zero. When follow is true, the
+// lines appended to the log files are printed until ctx is done.
+func PrintInstanceLogs(ctx context.Context, w io.Writer, name string, tail int, since time.Time, follow bool) error {
+	if err := instance.CheckName(name); err != nil {
+		return err
+	}
+
+	logErrPath, logOutPath, err := instance.GetLogFilePaths(name, instance.LogSubDir)
+	if err != nil {
+		return fmt.Errorf("could not determine instance log files: %v", err)
+	}
+
+	var lines []instance.LogLine
+	var followers []*logFollower
+	defer func() {
+		for _, f := range followers {
+			f.close()
+		}
+	}()
+
+	found := false
+	for _, l := range []struct {
+		path   string
+		stream string
+	}{
+		{logOutPath, instance.StdoutStream},
+		{logErrPath, instance.StderrStream},
+	} {
+		f, rotated, err := newLogFollower(l.path, l.stream)
+		if err != nil {
+			return fmt.Errorf("could not read instance log file: %v", err)
+		}
+		followers = append(followers, f)
+		found = found || f.file != nil || len(rotated) > 0
+
+		current, err := f.read(!follow)
+		if err != nil {
+			return fmt.Errorf("could not read instance log file: %v", err)
+		}
+		lines = append(lines, rotated...)
+		lines = append(lines, current...)
+	}
+	if !found {
+		return fmt.Errorf("no logs found for instance %s", name)
+	}
+
+	lines = filterLogLines(lines, since)
+	if tail >= 0 && len(lines) > tail {
+		lines = lines[len(lines)-tail:]
+	}
+	if err := writeLogLines(w, lines); err != nil {
+		return err
+	}
+
+	if !follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(logsFollowInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		lines = lines[:0]
+		for _, f := range followers {
+			l, err := f.read(false)
+			if err != nil {
+				return fmt.Errorf("could not read instance log file: %v", err)
+			}
+			lines = append(lines, l...)
+		}
+		if err := writeLogLines(w, filterLogLines(lines, since)); err != nil {
+			return err
+		}
+	}
+}
+
+// filterLogLines sorts the lines by time and returns the lines written
+// from since, if not zero.
+func filterLogLines(lines []instance.LogLine, since time.Time) []instance.LogLine {
+	// lines of each stream are already sorted, and lines without
+	// timestamp keep their position
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Time.Before(lines[j].Time)
+	})
+	if since.IsZero() {
+		return lines
+	}
+	i := sort.Search(len(lines), func(i int) bool {
+		return !lines[i].Time.Before(since)
+	})
+	return lines[i:]
+}
+
+func writeLogLines(w io.Writer, lines []instance.LogLine) error {
+	for _, l := range lines {
+		// lines written before instance logs were timestamped
+		t := "-"
+		if !l.Time.IsZero() {
+			t = l.Time.Local().Format(time.RFC3339Nano)
+		}
+		if _, err := fmt.Fprintf(w, "%s %s %s\n", t, l.Stream, l.Text); err != nil {
+			return fmt.Errorf("could not write instance log: %v", err)
+		}
+	}
+	return nil
+}
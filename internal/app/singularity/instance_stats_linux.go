@@ -0,0 +1,169 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// statsInterval is the interval between two samples of the instances
+// resource usage, used to compute their CPU usage percentage.
+const statsInterval = time.Second
+
+type instanceStats struct {
+	Instance   string  `json:"instance"`
+	Pid        int     `json:"pid"`
+	CPUPercent float64 `json:"cpuPercent"`
+	cgroups.Stats
+}
+
+type statsInstance struct {
+	file    *instance.File
+	manager cgroups.Manager
+	// last is the previous sample of the instance resource usage.
+	last     *cgroups.Stats
+	lastTime time.Time
+}
+
+// PrintInstanceStats fetches instance list, applying name and user
+// filters, and prints the resource usage of their cgroup in a regular or
+// a JSON format (if formatJSON is true) to the passed writer. When watch
+// is true, the resource usage is printed every second until ctx is done.
+func PrintInstanceStats(ctx context.Context, w io.Writer, name, user string, formatJSON, watch bool) error {
+	ii, err := instance.List(user, name, instance.SingSubDir)
+	if err != nil {
+		return fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	if len(ii) == 0 {
+		return fmt.Errorf("no instance found")
+	}
+
+	var instances []*statsInstance
+	for _, i := range ii {
+		if !i.Cgroup {
+			sylog.Warningf("Instance %s was not started with --apply-cgroups, no resource usage available", i.Name)
+			continue
+		}
+		manager, err := cgroups.GetManagerFromPid(i.Pid)
+		if err != nil {
+			return fmt.Errorf("could not find cgroup of instance %s: %v", i.Name, err)
+		}
+		instances = append(instances, &statsInstance{file: i, manager: manager})
+	}
+	if len(instances) == 0 {
+		return fmt.Errorf("no instance with a cgroup found")
+	}
+
+	// the first sample is the reference of the CPU usage
+	if _, err := sampleInstanceStats(instances); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		stats, err := sampleInstanceStats(instances)
+		if err != nil {
+			return err
+		}
+
+		if formatJSON {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "\t")
+			err = enc.Encode(map[string][]instanceStats{"instances": stats})
+		} else {
+			if watch {
+				// clear the terminal before printing the new usage
+				fmt.Fprint(w, "\033[H\033[2J")
+			}
+			err = writeInstanceStats(w, stats)
+		}
+		if err != nil {
+			return fmt.Errorf("could not write instance stats: %v", err)
+		}
+
+		if !watch {
+			return nil
+		}
+	}
+}
+
+// sampleInstanceStats reads the resource usage of the instances, CPU usage
+// percentages are computed since their previous sample.
+func sampleInstanceStats(instances []*statsInstance) ([]instanceStats, error) {
+	stats := make([]instanceStats, len(instances))
+
+	for n, i := range instances {
+		s, err := i.manager.GetStats()
+		now := time.Now()
+		if err != nil {
+			return nil, fmt.Errorf("could not read resource usage of instance %s: %v", i.file.Name, err)
+		}
+
+		stats[n] = instanceStats{
+			Instance: i.file.Name,
+			Pid:      i.file.Pid,
+			Stats:    *s,
+		}
+		if elapsed := now.Sub(i.lastTime); i.last != nil && elapsed > 0 && s.CPUUsage >= i.last.CPUUsage {
+			stats[n].CPUPercent = float64(s.CPUUsage-i.last.CPUUsage) / float64(elapsed.Nanoseconds()) * 100
+		}
+		i.last = s
+		i.lastTime = now
+	}
+
+	return stats, nil
+}
+
+func writeInstanceStats(w io.Writer, stats []instanceStats) error {
+	tabWriter := tabwriter.NewWriter(w, 0, 8, 4, ' ', 0)
+
+	_, err := fmt.Fprintln(tabWriter, "INSTANCE NAME\tPID\tCPU %\tMEM USAGE / LIMIT\tMEM %\tPIDS\tBLOCK I/O")
+	if err != nil {
+		return err
+	}
+
+	for _, s := range stats {
+		memLimit, memPercent := "-", "-"
+		if s.MemoryLimit > 0 {
+			memLimit = fs.FindSize(int64(s.MemoryLimit))
+			memPercent = fmt.Sprintf("%.2f%%", float64(s.MemoryUsage)/float64(s.MemoryLimit)*100)
+		}
+		pids := fmt.Sprintf("%d", s.Pids)
+		if s.PidsLimit > 0 {
+			pids = fmt.Sprintf("%d / %d", s.Pids, s.PidsLimit)
+		}
+
+		_, err = fmt.Fprintf(tabWriter, "%s\t%d\t%.2f%%\t%s / %s\t%s\t%s\t%s / %s\n",
+			s.Instance, s.Pid, s.CPUPercent,
+			fs.FindSize(int64(s.MemoryUsage)), memLimit, memPercent,
+			pids,
+			fs.FindSize(int64(s.IORead)), fs.FindSize(int64(s.IOWrite)),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tabWriter.Flush()
+}
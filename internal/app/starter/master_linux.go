@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -109,6 +109,16 @@ func startContainer(ctx context.Context, masterSocket int, containerPid int, e *
 		fatalChan <- fmt.Errorf("post start process failed: %s", err)
 		return
 	}
+
+	// special path for engines which keep the master socket open to
+	// report events about the container process once started
+	if obj, ok := e.Operations.(interface {
+		MonitorInstance(io.Reader) error
+	}); ok {
+		if err := obj.MonitorInstance(conn); err != nil {
+			sylog.Warningf("Instance monitoring failed: %s", err)
+		}
+	}
 }
 
 // Master initializes a runtime engine and runs it.
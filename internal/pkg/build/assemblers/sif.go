@@ -7,7 +7,9 @@ package assemblers
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"regexp"
@@ -16,6 +18,7 @@ import (
 	"strconv"
 	"syscall"
 
+	"github.com/google/uuid"
 	"github.com/hpcng/sif/v2/pkg/sif"
 	"github.com/hpcng/singularity/internal/pkg/image/packer"
 	"github.com/hpcng/singularity/internal/pkg/util/crypt"
@@ -38,11 +41,63 @@ type encryptionOptions struct {
 	plaintext []byte
 }
 
+// reproducibleID returns an image ID derived from the content of the data
+// objects of the image, so that reproducible builds get the same ID.
+func reproducibleID(b *types.Bundle, squashfile string) (string, error) {
+	h := sha256.New()
+	h.Write(b.Recipe.Raw)
+
+	names := make([]string, 0, len(b.JSONObjects))
+	for name := range b.JSONObjects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write(b.JSONObjects[name])
+	}
+
+	names = names[:0]
+	for name := range b.DataObjects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write(b.DataObjects[name])
+	}
+
+	f, err := os.Open(squashfile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return uuid.NewSHA1(uuid.Nil, h.Sum(nil)).String(), nil
+}
+
 func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encryptionOptions, arch string) (err error) {
 	var dis []sif.DescriptorInput
+	var objOpts []sif.DescriptorInputOpt
+	var createOpts []sif.CreateOpt
+
+	// reproducible builds get fixed creation times and image ID
+	if b.Opts.Reproducible {
+		t := b.BuildTime()
+		id, err := reproducibleID(b, squashfile)
+		if err != nil {
+			return fmt.Errorf("while computing image ID: %v", err)
+		}
+		objOpts = append(objOpts, sif.OptObjectTime(t))
+		createOpts = append(createOpts, sif.OptCreateWithTime(t), sif.OptCreateWithID(id))
+	}
 
 	// data we need to create a definition file descriptor
-	definput, err := sif.NewDescriptorInput(sif.DataDeffile, bytes.NewReader(b.Recipe.Raw))
+	definput, err := sif.NewDescriptorInput(sif.DataDeffile, bytes.NewReader(b.Recipe.Raw), objOpts...)
 	if err != nil {
 		return fmt.Errorf("sif id generation failed: %v", err)
 	}
@@ -61,7 +116,7 @@ func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encrypt
 		if len(b.JSONObjects[name]) > 0 {
 			// data we need to create a definition file descriptor
 			in, err := sif.NewDescriptorInput(sif.DataGenericJSON, bytes.NewReader(b.JSONObjects[name]),
-				sif.OptObjectName(name),
+				append(objOpts, sif.OptObjectName(name))...,
 			)
 			if err != nil {
 				return err
@@ -72,6 +127,25 @@ func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encrypt
 		}
 	}
 
+	// add all generic data object within SIF by alphabetical order
+	sorted = sorted[:0]
+	for name := range b.DataObjects {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		if len(b.DataObjects[name]) > 0 {
+			in, err := sif.NewDescriptorInput(sif.DataGeneric, bytes.NewReader(b.DataObjects[name]),
+				append(objOpts, sif.OptObjectName(name))...,
+			)
+			if err != nil {
+				return err
+			}
+			dis = append(dis, in)
+		}
+	}
+
 	// open up the data object file for this descriptor
 	fp, err := os.Open(squashfile)
 	if err != nil {
@@ -86,7 +160,7 @@ func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encrypt
 
 	// data we need to create a system partition descriptor
 	parinput, err := sif.NewDescriptorInput(sif.DataPartition, fp,
-		sif.OptPartitionMetadata(fs, sif.PartPrimSys, arch),
+		append(objOpts, sif.OptPartitionMetadata(fs, sif.PartPrimSys, arch))...,
 	)
 	if err != nil {
 		return err
@@ -119,7 +193,8 @@ func createSIF(path string, b *types.Bundle, squashfile string, encOpts *encrypt
 	os.RemoveAll(path)
 
 	// test container creation with two partition input descriptors
-	f, err := sif.CreateContainerAtPath(path, sif.OptCreateWithDescriptors(dis...))
+	createOpts = append(createOpts, sif.OptCreateWithDescriptors(dis...))
+	f, err := sif.CreateContainerAtPath(path, createOpts...)
 	if err != nil {
 		return fmt.Errorf("while creating container: %w", err)
 	}
@@ -169,6 +244,12 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 	if a.MksquashfsProcs != 0 {
 		flags = append(flags, "-processors", fmt.Sprint(a.MksquashfsProcs))
 	}
+	// use the same time for the filesystem and all the files of
+	// reproducible builds, this requires mksquashfs >= 4.4
+	if b.Opts.Reproducible {
+		epoch := strconv.FormatInt(b.BuildTime().Unix(), 10)
+		flags = append(flags, "-mkfs-time", epoch, "-all-time", epoch)
+	}
 	arch := machine.ArchFromContainer(b.RootfsPath)
 	if arch == "" {
 		sylog.Infof("Architecture not recognized, use native")
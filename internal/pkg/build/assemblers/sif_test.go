@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the URIs of this project regarding your
 // rights to use or distribute this software.
@@ -7,10 +7,15 @@ package assemblers_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/hpcng/singularity/internal/pkg/build/assemblers"
 	"github.com/hpcng/singularity/internal/pkg/build/sources"
@@ -134,3 +139,78 @@ func TestSIFAssemblerShub(t *testing.T) {
 
 	defer os.Remove(assemblerShubDest)
 }
+
+func fileDigest(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		t.Fatalf("could not read %s: %v", path, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// TestSIFAssemblerReproducible checks that building the same root filesystem
+// twice with reproducible builds gives identical SIF images
+func TestSIFAssemblerReproducible(t *testing.T) {
+	mksquashfsPath, err := exec.LookPath("mksquashfs")
+	if err != nil {
+		t.Skipf("could not find mksquashfs: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "sif-reproducible-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var digests []string
+	for i := 0; i < 2; i++ {
+		b, err := types.NewBundle(filepath.Join(dir, fmt.Sprintf("bundle%d", i)), dir)
+		if err != nil {
+			t.Fatalf("unable to make bundle: %v", err)
+		}
+		defer b.Remove()
+
+		b.Recipe.Raw = []byte("Bootstrap: scratch\n")
+		b.JSONObjects["oci-config"] = []byte(`{"Env":["PATH=/bin"]}`)
+		b.Opts.Reproducible = true
+		b.Opts.SourceDateEpoch = 1600000000
+
+		// files are created in a different order with different times
+		files := []string{"etc/hostname", "bin/app", "etc/hosts"}
+		if i == 1 {
+			files = []string{"etc/hosts", "bin/app", "etc/hostname"}
+		}
+		for _, f := range files {
+			path := filepath.Join(b.RootfsPath, f)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("could not create %s: %v", filepath.Dir(path), err)
+			}
+			if err := ioutil.WriteFile(path, []byte(f), 0o644); err != nil {
+				t.Fatalf("could not create %s: %v", path, err)
+			}
+		}
+
+		a := &assemblers.SIFAssembler{
+			MksquashfsPath: mksquashfsPath,
+		}
+
+		dest := filepath.Join(dir, fmt.Sprintf("image%d.sif", i))
+		if err := a.Assemble(b, dest); err != nil {
+			t.Fatalf("failed to assemble: %v", err)
+		}
+		digests = append(digests, fileDigest(t, dest))
+
+		// make sure the current time differs between the two builds
+		time.Sleep(1100 * time.Millisecond)
+	}
+
+	if digests[0] != digests[1] {
+		t.Errorf("images differ: %s != %s", digests[0], digests[1])
+	}
+}
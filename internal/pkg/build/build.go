@@ -1,4 +1,4 @@
-// Copyright (c) 2019-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2019-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/signal"
@@ -24,6 +25,7 @@ import (
 	"github.com/hpcng/singularity/internal/pkg/build/assemblers"
 	"github.com/hpcng/singularity/internal/pkg/build/sources"
 	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
 	"github.com/hpcng/singularity/internal/pkg/image/packer"
 	"github.com/hpcng/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/hpcng/singularity/internal/pkg/util/uri"
@@ -44,6 +46,12 @@ type Build struct {
 	stages []stage
 	// Conf contains cross stage build configuration.
 	Conf Config
+	// log captures the output of the stage scripts when the build log
+	// is stored in the image.
+	log *buildLog
+	// cgroups is the cgroups configuration applied to the %post and %test
+	// scripts, nil when the build is not constrained.
+	cgroups *cgroups.Config
 }
 
 // Config defines how build is executed, including things like where final image is written.
@@ -52,9 +60,34 @@ type Config struct {
 	Dest string
 	// Format is the format of built container, e.g. SIF, sandbox.
 	Format string
+	// Spec is the build specification, e.g. the path of the definition
+	// file, recorded in the build provenance.
+	Spec string
 	// NoCleanUp allows a user to prevent a bundle from being cleaned
 	// up after a failed build, useful for debugging.
 	NoCleanUp bool
+	// NoSBOM when true, disables the generation of the software bill of
+	// materials stored in the SIF image.
+	NoSBOM bool
+	// Jobs is the maximum number of stages built concurrently, stages are
+	// built one at a time when not set. Stages are always built one at a
+	// time when cgroups limits are set, as the limits apply to each stage.
+	Jobs int
+	// BuildLog when true, stores the compressed output of the stage scripts
+	// in the SIF image.
+	BuildLog bool
+	// BuildLogSize is the maximum size of the output stored in the build
+	// log, the remaining output is discarded. It defaults to 1MiB.
+	BuildLogSize int64
+	// CgroupsPath is the path of a cgroups configuration file applied to
+	// the %post and %test scripts of all stages.
+	CgroupsPath string
+	// Memory is the memory limit, in bytes, of the %post and %test scripts,
+	// it overrides the limit of the cgroups configuration file.
+	Memory int64
+	// CPUs is the number of CPUs available to the %post and %test scripts,
+	// it overrides the CPU quota of the cgroups configuration file.
+	CPUs float64
 	// Opts for bundles.
 	Opts types.Options
 }
@@ -65,6 +98,9 @@ func NewBuild(spec string, conf Config) (*Build, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse spec %v: %v", spec, err)
 	}
+	if conf.Spec == "" {
+		conf.Spec = spec
+	}
 
 	return newBuild([]types.Definition{def}, conf)
 }
@@ -93,6 +129,13 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 	b := &Build{
 		Conf: conf,
 	}
+	if conf.BuildLog {
+		b.log = newBuildLog(conf.BuildLogSize)
+	}
+	b.cgroups, err = cgroupsConfig(conf)
+	if err != nil {
+		return nil, err
+	}
 
 	// look if there is mount options set which could conflict
 	// with the build process like nodev and noexec
@@ -130,6 +173,13 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 		}
 		s.name = d.Header["stage"]
 		s.b.Recipe = d
+		s.stdout = os.Stdout
+		s.stderr = os.Stderr
+		if b.log != nil {
+			s.log = b.log
+			s.stdout = io.MultiWriter(os.Stdout, b.log)
+			s.stderr = io.MultiWriter(os.Stderr, b.log)
+		}
 
 		if conf.Format == "sandbox" && lastStageIndex == i {
 			// rootfs path changed during bundle creation it means that chown
@@ -371,117 +421,232 @@ func (b *Build) Full(ctx context.Context) error {
 	}
 	configData := buffer.Bytes()
 
-	// build each stage one after the other
-	for i, stage := range b.stages {
-		if err := stage.runSectionScript("pre", stage.b.Recipe.BuildData.Pre); err != nil {
-			return err
-		}
+	// the provenance records the sources before they are used
+	final := b.stages[len(b.stages)-1].b
+	var prov *provenance
+	if b.Conf.Format == "sif" {
+		prov = b.newProvenance(final.BuildTime())
+	}
 
-		// only update last stage if specified
-		update := stage.b.Opts.Update && !stage.b.Opts.Force && i == len(b.stages)-1
-		if update {
-			// updating, extract dest container to bundle
-			sylog.Infof("Building into existing container: %s", b.Conf.Dest)
-			p, err := sources.GetLocalPacker(ctx, b.Conf.Dest, stage.b)
+	// intermediate stages are cached, unless disabled, so that unchanged
+	// stages are not rebuilt
+	stageKeys := make([]string, len(b.stages))
+	if b.useStageCache() {
+		for i, stage := range b.stages[:len(b.stages)-1] {
+			key, err := b.stageKey(ctx, i, stageKeys)
 			if err != nil {
-				return err
+				sylog.Verbosef("Stage %s is not cached: %v", stage.name, err)
 			}
+			stageKeys[i] = key
+		}
+	}
 
-			_, err = p.Pack(ctx)
-			if err != nil {
-				return err
+	// build stages once the stages they copy files from are built,
+	// independent stages are built concurrently if requested
+	jobs := b.Conf.Jobs
+	if jobs <= 0 {
+		jobs = 1
+	}
+	// the limits apply to the scripts of each stage, concurrent stages
+	// would use more than the resources they grant
+	if jobs > 1 && b.cgroups != nil {
+		sylog.Infof("Building stages one at a time, as cgroups limits apply to each stage")
+		jobs = 1
+	}
+	if jobs > 1 && len(b.stages) > 1 {
+		for i := range b.stages {
+			b.stages[i].setOutputPrefix(i)
+		}
+		defer func() {
+			for i := range b.stages {
+				b.stages[i].flushOutput()
 			}
-		} else {
-			// regular build or force, start build from scratch
-			if b.Conf.Opts.ImgCache == nil {
-				return fmt.Errorf("undefined image cache")
+		}()
+	}
+	err = b.runStages(ctx, jobs, func(ctx context.Context, i int) error {
+		return b.buildStage(ctx, i, stageKeys[i], configData)
+	})
+	if err != nil {
+		return err
+	}
+
+	syscall.Umask(oldumask)
+
+	if b.Conf.Format == "sif" {
+		if b.log != nil {
+			for i := range b.stages {
+				b.stages[i].flushOutput()
 			}
-			if err := stage.c.Get(ctx, stage.b); err != nil {
-				return fmt.Errorf("conveyor failed to get: %v", err)
+			data, err := b.log.compress(final.BuildTime())
+			if err != nil {
+				return fmt.Errorf("while compressing build log: %v", err)
 			}
+			final.DataObjects[image.SIFDescBuildLog] = data
+		}
 
-			_, err := stage.c.Pack(ctx)
-			if err != nil {
-				return fmt.Errorf("packer failed to pack: %v", err)
+		if !b.Conf.NoSBOM {
+			sylog.Verbosef("Generating SBOM")
+			if err := insertSBOM(final); err != nil {
+				return fmt.Errorf("while inserting SBOM: %v", err)
 			}
 		}
+		if err := b.insertProvenance(ctx, prov, final.BuildTime()); err != nil {
+			return fmt.Errorf("while inserting provenance: %v", err)
+		}
+	}
 
-		// create apps in bundle
-		a := apps.New()
-		for k, v := range stage.b.Recipe.CustomData {
-			a.HandleSection(k, v)
+	if b.Conf.Opts.Reproducible {
+		sylog.Debugf("Setting file times to %s", final.BuildTime())
+		if err := normalizeTimes(final.RootfsPath, final.BuildTime()); err != nil {
+			return fmt.Errorf("while normalizing file times: %v", err)
 		}
+	}
+
+	sylog.Debugf("Calling assembler")
+	if err := b.stages[len(b.stages)-1].Assemble(b.Conf.Dest); err != nil {
+		return err
+	}
 
-		a.HandleBundle(stage.b)
-		appPost, err := a.HandlePost(stage.b)
+	sylog.Verbosef("Build complete: %s", b.Conf.Dest)
+	return nil
+}
+
+// buildStage builds the root filesystem of the stage at index i, key is
+// the cache key of the stage or an empty string if the stage isn't cached.
+func (b *Build) buildStage(ctx context.Context, i int, key string, configData []byte) error {
+	stage := &b.stages[i]
+
+	if key != "" {
+		cached, err := restoreStage(stage, key)
 		if err != nil {
-			return fmt.Errorf("unable to get app post information: %v", err)
+			return fmt.Errorf("while restoring cached stage %s: %v", stage.name, err)
 		}
-		stage.b.Recipe.BuildData.Post.Script += appPost
-
-		// copy potential files from previous stage
-		if stage.b.RunSection("files") {
-			if err := stage.copyFilesFrom(b); err != nil {
-				return fmt.Errorf("unable to copy files from stage to container fs: %v", err)
-			}
+		if cached {
+			sylog.Infof("%sUsing cached stage %s", stage.prefix, stage.name)
+			stage.logMarker("using cached stage %s", stage.name)
+			return nil
 		}
+	}
 
-		if err := stage.runSectionScript("setup", stage.b.Recipe.BuildData.Setup); err != nil {
-			return err
-		}
+	if err := stage.runSectionScript("pre", stage.b.Recipe.BuildData.Pre); err != nil {
+		return err
+	}
 
-		// copy files from host
-		if stage.b.RunSection("files") {
-			if err := stage.copyFiles(); err != nil {
-				return fmt.Errorf("unable to copy files from host to container fs: %v", err)
-			}
+	// only update last stage if specified
+	update := stage.b.Opts.Update && !stage.b.Opts.Force && i == len(b.stages)-1
+	if update {
+		// updating, extract dest container to bundle
+		sylog.Infof("%sBuilding into existing container: %s", stage.prefix, b.Conf.Dest)
+		p, err := sources.GetLocalPacker(ctx, b.Conf.Dest, stage.b)
+		if err != nil {
+			return err
 		}
 
-		// create stage file for /etc/resolv.conf and /etc/hosts
-		sessionResolv, err := createStageFile("/etc/resolv.conf", stage.b, "Name resolution could fail")
+		_, err = p.Pack(ctx)
 		if err != nil {
 			return err
-		} else if sessionResolv != "" {
-			defer os.Remove(sessionResolv)
 		}
-		sessionHosts, err := createStageFile("/etc/hosts", stage.b, "Host resolution could fail")
+	} else {
+		// regular build or force, start build from scratch
+		if b.Conf.Opts.ImgCache == nil {
+			return fmt.Errorf("undefined image cache")
+		}
+		if err := stage.c.Get(ctx, stage.b); err != nil {
+			return fmt.Errorf("conveyor failed to get: %v", err)
+		}
+		if d, ok := stage.c.(resolvedDigester); ok {
+			stage.sourceDigest = d.ResolvedDigest()
+		}
+
+		_, err := stage.c.Pack(ctx)
 		if err != nil {
-			return err
-		} else if sessionHosts != "" {
-			defer os.Remove(sessionHosts)
+			return fmt.Errorf("packer failed to pack: %v", err)
 		}
+	}
+
+	// create apps in bundle
+	a := apps.New()
+	for k, v := range stage.b.Recipe.CustomData {
+		a.HandleSection(k, v)
+	}
+
+	a.HandleBundle(stage.b)
+	appPost, err := a.HandlePost(stage.b)
+	if err != nil {
+		return fmt.Errorf("unable to get app post information: %v", err)
+	}
+	stage.b.Recipe.BuildData.Post.Script += appPost
 
-		// write the build configuration used for %post and %test sections
-		configFile := filepath.Join(stage.b.TmpDir, "singularity.conf")
-		if err := ioutil.WriteFile(configFile, configData, 0o644); err != nil {
-			return fmt.Errorf("while creating %s: %s", configFile, err)
+	// copy potential files from previous stage
+	if stage.b.RunSection("files") {
+		if err := stage.copyFilesFrom(b); err != nil {
+			return fmt.Errorf("unable to copy files from stage to container fs: %v", err)
 		}
-		defer os.Remove(configFile)
+	}
 
-		if stage.b.Recipe.BuildData.Post.Script != "" {
-			if err := stage.runPostScript(configFile, sessionResolv, sessionHosts); err != nil {
-				return fmt.Errorf("while running engine: %v", err)
-			}
+	if err := stage.runSectionScript("setup", stage.b.Recipe.BuildData.Setup); err != nil {
+		return err
+	}
+
+	// copy files from host
+	if stage.b.RunSection("files") {
+		if err := stage.copyFiles(); err != nil {
+			return fmt.Errorf("unable to copy files from host to container fs: %v", err)
 		}
+	}
 
-		sylog.Debugf("Inserting Metadata")
-		if err := stage.insertMetadata(); err != nil {
-			return fmt.Errorf("while inserting metadata to bundle: %v", err)
+	// create stage file for /etc/resolv.conf and /etc/hosts
+	sessionResolv, err := createStageFile("/etc/resolv.conf", stage.b, stage.prefix+"Name resolution could fail")
+	if err != nil {
+		return err
+	} else if sessionResolv != "" {
+		defer os.Remove(sessionResolv)
+	}
+	sessionHosts, err := createStageFile("/etc/hosts", stage.b, stage.prefix+"Host resolution could fail")
+	if err != nil {
+		return err
+	} else if sessionHosts != "" {
+		defer os.Remove(sessionHosts)
+	}
+
+	// write the build configuration used for %post and %test sections
+	configFile := filepath.Join(stage.b.TmpDir, "singularity.conf")
+	if err := ioutil.WriteFile(configFile, configData, 0o644); err != nil {
+		return fmt.Errorf("while creating %s: %s", configFile, err)
+	}
+	defer os.Remove(configFile)
+
+	// write the cgroups configuration applied to %post and %test sections
+	if b.cgroups != nil {
+		stage.cgroupsPath = filepath.Join(stage.b.TmpDir, "cgroups.toml")
+		if err := cgroups.PutConfig(*b.cgroups, stage.cgroupsPath); err != nil {
+			return fmt.Errorf("while creating %s: %s", stage.cgroupsPath, err)
 		}
+		defer os.Remove(stage.cgroupsPath)
+	}
 
-		if err := stage.runTestScript(configFile, sessionResolv, sessionHosts); err != nil {
-			return fmt.Errorf("failed to execute %%test script: %v", err)
+	if stage.b.Recipe.BuildData.Post.Script != "" {
+		if err := stage.runPostScript(configFile, sessionResolv, sessionHosts); err != nil {
+			return fmt.Errorf("while running engine: %v", err)
 		}
 	}
 
-	syscall.Umask(oldumask)
+	sylog.Debugf("%sInserting Metadata", stage.prefix)
+	if err := stage.insertMetadata(); err != nil {
+		return fmt.Errorf("while inserting metadata to bundle: %v", err)
+	}
 
-	sylog.Debugf("Calling assembler")
-	if err := b.stages[len(b.stages)-1].Assemble(b.Conf.Dest); err != nil {
-		return err
+	if err := stage.runTestScript(configFile, sessionResolv, sessionHosts); err != nil {
+		return fmt.Errorf("failed to execute %%test script: %v", err)
+	}
+
+	if key != "" {
+		sylog.Debugf("%sCaching stage %s", stage.prefix, stage.name)
+		if err := storeStage(stage, key); err != nil {
+			sylog.Warningf("%sCould not cache stage %s: %v", stage.prefix, stage.name, err)
+		}
 	}
 
-	sylog.Verbosef("Build complete: %s", b.Conf.Dest)
 	return nil
 }
 
@@ -512,10 +677,12 @@ func makeDef(spec string) (types.Definition, error) {
 	return d, nil
 }
 
-// MakeAllDefs gets a definition object from a spec
-func MakeAllDefs(spec string) ([]types.Definition, error) {
+// MakeAllDefs gets a definition object from a spec, the placeholders of
+// definition files are resolved with the build arguments args.
+func MakeAllDefs(spec string, args map[string]string) ([]types.Definition, error) {
 	if ok, err := uri.IsValid(spec); ok && err == nil {
 		// URI passed as spec
+		warnBuildArgs(args)
 		d, err := types.NewDefinitionFromURI(spec)
 		return []types.Definition{d}, err
 	}
@@ -523,18 +690,26 @@ func MakeAllDefs(spec string) ([]types.Definition, error) {
 	// check if spec is an image/sandbox
 	if i, err := image.Init(spec, false); err == nil {
 		_ = i.File.Close()
+		warnBuildArgs(args)
 		d, err := types.NewDefinitionFromURI("localimage://" + spec)
 		return []types.Definition{d}, err
 	}
 
 	// default to reading file as definition
-	defFile, err := os.Open(spec)
+	raw, err := ioutil.ReadFile(spec)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open file %s: %v", spec, err)
 	}
-	defer defFile.Close()
 
-	d, err := parser.All(defFile)
+	if unused := parser.UnusedArguments(raw, args); len(unused) > 0 {
+		sylog.Warningf("Build argument(s) not used by %s: %s", spec, strings.Join(unused, ", "))
+	}
+	raw, err = parser.ResolveArguments(raw, args)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing definition: %s: %v", spec, err)
+	}
+
+	d, err := parser.All(bytes.NewReader(raw))
 	if err != nil {
 		return nil, fmt.Errorf("while parsing definition: %s: %v", spec, err)
 	}
@@ -542,6 +717,14 @@ func MakeAllDefs(spec string) ([]types.Definition, error) {
 	return d, nil
 }
 
+// warnBuildArgs warns that build arguments are ignored when building
+// from an image.
+func warnBuildArgs(args map[string]string) {
+	if len(args) > 0 {
+		sylog.Warningf("Build arguments are ignored when not building from a definition file")
+	}
+}
+
 func (b *Build) findStageIndex(name string) (int, error) {
 	for i, s := range b.stages {
 		if name == s.name {
@@ -0,0 +1,87 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultBuildLogSize is the default maximum size of the build log stored
+// in the image.
+const defaultBuildLogSize = 1 << 20
+
+// buildLog captures the output of the build scripts of all stages. Only
+// the first max bytes are kept, the remaining output is counted but
+// discarded so that noisy builds don't bloat the image.
+type buildLog struct {
+	mutex   sync.Mutex
+	buf     bytes.Buffer
+	max     int64
+	dropped int64
+}
+
+func newBuildLog(max int64) *buildLog {
+	if max <= 0 {
+		max = defaultBuildLogSize
+	}
+	return &buildLog{max: max}
+}
+
+// Write implements the standard Write interface.
+func (l *buildLog) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	n := int64(len(p))
+	if room := l.max - int64(l.buf.Len()); n > room {
+		if room < 0 {
+			room = 0
+		}
+		l.dropped += n - room
+		n = room
+	}
+	l.buf.Write(p[:n])
+	return len(p), nil
+}
+
+// compress returns the gzip compressed log, t is recorded as the
+// modification time of the compressed data.
+func (l *buildLog) compress(t time.Time) ([]byte, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var b bytes.Buffer
+
+	zw := gzip.NewWriter(&b)
+	zw.Name = "build.log"
+	zw.ModTime = t
+
+	if _, err := zw.Write(l.buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if l.dropped > 0 {
+		if l.buf.Len() > 0 && l.buf.Bytes()[l.buf.Len()-1] != '\n' {
+			zw.Write([]byte("\n"))
+		}
+		fmt.Fprintf(zw, "==> build log truncated, %d bytes discarded\n", l.dropped)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// logMarker writes a marker line to the build log, if any, to introduce
+// the following output of the stage.
+func (s *stage) logMarker(format string, a ...interface{}) {
+	if s.log != nil {
+		fmt.Fprintf(s.log, "%s==> %s\n", s.prefix, fmt.Sprintf(format, a...))
+	}
+}
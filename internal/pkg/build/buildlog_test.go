@@ -0,0 +1,74 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestBuildLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		max    int64
+		writes []string
+		want   string
+	}{
+		{
+			name:   "fits",
+			max:    64,
+			writes: []string{"hello\n", "world\n"},
+			want:   "hello\nworld\n",
+		},
+		{
+			name:   "truncated",
+			max:    8,
+			writes: []string{"hello\n", "world\n", "again\n"},
+			want:   "hello\nwo\n==> build log truncated, 10 bytes discarded\n",
+		},
+		{
+			name:   "empty",
+			max:    0,
+			writes: nil,
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newBuildLog(tt.max)
+			for _, w := range tt.writes {
+				if n, err := l.Write([]byte(w)); err != nil || n != len(w) {
+					t.Fatalf("unexpected write result %d, %v", n, err)
+				}
+			}
+
+			mtime := time.Unix(1600000000, 0)
+			data, err := l.compress(mtime)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !zr.ModTime.Equal(mtime) {
+				t.Errorf("got modification time %v, want %v", zr.ModTime, mtime)
+			}
+			got, err := ioutil.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
+)
+
+// cgroupsConfig returns the cgroups configuration applied to the %post and
+// %test scripts, made of the cgroups configuration file and the memory and
+// CPUs limits of conf, or nil if the build is not constrained.
+func cgroupsConfig(conf Config) (*cgroups.Config, error) {
+	limits := cgroups.Limits{Memory: conf.Memory, CPUs: conf.CPUs}
+	if conf.CgroupsPath == "" && limits.IsZero() {
+		return nil, nil
+	}
+
+	var config cgroups.Config
+	if conf.CgroupsPath != "" {
+		c, err := cgroups.LoadConfig(conf.CgroupsPath)
+		if err != nil {
+			return nil, fmt.Errorf("while loading cgroups configuration %s: %v", conf.CgroupsPath, err)
+		}
+		config = c
+	}
+
+	if err := limits.Apply(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
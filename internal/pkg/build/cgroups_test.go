@@ -0,0 +1,66 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
+)
+
+func TestCgroupsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-cgroups-")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cgroups.toml")
+	conf := "[memory]\n  limit = 1073741824\n[pids]\n  limit = 100\n"
+	if err := ioutil.WriteFile(path, []byte(conf), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config, err := cgroupsConfig(Config{})
+	if err != nil || config != nil {
+		t.Errorf("got %v, %v for an unconstrained build", config, err)
+	}
+
+	if _, err := cgroupsConfig(Config{CgroupsPath: filepath.Join(dir, "missing.toml")}); err == nil {
+		t.Errorf("unexpected success with a missing configuration file")
+	}
+	if _, err := cgroupsConfig(Config{CPUs: 0.005}); err == nil {
+		t.Errorf("unexpected success with a CPU quota below the kernel minimum")
+	}
+
+	config, err = cgroupsConfig(Config{CgroupsPath: path, Memory: 512 << 20, CPUs: 1.5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the configuration written for the scripts must load identically
+	out := filepath.Join(dir, "out.toml")
+	if err := cgroups.PutConfig(*config, out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := cgroups.LoadConfig(out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Memory == nil || got.Memory.Limit == nil || *got.Memory.Limit != 512<<20 {
+		t.Errorf("got memory %+v, want a 512MiB limit", got.Memory)
+	}
+	if got.CPU == nil || got.CPU.Quota == nil || got.CPU.Period == nil || *got.CPU.Quota != 150000 || *got.CPU.Period != cgroups.CPUPeriod {
+		t.Errorf("got CPU %+v, want a 150000/100000 quota", got.CPU)
+	}
+	if got.Pids == nil || got.Pids.Limit != 100 {
+		t.Errorf("got pids %+v, want a 100 limit", got.Pids)
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -10,7 +10,9 @@ import (
 	"fmt"
 
 	"github.com/hpcng/singularity/internal/pkg/build/sources"
+	"github.com/hpcng/singularity/internal/pkg/plugin"
 	"github.com/hpcng/singularity/pkg/build/types"
+	buildcallback "github.com/hpcng/singularity/pkg/plugin/callback/build"
 )
 
 // Conveyor is responsible for downloading from remote sources (library, shub, docker...).
@@ -53,11 +55,40 @@ func conveyorPacker(def types.Definition) (ConveyorPacker, error) {
 		return &sources.YumConveyorPacker{}, nil
 	case "zypper":
 		return &sources.ZypperConveyorPacker{}, nil
+	case "apk":
+		return &sources.ApkConveyorPacker{}, nil
 	case "scratch":
 		return &sources.ScratchConveyorPacker{}, nil
 	case "":
 		return nil, fmt.Errorf("no bootstrap specification found")
 	default:
-		return nil, fmt.Errorf("invalid build source %s", def.Header["bootstrap"])
+		return pluginConveyorPacker(def.Header["bootstrap"])
 	}
 }
+
+// pluginConveyorPacker returns the ConveyorPacker provided by plugins for
+// the given custom bootstrap agent.
+func pluginConveyorPacker(bootstrap string) (ConveyorPacker, error) {
+	callbackType := (buildcallback.BootstrapAgent)(nil)
+	callbacks, err := plugin.LoadCallbacks(callbackType)
+	if err != nil {
+		return nil, fmt.Errorf("while loading plugins callbacks '%T': %s", callbackType, err)
+	}
+
+	var cp ConveyorPacker
+	for _, c := range callbacks {
+		pcp := c.(buildcallback.BootstrapAgent)(bootstrap)
+		if pcp == nil {
+			continue
+		}
+		if cp != nil {
+			return nil, fmt.Errorf("multiple plugins provide the bootstrap agent %s", bootstrap)
+		}
+		cp = pcp
+	}
+	if cp == nil {
+		return nil, fmt.Errorf("invalid build source %s", bootstrap)
+	}
+
+	return cp, nil
+}
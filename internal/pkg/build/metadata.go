@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -15,8 +15,8 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"time"
 
+	"github.com/hpcng/singularity/internal/pkg/build/sbom"
 	"github.com/hpcng/singularity/internal/pkg/buildcfg"
 	"github.com/hpcng/singularity/pkg/build/types"
 	"github.com/hpcng/singularity/pkg/build/types/parser"
@@ -27,12 +27,12 @@ import (
 
 func (s *stage) insertMetadata() error {
 	// insert help
-	if err := insertHelpScript(s.b); err != nil {
+	if err := insertHelpScript(s.b, s.prefix); err != nil {
 		return fmt.Errorf("while inserting help script: %v", err)
 	}
 
 	// insert labels
-	if err := insertLabelsJSON(s.b); err != nil {
+	if err := insertLabelsJSON(s.b, s.prefix); err != nil {
 		return fmt.Errorf("while inserting labels json: %v", err)
 	}
 
@@ -42,22 +42,27 @@ func (s *stage) insertMetadata() error {
 	}
 
 	// insert environment
-	if err := insertEnvScript(s.b); err != nil {
+	if err := insertEnvScript(s.b, s.prefix); err != nil {
 		return fmt.Errorf("while inserting environment script: %v", err)
 	}
 
 	// insert startscript
-	if err := insertStartScript(s.b); err != nil {
+	if err := insertStartScript(s.b, s.prefix); err != nil {
 		return fmt.Errorf("while inserting startscript: %v", err)
 	}
 
+	// insert healthcheck
+	if err := insertHealthcheck(s.b, s.prefix); err != nil {
+		return fmt.Errorf("while inserting healthcheck: %v", err)
+	}
+
 	// insert runscript
-	if err := insertRunScript(s.b); err != nil {
+	if err := insertRunScript(s.b, s.prefix); err != nil {
 		return fmt.Errorf("while inserting runscript: %v", err)
 	}
 
 	// insert test script
-	if err := insertTestScript(s.b); err != nil {
+	if err := insertTestScript(s.b, s.prefix); err != nil {
 		return fmt.Errorf("while inserting test script: %v", err)
 	}
 
@@ -69,9 +74,9 @@ func (s *stage) insertMetadata() error {
 	return nil
 }
 
-func insertEnvScript(b *types.Bundle) error {
+func insertEnvScript(b *types.Bundle, prefix string) error {
 	if b.RunSection("environment") && b.Recipe.ImageData.Environment.Script != "" {
-		sylog.Infof("Adding environment to container")
+		sylog.Infof("%sAdding environment to container", prefix)
 		envScriptPath := filepath.Join(b.RootfsPath, "/.singularity.d/env/90-environment.sh")
 		_, err := os.Stat(envScriptPath)
 		if os.IsNotExist(err) {
@@ -96,7 +101,7 @@ func insertEnvScript(b *types.Bundle) error {
 	return nil
 }
 
-// runscript and starscript should use this function to properly handle args and shebangs
+// runscript, starscript and healthcheck should use this function to properly handle args and shebangs
 func handleShebangScript(s types.Script) (string, string) {
 	shebang := "#!/bin/sh"
 	script := ""
@@ -118,9 +123,9 @@ func handleShebangScript(s types.Script) (string, string) {
 	return shebang, script
 }
 
-func insertRunScript(b *types.Bundle) error {
+func insertRunScript(b *types.Bundle, prefix string) error {
 	if b.RunSection("runscript") && b.Recipe.ImageData.Runscript.Script != "" {
-		sylog.Infof("Adding runscript")
+		sylog.Infof("%sAdding runscript", prefix)
 		shebang, script := handleShebangScript(b.Recipe.ImageData.Runscript)
 		err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/runscript"), []byte(shebang+"\n\n"+script+"\n"), 0o755)
 		if err != nil {
@@ -130,9 +135,9 @@ func insertRunScript(b *types.Bundle) error {
 	return nil
 }
 
-func insertStartScript(b *types.Bundle) error {
+func insertStartScript(b *types.Bundle, prefix string) error {
 	if b.RunSection("startscript") && b.Recipe.ImageData.Startscript.Script != "" {
-		sylog.Infof("Adding startscript")
+		sylog.Infof("%sAdding startscript", prefix)
 		shebang, script := handleShebangScript(b.Recipe.ImageData.Startscript)
 		err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/startscript"), []byte(shebang+"\n\n"+script+"\n"), 0o755)
 		if err != nil {
@@ -142,9 +147,21 @@ func insertStartScript(b *types.Bundle) error {
 	return nil
 }
 
-func insertTestScript(b *types.Bundle) error {
+func insertHealthcheck(b *types.Bundle, prefix string) error {
+	if b.RunSection("healthcheck") && b.Recipe.ImageData.Healthcheck.Script != "" {
+		sylog.Infof("%sAdding healthcheck", prefix)
+		shebang, script := handleShebangScript(b.Recipe.ImageData.Healthcheck)
+		err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/healthcheck"), []byte(shebang+"\n\n"+script+"\n"), 0o755)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertTestScript(b *types.Bundle, prefix string) error {
 	if b.RunSection("test") && b.Recipe.ImageData.Test.Script != "" {
-		sylog.Infof("Adding testscript")
+		sylog.Infof("%sAdding testscript", prefix)
 		err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/test"), []byte("#!/bin/sh\n\n"+b.Recipe.ImageData.Test.Script+"\n"), 0o755)
 		if err != nil {
 			return err
@@ -153,17 +170,17 @@ func insertTestScript(b *types.Bundle) error {
 	return nil
 }
 
-func insertHelpScript(b *types.Bundle) error {
+func insertHelpScript(b *types.Bundle, prefix string) error {
 	if b.RunSection("help") && b.Recipe.ImageData.Help.Script != "" {
 		_, err := os.Stat(filepath.Join(b.RootfsPath, "/.singularity.d/runscript.help"))
 		if err != nil || b.Opts.Force {
-			sylog.Infof("Adding help info")
+			sylog.Infof("%sAdding help info", prefix)
 			err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/runscript.help"), []byte(b.Recipe.ImageData.Help.Script+"\n"), 0o644)
 			if err != nil {
 				return err
 			}
 		} else {
-			sylog.Warningf("Help message already exists and force option is false, not overwriting")
+			sylog.Warningf("%sHelp message already exists and force option is false, not overwriting", prefix)
 		}
 	}
 	return nil
@@ -206,7 +223,7 @@ func insertDefinition(b *types.Bundle) error {
 	return nil
 }
 
-func insertLabelsJSON(b *types.Bundle) (err error) {
+func insertLabelsJSON(b *types.Bundle, prefix string) (err error) {
 	var text []byte
 	labels := make(map[string]string)
 
@@ -233,7 +250,7 @@ func insertLabelsJSON(b *types.Bundle) (err error) {
 	}
 
 	if b.RunSection("labels") && len(b.Recipe.ImageData.Labels) > 0 {
-		sylog.Infof("Adding labels")
+		sylog.Infof("%sAdding labels", prefix)
 
 		// add new labels to new map and check for collisions
 		for key, value := range b.Recipe.ImageData.Labels {
@@ -243,7 +260,7 @@ func insertLabelsJSON(b *types.Bundle) (err error) {
 				if b.Opts.Force {
 					labels[key] = value
 				} else {
-					sylog.Warningf("Label: %s already exists and force option is false, not overwriting", key)
+					sylog.Warningf("%sLabel: %s already exists and force option is false, not overwriting", prefix, key)
 				}
 			} else {
 				// set if it doesn't
@@ -287,6 +304,20 @@ func insertJSONInspectMetadata(b *types.Bundle) error {
 	return nil
 }
 
+// insertSBOM adds the software bill of materials of the packages installed
+// in the bundle to the JSON objects stored in the image.
+func insertSBOM(b *types.Bundle) error {
+	bom := sbom.Generate(b.RootfsPath, b.BuildTime(), buildcfg.PACKAGE_VERSION)
+
+	data, err := json.Marshal(bom)
+	if err != nil {
+		return fmt.Errorf("while encoding SBOM: %s", err)
+	}
+	b.JSONObjects[image.SIFDescSBOMJSON] = data
+
+	return nil
+}
+
 func getExistingLabels(labels map[string]string, b *types.Bundle) error {
 	// check for existing labels in bundle
 	if _, err := os.Stat(filepath.Join(b.RootfsPath, "/.singularity.d/labels.json")); err == nil {
@@ -314,7 +345,7 @@ func addBuildLabels(labels map[string]string, b *types.Bundle) error {
 	labels["org.label-schema.schema-version"] = "1.0"
 
 	// build date and time, lots of time formatting
-	currentTime := time.Now()
+	currentTime := b.BuildTime()
 	year, month, day := currentTime.Date()
 	date := strconv.Itoa(day) + `_` + month.String() + `_` + strconv.Itoa(year)
 	hour, min, sec := currentTime.Clock()
@@ -0,0 +1,256 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	"github.com/hpcng/singularity/pkg/sylog"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ParseLocalReference returns a reference to the image designated by an
+// oci or oci-archive transport reference, in the "path[:image]" format.
+// OCI archives are extracted into a temporary directory under tmpDir, as
+// the extraction done by containers/image fails for unprivileged users when
+// file ownerships don't match the host. When no image name is specified and
+// the layout index holds several images, the image matching the platform
+// requested by sys is selected. The returned cleanup function removes the
+// temporary data and must be called once the reference is not used anymore.
+func ParseLocalReference(transport, reference, tmpDir string, sys *types.SystemContext) (ref types.ImageReference, cleanup func(), err error) {
+	var tmpDirs []string
+	cleanup = func() {
+		for _, d := range tmpDirs {
+			if err := os.RemoveAll(d); err != nil {
+				sylog.Warningf("Could not remove temporary directory %s: %v", d, err)
+			}
+		}
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+
+	parts := strings.SplitN(reference, ":", 2)
+	dir, image := parts[0], ""
+	if len(parts) == 2 {
+		image = parts[1]
+	}
+
+	switch transport {
+	case "oci":
+	case "oci-archive":
+		archiveDir, err := ioutil.TempDir(tmpDir, "temp-oci-")
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("could not create temporary oci directory: %v", err)
+		}
+		tmpDirs = append(tmpDirs, archiveDir)
+
+		if err := ExtractArchive(dir, archiveDir); err != nil {
+			return nil, cleanup, fmt.Errorf("error extracting the OCI archive file: %v", err)
+		}
+		dir = archiveDir
+	default:
+		return nil, cleanup, fmt.Errorf("%s is not a local OCI transport", transport)
+	}
+
+	if image != "" {
+		ref, err = layout.NewReference(dir, image)
+		return ref, cleanup, err
+	}
+
+	selectDir, err := selectLayoutImage(dir, tmpDir, sys)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	if selectDir != dir {
+		tmpDirs = append(tmpDirs, selectDir)
+	}
+
+	ref, err = layout.NewReference(selectDir, "")
+	return ref, cleanup, err
+}
+
+// selectLayoutImage returns the path of an OCI layout holding only the
+// image of the layout at dir matching the platform requested by sys, if
+// the layout index holds several images. The returned layout is a temporary
+// directory created under tmpDir sharing the blobs of the original layout,
+// or dir itself if it holds a single image.
+func selectLayoutImage(dir, tmpDir string, sys *types.SystemContext) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return "", fmt.Errorf("could not read OCI layout index: %v", err)
+	}
+	var index imgspecv1.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return "", fmt.Errorf("could not parse OCI layout index: %v", err)
+	}
+	if len(index.Manifests) <= 1 {
+		return dir, nil
+	}
+
+	var names []string
+	hasPlatform := false
+	for _, d := range index.Manifests {
+		if name, ok := d.Annotations[imgspecv1.AnnotationRefName]; ok {
+			names = append(names, name)
+		}
+		if d.Platform != nil {
+			hasPlatform = true
+		}
+	}
+	if !hasPlatform {
+		return "", fmt.Errorf("more than one image in %s, select one of them with %s:<name> (available: %s)", dir, dir, strings.Join(names, ", "))
+	}
+
+	digest, err := manifest.OCI1IndexFromComponents(index.Manifests, nil).ChooseInstance(sys)
+	if err != nil {
+		return "", err
+	}
+
+	var selected imgspecv1.Descriptor
+	for _, d := range index.Manifests {
+		if d.Digest == digest {
+			selected = d
+			break
+		}
+	}
+	if selected.Platform != nil {
+		sylog.Debugf("Selected image %s for platform %s/%s from %s", digest, selected.Platform.OS, selected.Platform.Architecture, dir)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	selectDir, err := ioutil.TempDir(tmpDir, "temp-oci-select-")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary oci directory: %v", err)
+	}
+
+	index.Manifests = []imgspecv1.Descriptor{selected}
+	b, err = json.Marshal(index)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(selectDir, "index.json"), b, 0o644)
+	}
+	if err == nil {
+		layoutFile := imgspecv1.ImageLayout{Version: imgspecv1.ImageLayoutVersion}
+		b, err = json.Marshal(layoutFile)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(selectDir, imgspecv1.ImageLayoutFile), b, 0o644)
+		}
+	}
+	if err == nil {
+		err = os.Symlink(filepath.Join(absDir, "blobs"), filepath.Join(selectDir, "blobs"))
+	}
+	if err != nil {
+		os.RemoveAll(selectDir)
+		return "", fmt.Errorf("could not create OCI layout for selected image: %v", err)
+	}
+
+	return selectDir, nil
+}
+
+// ExtractArchive performs a dumb tar(gz) extraction with no chown, id
+// remapping etc. This is needed for non-root handling of `oci-archive` as
+// the extraction by containers/archive is failing when uid/gid don't match
+// local machine and we're not root.
+func ExtractArchive(src string, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	header, err := br.Peek(10) // read a few bytes without consuming
+	if err != nil {
+		return err
+	}
+	var r io.Reader = br
+	gzipped := strings.Contains(http.DetectContentType(header), "x-gzip")
+
+	if gzipped {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+
+		switch {
+
+		// if no more files are found return
+		case err == io.EOF:
+			return nil
+
+		// return any other error
+		case err != nil:
+			return err
+
+		// if the header is nil, just skip it (not sure how this happens)
+		case header == nil:
+			continue
+		}
+
+		// ZipSlip protection - don't escape from dst
+		target := filepath.Join(dst, header.Name)
+		if !strings.HasPrefix(target, filepath.Clean(dst)+string(os.PathSeparator)) {
+			return fmt.Errorf("%s: illegal extraction path", target)
+		}
+
+		// check the file type
+		switch header.Typeflag {
+		// if its a dir and it doesn't exist create it
+		case tar.TypeDir:
+			if _, err := os.Stat(target); err != nil {
+				if err := os.MkdirAll(target, 0o755); err != nil {
+					return err
+				}
+			}
+		// if it's a file create it
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := extractFile(target, os.FileMode(header.Mode), tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func extractFile(target string, mode os.FileMode, r io.Reader) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_RDWR, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	// register the docker-archive transport
+	_ "github.com/containers/image/v5/docker/archive"
+	"github.com/containers/image/v5/types"
+)
+
+const (
+	multiArchLayout  = "../../../../e2e/testdata/oci-multiarch"
+	multiArchArchive = "../../../../e2e/testdata/oci-multiarch.tar"
+	dockerArchive    = "../../../../e2e/testdata/docker-archive.tar"
+)
+
+func TestParseLocalReference(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "oci-local-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name      string
+		transport string
+		reference string
+		arch      string
+		wantArch  string
+		shouldErr bool
+	}{
+		{
+			name:      "layout amd64",
+			transport: "oci",
+			reference: multiArchLayout,
+			arch:      "amd64",
+			wantArch:  "amd64",
+		},
+		{
+			name:      "layout arm64",
+			transport: "oci",
+			reference: multiArchLayout,
+			arch:      "arm64",
+			wantArch:  "arm64",
+		},
+		{
+			name:      "layout name",
+			transport: "oci",
+			reference: multiArchLayout + ":arm64",
+			arch:      "amd64",
+			wantArch:  "arm64",
+		},
+		{
+			name:      "layout unknown platform",
+			transport: "oci",
+			reference: multiArchLayout,
+			arch:      "s390x",
+			shouldErr: true,
+		},
+		{
+			name:      "archive arm64",
+			transport: "oci-archive",
+			reference: multiArchArchive,
+			arch:      "arm64",
+			wantArch:  "arm64",
+		},
+		{
+			name:      "archive name",
+			transport: "oci-archive",
+			reference: multiArchArchive + ":amd64",
+			arch:      "arm64",
+			wantArch:  "amd64",
+		},
+		{
+			name:      "unsupported transport",
+			transport: "docker-archive",
+			reference: dockerArchive,
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sys := &types.SystemContext{OSChoice: "linux", ArchitectureChoice: tt.arch}
+
+			ref, cleanup, err := ParseLocalReference(tt.transport, tt.reference, tmpDir, sys)
+			defer cleanup()
+			if err == nil {
+				err = checkImageArch(ref, sys, tt.wantArch)
+			}
+			if tt.shouldErr && err == nil {
+				t.Fatalf("unexpected success")
+			} else if !tt.shouldErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	// temporary data are removed by cleanup
+	if fi, err := ioutil.ReadDir(tmpDir); err != nil || len(fi) != 0 {
+		t.Errorf("temporary directory not cleaned up: %v", err)
+	}
+}
+
+func checkImageArch(ref types.ImageReference, sys *types.SystemContext, want string) error {
+	img, err := ref.NewImage(context.Background(), sys)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	config, err := img.OCIConfig(context.Background())
+	if err != nil {
+		return err
+	}
+	if config.Architecture != want {
+		return fmt.Errorf("got image architecture %s, want %s", config.Architecture, want)
+	}
+	return nil
+}
+
+func TestImageSHALocal(t *testing.T) {
+	ctx := context.Background()
+
+	hashes := make(map[string]string)
+	for _, arch := range []string{"amd64", "arm64"} {
+		sys := &types.SystemContext{OSChoice: "linux", ArchitectureChoice: arch}
+		for _, uri := range []string{"oci:" + multiArchLayout, "oci-archive:" + multiArchArchive} {
+			hash, err := ImageSHA(ctx, uri, sys)
+			if err != nil {
+				t.Fatalf("could not compute hash of %s for %s: %v", uri, arch, err)
+			}
+			if h, ok := hashes[arch]; ok && h != hash {
+				t.Errorf("layout and archive hashes differ for %s", arch)
+			}
+			hashes[arch] = hash
+		}
+	}
+	if hashes["amd64"] == hashes["arm64"] {
+		t.Errorf("same hash for different architectures")
+	}
+
+	if _, err := ImageSHA(ctx, "docker-archive:"+dockerArchive, nil); err != nil {
+		t.Errorf("could not compute hash of docker archive: %v", err)
+	}
+}
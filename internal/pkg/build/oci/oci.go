@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -14,6 +14,7 @@ import (
 	"strings"
 
 	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/oci/layout"
 	"github.com/containers/image/v5/signature"
 	"github.com/containers/image/v5/transports"
@@ -43,7 +44,7 @@ func ConvertReference(ctx context.Context, imgCache *cache.Handle, src types.Ima
 		return nil, err
 	}
 
-	cacheDir, err := imgCache.GetOciCacheDir(cache.OciBlobCacheType)
+	cacheDir, system, err := imgCache.LookupOciCacheDir(cache.OciBlobCacheType, cacheTag)
 	if err != nil {
 		return nil, err
 	}
@@ -52,6 +53,12 @@ func ConvertReference(ctx context.Context, imgCache *cache.Handle, src types.Ima
 		return nil, err
 	}
 
+	// The read-only system cache already holds the image, use it in place
+	// rather than fetching it again into the user cache
+	if system {
+		return c, nil
+	}
+
 	return &ImageReference{
 		source:         src,
 		ImageReference: c,
@@ -105,12 +112,39 @@ func parseURI(uri string) (types.ImageReference, error) {
 		return nil, fmt.Errorf("%s not a registered transport", split[0])
 	}
 
-	return transport.ParseReference(split[1])
+	return transport.ParseReference(transportReference(split[0], split[1]))
+}
+
+// transportReference returns the reference within transport of a
+// transport:reference pair. Only the docker transport expects a leading
+// "//", consistently with definitions created from URIs, so that
+// oci-archive://image.tar designates a file in the current directory.
+func transportReference(transport, ref string) string {
+	if transport == "docker" {
+		return ref
+	}
+	return strings.TrimPrefix(ref, "//")
 }
 
 // ImageSHA calculates the SHA of a uri's manifest
 func ImageSHA(ctx context.Context, uri string, sys *types.SystemContext) (string, error) {
-	ref, err := parseURI(uri)
+	var ref types.ImageReference
+	var err error
+
+	// local OCI layouts and archives are handled the same way they are by
+	// the build, to select the same image
+	split := strings.SplitN(uri, ":", 2)
+	if len(split) == 2 && (split[0] == "oci" || split[0] == "oci-archive") {
+		tmpDir := ""
+		if sys != nil {
+			tmpDir = sys.BigFilesTemporaryDir
+		}
+		var cleanup func()
+		ref, cleanup, err = ParseLocalReference(split[0], transportReference(split[0], split[1]), tmpDir, sys)
+		defer cleanup()
+	} else {
+		ref, err = parseURI(uri)
+	}
 	if err != nil {
 		return "", fmt.Errorf("unable to parse image name %v: %v", uri, err)
 	}
@@ -118,6 +152,13 @@ func ImageSHA(ctx context.Context, uri string, sys *types.SystemContext) (string
 	return calculateRefHash(ctx, ref, sys)
 }
 
+// ImageDigest calculates the SHA of the manifest of the image designated
+// by ref, the manifest of the image selected for the platform of sys for
+// multi-architecture images.
+func ImageDigest(ctx context.Context, ref types.ImageReference, sys *types.SystemContext) (string, error) {
+	return calculateRefHash(ctx, ref, sys)
+}
+
 func calculateRefHash(ctx context.Context, ref types.ImageReference, sys *types.SystemContext) (hash string, err error) {
 	source, err := ref.NewImageSource(ctx, sys)
 	if err != nil {
@@ -129,11 +170,28 @@ func calculateRefHash(ctx context.Context, ref types.ImageReference, sys *types.
 		}
 	}()
 
-	man, _, err := source.GetManifest(ctx, nil)
+	man, mimeType, err := source.GetManifest(ctx, nil)
 	if err != nil {
 		return "", err
 	}
 
+	// hash the manifest of the image selected for the requested platform,
+	// so multi-architecture images are cached per architecture
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(man, mimeType)
+		if err != nil {
+			return "", err
+		}
+		instance, err := list.ChooseInstance(sys)
+		if err != nil {
+			return "", err
+		}
+		man, _, err = source.GetManifest(ctx, &instance)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	hash = fmt.Sprintf("%x", sha256.Sum256(man))
 	return hash, nil
 }
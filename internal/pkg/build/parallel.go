@@ -0,0 +1,194 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// errStageSkipped is returned for stages not built because of the failure
+// of another stage.
+var errStageSkipped = errors.New("stage skipped")
+
+// stageDependencies returns the indexes of the stages the stage at index i
+// copies files from with `%files from <stage>` sections.
+func (b *Build) stageDependencies(i int) ([]int, error) {
+	var deps []int
+
+	for _, f := range b.stages[i].b.Recipe.BuildData.Files {
+		// Trim comments from args
+		args := strings.Fields(strings.Split(f.Args, "#")[0])
+		if len(args) != 2 {
+			continue
+		}
+
+		stageIndex, err := b.findStageIndex(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if stageIndex >= i {
+			return nil, fmt.Errorf("stage %s must be defined before the stages copying files from it", args[1])
+		}
+		deps = append(deps, stageIndex)
+	}
+
+	return deps, nil
+}
+
+// runStages calls fn for each stage of the build once the stages it depends
+// on are complete, with up to jobs concurrent calls. The context passed to fn
+// is canceled when a call fails, in which case stages not started yet are
+// skipped and the first error is returned. With a single job, stages are
+// built in definition order.
+func (b *Build) runStages(ctx context.Context, jobs int, fn func(ctx context.Context, i int) error) error {
+	deps := make([][]int, len(b.stages))
+	for i := range b.stages {
+		d, err := b.stageDependencies(i)
+		if err != nil {
+			return err
+		}
+		deps[i] = d
+	}
+
+	// stages are defined after the stages they depend on
+	if jobs <= 1 {
+		for i := range b.stages {
+			if err := fn(ctx, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make([]chan struct{}, len(b.stages))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	errs := make([]error, len(b.stages))
+	sem := make(chan struct{}, jobs)
+
+	var wg sync.WaitGroup
+	for i := range b.stages {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+
+			for _, d := range deps[i] {
+				<-done[d]
+				if errs[d] != nil {
+					errs[i] = errStageSkipped
+					return
+				}
+			}
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				errs[i] = errStageSkipped
+				return
+			}
+			if ctx.Err() != nil {
+				errs[i] = errStageSkipped
+				return
+			}
+
+			if errs[i] = fn(ctx, i); errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && err != errStageSkipped {
+			return err
+		}
+	}
+	return nil
+}
+
+// setOutputPrefix prefixes the log messages and the output lines of the
+// stage at index i with its name, to distinguish them from the output of
+// stages built concurrently.
+func (s *stage) setOutputPrefix(i int) {
+	name := s.name
+	if name == "" {
+		name = fmt.Sprintf("stage %d", i+1)
+	}
+	s.prefix = "[" + name + "] "
+	s.stdout = &prefixWriter{w: s.stdout, prefix: []byte(s.prefix)}
+	s.stderr = &prefixWriter{w: s.stderr, prefix: []byte(s.prefix)}
+}
+
+// flushOutput writes the last incomplete output lines of the stage.
+func (s *stage) flushOutput() {
+	for _, w := range []io.Writer{s.stdout, s.stderr} {
+		if pw, ok := w.(*prefixWriter); ok {
+			pw.Flush()
+		}
+	}
+}
+
+// prefixWriter writes lines prefixed with prefix to w. Incomplete lines
+// are buffered until they are completed or flushed, so that lines are
+// written at once and don't interleave with the output of other stages.
+type prefixWriter struct {
+	mutex  sync.Mutex
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+}
+
+// Write implements the standard Write interface.
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+
+	pw.buf = append(pw.buf, p...)
+	for {
+		n := bytes.IndexByte(pw.buf, '\n')
+		if n < 0 {
+			break
+		}
+		if err := pw.writeLine(pw.buf[:n+1]); err != nil {
+			return 0, err
+		}
+		pw.buf = pw.buf[n+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the buffered incomplete line, if any.
+func (pw *prefixWriter) Flush() error {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+
+	if len(pw.buf) == 0 {
+		return nil
+	}
+	err := pw.writeLine(append(pw.buf, '\n'))
+	pw.buf = nil
+	return err
+}
+
+func (pw *prefixWriter) writeLine(line []byte) error {
+	out := make([]byte, 0, len(pw.prefix)+len(line))
+	out = append(out, pw.prefix...)
+	out = append(out, line...)
+	_, err := pw.w.Write(out)
+	return err
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/build/types"
+)
+
+func fromStages(names ...string) []types.Files {
+	var files []types.Files
+	for _, n := range names {
+		files = append(files, types.Files{Args: "stage " + n, Files: []types.FileTransport{{Src: "/" + n}}})
+	}
+	return files
+}
+
+func TestRunStages(t *testing.T) {
+	// one and two are independent, final copies files from both of them
+	b := &Build{stages: []stage{
+		newTestStage("one", nil, ""),
+		newTestStage("two", nil, ""),
+		newTestStage("final", nil, "", fromStages("one", "two")...),
+	}}
+
+	t.Run("concurrent", func(t *testing.T) {
+		var mutex sync.Mutex
+		var order []int
+
+		// independent stages wait for each other to start
+		var started sync.WaitGroup
+		started.Add(2)
+		bothStarted := make(chan struct{})
+		go func() {
+			started.Wait()
+			close(bothStarted)
+		}()
+
+		err := b.runStages(context.Background(), 2, func(ctx context.Context, i int) error {
+			if i < 2 {
+				started.Done()
+				select {
+				case <-bothStarted:
+				case <-time.After(5 * time.Second):
+					return fmt.Errorf("stage %d not built concurrently", i)
+				}
+			}
+			mutex.Lock()
+			order = append(order, i)
+			mutex.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(order) != 3 || order[2] != 2 {
+			t.Errorf("unexpected build order %v", order)
+		}
+	})
+
+	t.Run("sequential", func(t *testing.T) {
+		running := 0
+		var mutex sync.Mutex
+		var order []int
+
+		err := b.runStages(context.Background(), 1, func(ctx context.Context, i int) error {
+			mutex.Lock()
+			running++
+			n := running
+			order = append(order, i)
+			mutex.Unlock()
+			defer func() {
+				mutex.Lock()
+				running--
+				mutex.Unlock()
+			}()
+			if n > 1 {
+				return fmt.Errorf("%d stages built concurrently", n)
+			}
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(order, []int{0, 1, 2}) {
+			t.Errorf("got build order %v, want [0 1 2]", order)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		var mutex sync.Mutex
+		built := make(map[int]bool)
+
+		err := b.runStages(context.Background(), 1, func(ctx context.Context, i int) error {
+			mutex.Lock()
+			built[i] = true
+			mutex.Unlock()
+			if i == 0 {
+				return fmt.Errorf("stage failure")
+			}
+			return nil
+		})
+		if err == nil || err.Error() != "stage failure" {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if built[2] {
+			t.Errorf("dependent stage built after failure")
+		}
+	})
+
+	t.Run("forward reference", func(t *testing.T) {
+		b := &Build{stages: []stage{
+			newTestStage("one", nil, "", fromStages("two")...),
+			newTestStage("two", nil, ""),
+		}}
+		err := b.runStages(context.Background(), 2, func(ctx context.Context, i int) error {
+			return nil
+		})
+		if err == nil {
+			t.Errorf("unexpected success")
+		}
+	})
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw := &prefixWriter{w: &buf, prefix: []byte("[one] ")}
+
+	for _, s := range []string{"first ", "line\nsecond", " line\n", "last"} {
+		if _, err := pw.Write([]byte(s)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := pw.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "[one] first line\n[one] second line\n[one] last\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
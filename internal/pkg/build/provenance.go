@@ -0,0 +1,245 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/buildcfg"
+	"github.com/hpcng/singularity/pkg/image"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+const (
+	// inTotoStatementType is the type of in-toto attestation statements.
+	inTotoStatementType = "https://in-toto.io/Statement/v0.1"
+	// slsaProvenanceType is the predicate type of SLSA provenance documents.
+	slsaProvenanceType = "https://slsa.dev/provenance/v0.2"
+	// provenanceBuildType identifies how the image was built.
+	provenanceBuildType = "https://github.com/hpcng/singularity/build@v1"
+)
+
+// provenance is an in-toto statement with a SLSA provenance predicate,
+// describing how and from which sources an image was built.
+type provenance struct {
+	Type          string              `json:"_type"`
+	PredicateType string              `json:"predicateType"`
+	Subject       []provenanceSubject `json:"subject"`
+	Predicate     provenancePredicate `json:"predicate"`
+
+	// sources maps the index of the stages to the index of the material
+	// of their bootstrap source, whose digest is known once built.
+	sources map[int]int
+}
+
+// provenanceSubject is the image the provenance applies to. The image
+// can't hold its own digest, its integrity is ensured by signing it.
+type provenanceSubject struct {
+	Name string `json:"name"`
+}
+
+type provenancePredicate struct {
+	Builder    provenanceBuilder    `json:"builder"`
+	BuildType  string               `json:"buildType"`
+	Invocation provenanceInvocation `json:"invocation"`
+	Metadata   provenanceMetadata   `json:"metadata"`
+	Materials  []provenanceMaterial `json:"materials"`
+}
+
+type provenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+type provenanceInvocation struct {
+	ConfigSource provenanceMaterial    `json:"configSource"`
+	Parameters   provenanceParameters  `json:"parameters"`
+	Environment  provenanceEnvironment `json:"environment"`
+}
+
+type provenanceParameters struct {
+	BuildArgs map[string]string `json:"buildArgs,omitempty"`
+	Sections  []string          `json:"sections,omitempty"`
+	Arch      string            `json:"arch,omitempty"`
+	FixPerms  bool              `json:"fixPerms,omitempty"`
+}
+
+type provenanceEnvironment struct {
+	Hostname           string `json:"hostname,omitempty"`
+	Arch               string `json:"arch"`
+	SingularityVersion string `json:"singularityVersion"`
+}
+
+type provenanceMetadata struct {
+	BuildStartedOn  string `json:"buildStartedOn"`
+	BuildFinishedOn string `json:"buildFinishedOn"`
+	Reproducible    bool   `json:"reproducible"`
+}
+
+// provenanceMaterial is a source used by the build, identified by its URI
+// and the digests of its content.
+type provenanceMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// digestSet returns the digest set of a "<algorithm>:<hex>" digest.
+func digestSet(digest string) map[string]string {
+	split := strings.SplitN(digest, ":", 2)
+	if len(split) != 2 || split[1] == "" {
+		return nil
+	}
+	return map[string]string{split[0]: split[1]}
+}
+
+// materialDigest returns the digest of the content of the host file src,
+// or the digest of the files matching src if it isn't a regular file.
+func materialDigest(src string) (string, error) {
+	if fi, err := os.Stat(src); err != nil || !fi.Mode().IsRegular() {
+		return hostFilesDigest(src)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newProvenance returns the provenance of the build, recording the digests
+// of the definition file and of the host files copied in the stages. It
+// must be called before the stages are built, so that the recorded files
+// are the ones used by the build. The digests of the bootstrap sources are
+// recorded by insertProvenance once the stages are built. Sources which
+// can't be identified are recorded without digest.
+func (b *Build) newProvenance(started time.Time) *provenance {
+	final := b.stages[len(b.stages)-1].b
+
+	p := &provenance{
+		Type:          inTotoStatementType,
+		PredicateType: slsaProvenanceType,
+		Subject:       []provenanceSubject{{Name: filepath.Base(b.Conf.Dest)}},
+		Predicate: provenancePredicate{
+			Builder:   provenanceBuilder{ID: "https://github.com/hpcng/singularity@" + buildcfg.PACKAGE_VERSION},
+			BuildType: provenanceBuildType,
+			Invocation: provenanceInvocation{
+				ConfigSource: provenanceMaterial{
+					URI: b.Conf.Spec,
+				},
+				Parameters: provenanceParameters{
+					BuildArgs: final.Opts.BuildArgs,
+					Sections:  final.Opts.Sections,
+					Arch:      final.Opts.Arch,
+					FixPerms:  final.Opts.FixPerms,
+				},
+				Environment: provenanceEnvironment{
+					Arch:               runtime.GOARCH,
+					SingularityVersion: buildcfg.PACKAGE_VERSION,
+				},
+			},
+			Metadata: provenanceMetadata{
+				BuildStartedOn: started.UTC().Format(time.RFC3339),
+				Reproducible:   final.Opts.Reproducible,
+			},
+			Materials: []provenanceMaterial{},
+		},
+		sources: make(map[int]int),
+	}
+
+	// the definition file as written, the resolved build arguments are
+	// recorded as parameters
+	if fi, err := os.Stat(b.Conf.Spec); err == nil && fi.Mode().IsRegular() {
+		digest, err := materialDigest(b.Conf.Spec)
+		if err != nil {
+			sylog.Debugf("Could not compute digest of %s: %v", b.Conf.Spec, err)
+		} else {
+			p.Predicate.Invocation.ConfigSource.Digest = map[string]string{"sha256": digest}
+		}
+	}
+
+	// the build host differs between reproducible builds
+	if !final.Opts.Reproducible {
+		p.Predicate.Invocation.Environment.Hostname, _ = os.Hostname()
+	}
+
+	for i, s := range b.stages {
+		bootstrap := s.b.Recipe.Header["bootstrap"]
+		if bootstrap != "" && bootstrap != "scratch" {
+			p.sources[i] = len(p.Predicate.Materials)
+			m := provenanceMaterial{URI: bootstrap + "://" + s.b.Recipe.Header["from"]}
+			p.Predicate.Materials = append(p.Predicate.Materials, m)
+		}
+
+		for _, f := range s.b.Recipe.BuildData.Files {
+			// only files copied from the host, not from other stages
+			if len(strings.Fields(strings.Split(f.Args, "#")[0])) != 0 {
+				continue
+			}
+			for _, transfer := range f.Files {
+				if transfer.Src == "" {
+					continue
+				}
+				src, err := filepath.Abs(transfer.Src)
+				if err != nil {
+					src = transfer.Src
+				}
+				m := provenanceMaterial{URI: "file://" + src}
+				digest, err := materialDigest(transfer.Src)
+				if err != nil {
+					sylog.Debugf("Could not compute digest of %s: %v", transfer.Src, err)
+				} else {
+					m.Digest = map[string]string{"sha256": digest}
+				}
+				p.Predicate.Materials = append(p.Predicate.Materials, m)
+			}
+		}
+	}
+
+	return p
+}
+
+// insertProvenance adds the provenance to the JSON objects stored in the
+// image built by the final stage, recording the digests of the bootstrap
+// sources the stages were built from, and finished as the time the build
+// finished.
+func (b *Build) insertProvenance(ctx context.Context, p *provenance, finished time.Time) error {
+	for i, n := range p.sources {
+		s := b.stages[i]
+		digest := s.sourceDigest
+		// sources not retrieved by the build, such as the image updated
+		if d, ok := s.c.(sourceDigester); ok && digest == "" {
+			var err error
+			digest, err = d.SourceDigest(ctx, s.b)
+			if err != nil {
+				sylog.Debugf("Could not compute digest of %s: %v", p.Predicate.Materials[n].URI, err)
+			}
+		}
+		p.Predicate.Materials[n].Digest = digestSet(digest)
+	}
+	p.Predicate.Metadata.BuildFinishedOn = finished.UTC().Format(time.RFC3339)
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	b.stages[len(b.stages)-1].b.JSONObjects[image.SIFDescProvenanceJSON] = data
+
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/build/sources"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/image"
+)
+
+func TestProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenance-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostFile := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(hostFile, []byte("content"), 0o644); err != nil {
+		t.Fatalf("could not create %s: %v", hostFile, err)
+	}
+	// the definition file digest is the digest of the file as written,
+	// not of the definitions of the stages
+	spec := filepath.Join(dir, "app.def")
+	if err := ioutil.WriteFile(spec, []byte("content"), 0o644); err != nil {
+		t.Fatalf("could not create %s: %v", spec, err)
+	}
+
+	build := newTestStage("build", &sources.OCIConveyorPacker{}, "make",
+		types.Files{Files: []types.FileTransport{{Src: hostFile, Dst: "/src/file"}}},
+	)
+	build.b.Recipe.Header = map[string]string{"bootstrap": "docker", "from": "alpine:3.14", "stage": "build"}
+	build.b.Recipe.Raw = []byte("Bootstrap: docker\nFrom: alpine:3.14\nStage: build\n")
+	build.sourceDigest = "sha256:e1c082e3d3c45cccac829840a25941e679c25d438cc8412c2fa221cf1a824e6a"
+	final := newTestStage("final", &sources.ScratchConveyorPacker{}, "", fromStages("build")...)
+	final.b.Recipe.Raw = []byte("Bootstrap: scratch\nStage: final\n")
+	final.b.JSONObjects = make(map[string][]byte)
+	final.b.Opts.BuildArgs = map[string]string{"VERSION": "1.0"}
+	final.b.Opts.Reproducible = true
+
+	b := &Build{
+		stages: []stage{build, final},
+		Conf:   Config{Dest: "/tmp/image.sif", Spec: spec},
+	}
+
+	p := b.newProvenance(time.Unix(0, 0))
+	if err := b.insertProvenance(context.Background(), p, time.Unix(60, 0)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got provenance
+	if err := json.Unmarshal(final.b.JSONObjects[image.SIFDescProvenanceJSON], &got); err != nil {
+		t.Fatalf("could not decode provenance: %v", err)
+	}
+
+	if got.Type != inTotoStatementType || got.PredicateType != slsaProvenanceType {
+		t.Errorf("unexpected statement type %s %s", got.Type, got.PredicateType)
+	}
+	if len(got.Subject) != 1 || got.Subject[0].Name != "image.sif" {
+		t.Errorf("unexpected subject %+v", got.Subject)
+	}
+
+	pred := got.Predicate
+	wantSource := provenanceMaterial{
+		URI:    spec,
+		Digest: map[string]string{"sha256": "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"},
+	}
+	if !reflect.DeepEqual(pred.Invocation.ConfigSource, wantSource) {
+		t.Errorf("got config source %+v, want %+v", pred.Invocation.ConfigSource, wantSource)
+	}
+	if !reflect.DeepEqual(pred.Invocation.Parameters.BuildArgs, final.b.Opts.BuildArgs) {
+		t.Errorf("got build arguments %v, want %v", pred.Invocation.Parameters.BuildArgs, final.b.Opts.BuildArgs)
+	}
+	if pred.Invocation.Environment.Hostname != "" {
+		t.Errorf("hostname recorded for reproducible build")
+	}
+	if pred.Metadata.BuildStartedOn != "1970-01-01T00:00:00Z" || pred.Metadata.BuildFinishedOn != "1970-01-01T00:01:00Z" {
+		t.Errorf("unexpected build times %+v", pred.Metadata)
+	}
+
+	// the bootstrap source is recorded with the digest the stage was built
+	// from, scratch sources and files copied from stages aren't materials
+	want := []provenanceMaterial{
+		{
+			URI:    "docker://alpine:3.14",
+			Digest: map[string]string{"sha256": "e1c082e3d3c45cccac829840a25941e679c25d438cc8412c2fa221cf1a824e6a"},
+		},
+		{
+			URI:    "file://" + hostFile,
+			Digest: map[string]string{"sha256": "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"},
+		},
+	}
+	if !reflect.DeepEqual(pred.Materials, want) {
+		t.Errorf("got materials %+v, want %+v", pred.Materials, want)
+	}
+}
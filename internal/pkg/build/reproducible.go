@@ -0,0 +1,49 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SourceDateEpoch returns the time, in seconds since the Unix epoch, set
+// by the SOURCE_DATE_EPOCH environment variable for reproducible builds,
+// or zero when the variable is not set.
+// See https://reproducible-builds.org/specs/source-date-epoch/.
+func SourceDateEpoch() (int64, error) {
+	value, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !ok || value == "" {
+		return 0, nil
+	}
+
+	epoch, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || epoch < 0 {
+		return 0, fmt.Errorf("invalid SOURCE_DATE_EPOCH value %q, must be a number of seconds since the Unix epoch", value)
+	}
+	return epoch, nil
+}
+
+// normalizeTimes sets the access and modification times of all the files
+// under root, root included, to t without following symbolic links.
+func normalizeTimes(root string, t time.Time) error {
+	ts := []unix.Timespec{unix.NsecToTimespec(t.UnixNano()), unix.NsecToTimespec(t.UnixNano())}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return fmt.Errorf("while setting times of %s: %v", path, err)
+		}
+		return nil
+	})
+}
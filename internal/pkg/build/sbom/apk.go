@@ -0,0 +1,50 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sbom
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const apkInstalled = "lib/apk/db/installed"
+
+// scanApk returns the packages installed according to the apk database of
+// Alpine Linux, made of stanzas of single letter fields.
+func scanApk(rootfs, distro string) ([]Component, error) {
+	f, err := os.Open(filepath.Join(rootfs, apkInstalled))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stanzas, err := parseStanzas(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if distro == "" {
+		distro = "alpine"
+	}
+
+	var components []Component
+	for _, p := range stanzas {
+		if p["P"] == "" {
+			continue
+		}
+		components = append(components, Component{
+			Type:     "library",
+			Name:     p["P"],
+			Version:  p["V"],
+			Licenses: license(p["L"]),
+			PURL:     purl("apk", distro, p["P"], p["V"], "arch", p["A"]),
+		})
+	}
+
+	return components, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sbom
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const dpkgStatus = "var/lib/dpkg/status"
+
+// parseStanzas parses the blank line separated stanzas of "Key: value"
+// fields of r, continuation lines of a field are ignored.
+func parseStanzas(r io.Reader) ([]map[string]string, error) {
+	var stanzas []map[string]string
+
+	stanza := make(map[string]string)
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(stanza) > 0 {
+				stanzas = append(stanzas, stanza)
+				stanza = make(map[string]string)
+			}
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 {
+			stanza[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	if len(stanza) > 0 {
+		stanzas = append(stanzas, stanza)
+	}
+
+	return stanzas, s.Err()
+}
+
+// scanDpkg returns the packages installed according to the dpkg status
+// database of Debian based distributions.
+func scanDpkg(rootfs, distro string) ([]Component, error) {
+	f, err := os.Open(filepath.Join(rootfs, dpkgStatus))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stanzas, err := parseStanzas(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if distro == "" {
+		distro = "debian"
+	}
+
+	var components []Component
+	for _, p := range stanzas {
+		if !strings.HasSuffix(p["Status"], " installed") || p["Package"] == "" {
+			continue
+		}
+		components = append(components, Component{
+			Type:    "library",
+			Name:    p["Package"],
+			Version: p["Version"],
+			PURL:    purl("deb", distro, p["Package"], p["Version"], "arch", p["Architecture"]),
+		})
+	}
+
+	return components, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sbom
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// pythonSitePackages are the glob patterns of the directories of installed
+// Python packages.
+var pythonSitePackages = []string{
+	"usr/lib/python*/site-packages",
+	"usr/lib/python*/dist-packages",
+	"usr/lib64/python*/site-packages",
+	"usr/local/lib/python*/site-packages",
+	"usr/local/lib/python*/dist-packages",
+	"usr/local/lib64/python*/site-packages",
+	"opt/conda/lib/python*/site-packages",
+}
+
+var pypiNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// scanPython returns the Python packages installed in the site-packages
+// directories, from the metadata of their dist-info and egg-info directories.
+func scanPython(rootfs, distro string) ([]Component, error) {
+	var metadataFiles []string
+	for _, pattern := range pythonSitePackages {
+		for _, suffix := range []string{"*.dist-info/METADATA", "*.egg-info/PKG-INFO", "*.egg-info"} {
+			matches, err := filepath.Glob(filepath.Join(rootfs, pattern, suffix))
+			if err != nil {
+				return nil, err
+			}
+			metadataFiles = append(metadataFiles, matches...)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var components []Component
+	for _, path := range metadataFiles {
+		// egg-info can also be a directory, handled by the PKG-INFO pattern
+		if fi, err := os.Stat(path); err != nil || fi.IsDir() {
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		stanzas, err := parseStanzas(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(stanzas) == 0 || stanzas[0]["Name"] == "" {
+			continue
+		}
+
+		// the first stanza holds the metadata headers
+		p := stanzas[0]
+		name := strings.ToLower(pypiNameSeparators.ReplaceAllString(p["Name"], "-"))
+		ref := purl("pypi", "", name, p["Version"])
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		components = append(components, Component{
+			Type:     "library",
+			Name:     p["Name"],
+			Version:  p["Version"],
+			Licenses: license(p["License"]),
+			PURL:     ref,
+		})
+	}
+
+	return components, nil
+}
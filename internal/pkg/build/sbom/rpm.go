@@ -0,0 +1,88 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/util/bin"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// rpmDBPaths are the possible locations of the rpm database.
+var rpmDBPaths = []string{"var/lib/rpm", "usr/lib/sysimage/rpm"}
+
+const rpmQueryFormat = `%{NAME}\t%{EPOCHNUM}\t%{VERSION}-%{RELEASE}\t%{ARCH}\t%{LICENSE}\n`
+
+// scanRpm returns the packages installed according to the rpm database of
+// RPM based distributions. The database formats vary between distributions,
+// it is read with the rpm command of the host, which must be installed and
+// support the database format of the container.
+func scanRpm(rootfs, distro string) ([]Component, error) {
+	dbPath := ""
+	for _, p := range rpmDBPaths {
+		path := filepath.Join(rootfs, p)
+		for _, db := range []string{"Packages", "Packages.db", "rpmdb.sqlite"} {
+			if _, err := os.Stat(filepath.Join(path, db)); err == nil {
+				dbPath = path
+				break
+			}
+		}
+		if dbPath != "" {
+			break
+		}
+	}
+	if dbPath == "" {
+		return nil, nil
+	}
+
+	rpmPath, err := bin.FindBin("rpm")
+	if err != nil {
+		return nil, fmt.Errorf("rpm database found but the host rpm command required to read it is not in path: %v", err)
+	}
+	sylog.Verbosef("Reading rpm database %s with the host command %s", dbPath, rpmPath)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(rpmPath, "--dbpath", dbPath, "-qa", "--qf", rpmQueryFormat)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("while querying rpm database with the host command %s: %v: %s", rpmPath, err, stderr.String())
+	}
+
+	if distro == "" {
+		distro = "redhat"
+	}
+
+	var components []Component
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		// gpg-pubkey entries are imported keys, not packages
+		if len(fields) != 5 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		epoch := fields[1]
+		if epoch == "0" {
+			epoch = ""
+		}
+		components = append(components, Component{
+			Type:     "library",
+			Name:     fields[0],
+			Version:  fields[2],
+			Licenses: license(fields[4]),
+			PURL:     purl("rpm", distro, fields[0], fields[2], "arch", fields[3], "epoch", epoch),
+		})
+	}
+
+	return components, s.Err()
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package sbom generates a software bill of materials, in the CycloneDX JSON
+// format, listing the packages installed in the root filesystem of a build.
+package sbom
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+const (
+	// Format is the format of the generated bill of materials.
+	Format = "CycloneDX"
+	// SpecVersion is the version of the CycloneDX specification followed.
+	SpecVersion = "1.3"
+)
+
+// BOM is a CycloneDX bill of materials.
+type BOM struct {
+	BOMFormat   string      `json:"bomFormat"`
+	SpecVersion string      `json:"specVersion"`
+	Version     int         `json:"version"`
+	Metadata    Metadata    `json:"metadata"`
+	Components  []Component `json:"components"`
+}
+
+// Metadata describes when and how a bill of materials was generated.
+type Metadata struct {
+	Timestamp string `json:"timestamp"`
+	Tools     []Tool `json:"tools"`
+}
+
+// Tool is a tool used to generate a bill of materials.
+type Tool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Component is a software component found in a root filesystem.
+type Component struct {
+	Type     string    `json:"type"`
+	Name     string    `json:"name"`
+	Version  string    `json:"version,omitempty"`
+	Licenses []License `json:"licenses,omitempty"`
+	PURL     string    `json:"purl,omitempty"`
+}
+
+// License is a component license, as recorded by its package.
+type License struct {
+	License *LicenseName `json:"license,omitempty"`
+}
+
+// LicenseName holds the name of a license which isn't an SPDX expression.
+type LicenseName struct {
+	Name string `json:"name"`
+}
+
+// scanner returns the components installed by a package manager in the
+// root filesystem rootfs, distro is the ID of the distribution.
+type scanner func(rootfs, distro string) ([]Component, error)
+
+var scanners = map[string]scanner{
+	"dpkg":   scanDpkg,
+	"rpm":    scanRpm,
+	"apk":    scanApk,
+	"python": scanPython,
+}
+
+// Generate returns the bill of materials of the packages installed in the
+// root filesystem rootfs, recorded as generated at time t by the given
+// singularity version. Package databases which can't be read are reported
+// as warnings and skipped.
+func Generate(rootfs string, t time.Time, version string) *BOM {
+	bom := &BOM{
+		BOMFormat:   Format,
+		SpecVersion: SpecVersion,
+		Version:     1,
+		Metadata: Metadata{
+			Timestamp: t.UTC().Format(time.RFC3339),
+			Tools: []Tool{
+				{Vendor: "Sylabs", Name: "singularity", Version: version},
+			},
+		},
+		Components: []Component{},
+	}
+
+	osRelease := readOSRelease(rootfs)
+	distro := osRelease["ID"]
+	if distro != "" {
+		bom.Components = append(bom.Components, Component{
+			Type:    "operating-system",
+			Name:    distro,
+			Version: osRelease["VERSION_ID"],
+		})
+	}
+
+	names := make([]string, 0, len(scanners))
+	for name := range scanners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var packages []Component
+	for _, name := range names {
+		components, err := scanners[name](rootfs, distro)
+		if err != nil {
+			sylog.Warningf("Could not list %s packages for the SBOM: %v", name, err)
+			continue
+		}
+		sylog.Debugf("Found %d %s packages", len(components), name)
+		packages = append(packages, components...)
+	}
+
+	sort.SliceStable(packages, func(i, j int) bool {
+		if packages[i].PURL != packages[j].PURL {
+			return packages[i].PURL < packages[j].PURL
+		}
+		return packages[i].Name < packages[j].Name
+	})
+	bom.Components = append(bom.Components, packages...)
+
+	return bom
+}
+
+// readOSRelease returns the variables of the os-release file of rootfs.
+func readOSRelease(rootfs string) map[string]string {
+	vars := make(map[string]string)
+
+	for _, path := range []string{"etc/os-release", "usr/lib/os-release"} {
+		f, err := os.Open(filepath.Join(rootfs, path))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			kv := strings.SplitN(strings.TrimSpace(s.Text()), "=", 2)
+			if len(kv) != 2 || strings.HasPrefix(kv[0], "#") {
+				continue
+			}
+			vars[kv[0]] = strings.Trim(kv[1], `"'`)
+		}
+		break
+	}
+
+	return vars
+}
+
+// license returns the license of a component from a package metadata value.
+func license(value string) []License {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, "unknown") || strings.EqualFold(value, "none") {
+		return nil
+	}
+	return []License{{License: &LicenseName{Name: value}}}
+}
+
+// purl returns a package URL, see https://github.com/package-url/purl-spec.
+func purl(typ, namespace, name, version string, qualifiers ...string) string {
+	p := "pkg:" + typ + "/"
+	if namespace != "" {
+		p += purlEscape(namespace) + "/"
+	}
+	p += purlEscape(name)
+	if version != "" {
+		p += "@" + purlEscape(version)
+	}
+
+	var q []string
+	for i := 0; i+1 < len(qualifiers); i += 2 {
+		if qualifiers[i+1] != "" {
+			q = append(q, qualifiers[i]+"="+purlEscape(qualifiers[i+1]))
+		}
+	}
+	if len(q) > 0 {
+		p += "?" + strings.Join(q, "&")
+	}
+	return p
+}
+
+var purlEscaper = strings.NewReplacer("%", "%25", "@", "%40", "/", "%2F", "?", "%3F", "#", "%23", "&", "%26", "=", "%3D", "+", "%2B", " ", "%20")
+
+func purlEscape(s string) string {
+	return purlEscaper.Replace(s)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sbom
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testDpkgStatus = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.31-13+deb11u2
+Description: GNU C Library: Shared libraries
+ Contains the standard libraries.
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0-1
+`
+
+const testApkInstalled = `C:Q1abc=
+P:musl
+V:1.2.2-r3
+A:x86_64
+L:MIT
+F:lib
+
+P:busybox
+V:1.33.1-r6
+A:x86_64
+L:GPL-2.0-only
+`
+
+const testPythonMetadata = `Metadata-Version: 2.1
+Name: Requests_OAuthlib
+Version: 1.3.0
+License: ISC
+
+Long description.
+`
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("could not create %s: %v", filepath.Dir(path), err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("could not create %s: %v", path, err)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "sbom-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	writeFiles(t, rootfs, map[string]string{
+		"etc/os-release": "NAME=\"Debian GNU/Linux\"\nID=debian\nVERSION_ID=\"11\"\n",
+		dpkgStatus:       testDpkgStatus,
+		apkInstalled:     testApkInstalled,
+		"usr/lib/python3/dist-packages/requests_oauthlib-1.3.0.dist-info/METADATA": testPythonMetadata,
+	})
+
+	bom := Generate(rootfs, time.Unix(1600000000, 0), "3.9.0")
+
+	if bom.BOMFormat != Format || bom.SpecVersion != SpecVersion {
+		t.Errorf("unexpected format %s %s", bom.BOMFormat, bom.SpecVersion)
+	}
+	if bom.Metadata.Timestamp != "2020-09-13T12:26:40Z" {
+		t.Errorf("unexpected timestamp %s", bom.Metadata.Timestamp)
+	}
+
+	want := []Component{
+		{Type: "operating-system", Name: "debian", Version: "11"},
+		{
+			Type:     "library",
+			Name:     "busybox",
+			Version:  "1.33.1-r6",
+			Licenses: []License{{License: &LicenseName{Name: "GPL-2.0-only"}}},
+			PURL:     "pkg:apk/debian/busybox@1.33.1-r6?arch=x86_64",
+		},
+		{
+			Type:     "library",
+			Name:     "musl",
+			Version:  "1.2.2-r3",
+			Licenses: []License{{License: &LicenseName{Name: "MIT"}}},
+			PURL:     "pkg:apk/debian/musl@1.2.2-r3?arch=x86_64",
+		},
+		{
+			Type:    "library",
+			Name:    "libc6",
+			Version: "2.31-13+deb11u2",
+			PURL:    "pkg:deb/debian/libc6@2.31-13%2Bdeb11u2?arch=amd64",
+		},
+		{
+			Type:     "library",
+			Name:     "Requests_OAuthlib",
+			Version:  "1.3.0",
+			Licenses: []License{{License: &LicenseName{Name: "ISC"}}},
+			PURL:     "pkg:pypi/requests-oauthlib@1.3.0",
+		},
+	}
+	if !reflect.DeepEqual(bom.Components, want) {
+		t.Errorf("got components:\n%+v\nwant:\n%+v", bom.Components, want)
+	}
+}
+
+func TestGenerateEmpty(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "sbom-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	bom := Generate(rootfs, time.Now(), "3.9.0")
+	if len(bom.Components) != 0 {
+		t.Errorf("unexpected components %+v", bom.Components)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+// secretsDir is the directory of the container where build secrets are
+// mounted during the %post section.
+const secretsDir = "/run/secrets"
+
+// ParseSecret parses a build secret specification in the
+// id=<id>,src=<path> format. A leading ~ in the source path is
+// expanded to the home directory of the user.
+func ParseSecret(spec string) (types.Secret, error) {
+	var secret types.Secret
+
+	for _, opt := range strings.Split(spec, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return secret, fmt.Errorf("invalid secret option %q, must be in the key=value format", opt)
+		}
+		switch kv[0] {
+		case "id":
+			secret.ID = kv[1]
+		case "src", "source":
+			secret.Source = kv[1]
+		default:
+			return secret, fmt.Errorf("unknown secret option %q", kv[0])
+		}
+	}
+
+	if secret.ID == "" || secret.ID == "." || secret.ID == ".." || strings.Contains(secret.ID, "/") {
+		return secret, fmt.Errorf("invalid secret id %q", secret.ID)
+	}
+	if secret.Source == "" {
+		return secret, fmt.Errorf("no source file for secret %s", secret.ID)
+	}
+
+	if secret.Source == "~" || strings.HasPrefix(secret.Source, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return secret, fmt.Errorf("while expanding %s: %v", secret.Source, err)
+		}
+		secret.Source = filepath.Join(home, strings.TrimPrefix(secret.Source, "~"))
+	}
+	src, err := filepath.Abs(secret.Source)
+	if err != nil {
+		return secret, err
+	}
+	secret.Source = src
+
+	fi, err := os.Stat(secret.Source)
+	if err != nil {
+		return secret, fmt.Errorf("while checking secret %s: %v", secret.ID, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return secret, fmt.Errorf("source of secret %s is not a regular file", secret.ID)
+	}
+
+	return secret, nil
+}
+
+// mountSecrets copies the build secrets to a tmpfs mounted on a staging
+// directory of the bundle, so that they are never written to disk, and
+// returns the bind arguments mounting the staging directory read-only on
+// secretsDir. The returned cleanup function unmounts the staging directory
+// and removes the directories created for secretsDir in the root
+// filesystem of the stage, it must be called once the %post section is
+// done so that no trace of the secrets is left in the image.
+func (s *stage) mountSecrets() (args []string, cleanup func(), err error) {
+	var created []string
+	var staging string
+	cleanup = func() {
+		if staging != "" {
+			if err := unix.Unmount(staging, unix.MNT_DETACH); err != nil && err != unix.EINVAL {
+				sylog.Errorf("%sCould not unmount secrets staging directory %s: %v", s.prefix, staging, err)
+			}
+			if err := os.Remove(staging); err != nil {
+				sylog.Errorf("%sCould not remove secrets staging directory %s: %v", s.prefix, staging, err)
+			}
+		}
+		// remove directories in reverse order of creation
+		for i := len(created) - 1; i >= 0; i-- {
+			if err := os.RemoveAll(created[i]); err != nil {
+				sylog.Errorf("%sCould not remove secret mount point %s: %v", s.prefix, created[i], err)
+			}
+		}
+	}
+	defer func() {
+		if err != nil {
+			cleanup()
+		}
+	}()
+
+	if len(s.b.Opts.Secrets) == 0 {
+		return nil, cleanup, nil
+	}
+
+	ids := make(map[string]bool)
+	for _, secret := range s.b.Opts.Secrets {
+		if ids[secret.ID] {
+			return nil, cleanup, fmt.Errorf("duplicate secret id %s", secret.ID)
+		}
+		ids[secret.ID] = true
+	}
+
+	// the staging directory is passed as a bind path, which can't
+	// contain the separators of bind specifications
+	dir := filepath.Join(s.b.TmpDir, "secrets")
+	if strings.ContainsAny(dir, ":,") {
+		return nil, cleanup, fmt.Errorf("secrets staging directory %s can't contain ':' or ','", dir)
+	}
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return nil, cleanup, fmt.Errorf("while creating secrets staging directory: %v", err)
+	}
+	staging = dir
+	if err := unix.Mount("tmpfs", staging, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=0700"); err != nil {
+		return nil, cleanup, fmt.Errorf("while mounting tmpfs on %s: %v", staging, err)
+	}
+
+	for _, secret := range s.b.Opts.Secrets {
+		if err := copySecret(secret.Source, filepath.Join(staging, secret.ID)); err != nil {
+			return nil, cleanup, fmt.Errorf("while copying secret %s: %v", secret.ID, err)
+		}
+	}
+
+	// create missing directories, the topmost one created is removed
+	// with everything the %post section may have written in it
+	dir = "/"
+	for _, elem := range strings.Split(strings.Trim(secretsDir, "/"), "/") {
+		dir = filepath.Join(dir, elem)
+		path, err := securejoin.SecureJoin(s.b.RootfsPath, dir)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := os.Mkdir(path, 0o755); err != nil {
+				return nil, cleanup, fmt.Errorf("while creating %s: %v", dir, err)
+			}
+			if len(created) == 0 {
+				created = append(created, path)
+			}
+		} else if err != nil {
+			return nil, cleanup, err
+		}
+	}
+
+	sylog.Debugf("%sMounting secrets to %s", s.prefix, secretsDir)
+	return []string{"-B", staging + ":" + secretsDir + ":ro"}, cleanup, nil
+}
+
+// copySecret copies the secret file src to dst, readable by its owner only.
+func copySecret(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o400)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
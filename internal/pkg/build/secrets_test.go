@@ -0,0 +1,172 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/test"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-secret-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "npmrc")
+	if err := ioutil.WriteFile(src, []byte("token"), 0o600); err != nil {
+		t.Fatalf("could not create %s: %v", src, err)
+	}
+
+	tests := []struct {
+		name      string
+		spec      string
+		want      types.Secret
+		shouldErr bool
+	}{
+		{
+			name: "valid",
+			spec: "id=npmrc,src=" + src,
+			want: types.Secret{ID: "npmrc", Source: src},
+		},
+		{
+			name:      "missing id",
+			spec:      "src=" + src,
+			shouldErr: true,
+		},
+		{
+			name:      "invalid id",
+			spec:      "id=../npmrc,src=" + src,
+			shouldErr: true,
+		},
+		{
+			name:      "missing source",
+			spec:      "id=npmrc",
+			shouldErr: true,
+		},
+		{
+			name:      "directory source",
+			spec:      "id=npmrc,src=" + dir,
+			shouldErr: true,
+		},
+		{
+			name:      "unknown option",
+			spec:      "id=npmrc,src=" + src + ",mode=0600",
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSecret(tt.spec)
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountSecrets(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "build-secret-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "run"), 0o755); err != nil {
+		t.Fatalf("could not create rootfs: %v", err)
+	}
+	tmpDir := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(tmpDir, 0o700); err != nil {
+		t.Fatalf("could not create bundle temporary directory: %v", err)
+	}
+
+	// source paths with bind separators are not passed as bind paths
+	src := filepath.Join(dir, "npm:rc,1")
+	if err := ioutil.WriteFile(src, []byte("token"), 0o600); err != nil {
+		t.Fatalf("could not create %s: %v", src, err)
+	}
+
+	s := newTestStage("", nil, "")
+	s.b.RootfsPath = rootfs
+	s.b.TmpDir = tmpDir
+	s.b.Opts.Secrets = []types.Secret{
+		{ID: "npmrc", Source: src},
+		{ID: "token", Source: src},
+	}
+
+	args, cleanup, err := s.mountSecrets()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	staging := filepath.Join(tmpDir, "secrets")
+	want := []string{"-B", staging + ":/run/secrets:ro"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("got %v, want %v", args, want)
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(staging, &st); err != nil {
+		t.Errorf("could not stat staging directory: %v", err)
+	} else if st.Type != unix.TMPFS_MAGIC {
+		t.Errorf("staging directory is not a tmpfs")
+	}
+	for _, id := range []string{"npmrc", "token"} {
+		b, err := ioutil.ReadFile(filepath.Join(staging, id))
+		if err != nil || string(b) != "token" {
+			t.Errorf("unexpected content for secret %s: %q (%v)", id, b, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, secretsDir)); err != nil {
+		t.Errorf("missing mount point %s: %v", secretsDir, err)
+	}
+
+	cleanup()
+
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Errorf("staging directory not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, secretsDir)); !os.IsNotExist(err) {
+		t.Errorf("%s not removed from rootfs: %v", secretsDir, err)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "run")); err != nil {
+		t.Errorf("existing /run removed from rootfs: %v", err)
+	}
+
+	s.b.Opts.Secrets = append(s.b.Opts.Secrets, types.Secret{ID: "npmrc", Source: "/tmp/npmrc"})
+	if _, _, err := s.mountSecrets(); err == nil {
+		t.Errorf("unexpected success with duplicate secret ids")
+	}
+	s.b.Opts.Secrets = []types.Secret{{ID: "missing", Source: filepath.Join(dir, "missing")}}
+	if _, _, err := s.mountSecrets(); err == nil {
+		t.Errorf("unexpected success with missing secret source")
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Errorf("staging directory not removed after failure: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, secretsDir)); !os.IsNotExist(err) {
+		t.Errorf("%s not removed from rootfs after failure: %v", secretsDir, err)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/hpcng/singularity/internal/pkg/util/bin"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+const apkRepositories = "/etc/apk/repositories"
+
+// apkHostKeysDir is the directory of the host Alpine keys used to verify
+// packages by default.
+var apkHostKeysDir = "/etc/apk/keys"
+
+// ApkConveyor holds stuff that needs to be packed into the bundle
+type ApkConveyor struct {
+	b              *types.Bundle
+	mirrorurl      string
+	updateurl      string
+	osversion      string
+	include        string
+	keysdir        string
+	allowuntrusted bool
+}
+
+// ApkConveyorPacker only needs to hold the conveyor to have the needed data to pack
+type ApkConveyorPacker struct {
+	ApkConveyor
+}
+
+// Get downloads container information from the specified source
+func (c *ApkConveyor) Get(ctx context.Context, b *types.Bundle) (err error) {
+	c.b = b
+
+	// check for apk.static or apk on system
+	var apkPath string
+	if apkPath, err = bin.FindBin("apk.static"); err == nil {
+		sylog.Debugf("Found apk.static at: %v", apkPath)
+	} else if apkPath, err = bin.FindBin("apk"); err == nil {
+		sylog.Debugf("Found apk at: %v", apkPath)
+	} else {
+		return fmt.Errorf("neither apk.static nor apk in path")
+	}
+
+	err = c.getBootstrapOptions()
+	if err != nil {
+		return fmt.Errorf("while getting bootstrap options: %v", err)
+	}
+
+	err = c.genApkConfig()
+	if err != nil {
+		return fmt.Errorf("while generating apk config: %v", err)
+	}
+
+	err = c.makePseudoDevices()
+	if err != nil {
+		return fmt.Errorf("while copying pseudo devices: %v", err)
+	}
+
+	args, err := c.apkArgs()
+	if err != nil {
+		return err
+	}
+
+	// Do the install
+	sylog.Debugf("\n\tInstall Command Path: %s\n\tDetected Arch: %s\n\tOSVersion: %s\n\tMirrorURL: %s\n\tUpdateURL: %s\n\tIncludes: %s\n", apkPath, runtime.GOARCH, c.osversion, c.mirrorurl, c.updateurl, c.include)
+	cmd := exec.Command(apkPath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("while bootstrapping: %v", err)
+	}
+
+	return nil
+}
+
+// apkArgs returns the arguments of the apk command installing the
+// packages. Packages are verified against the keys of the KeysDir header
+// directory, or of the host, package signature verification is only
+// skipped when explicitly requested with the AllowUntrusted header.
+func (c *ApkConveyor) apkArgs() ([]string, error) {
+	args := []string{`--root`, c.b.RootfsPath, `--initdb`, `--no-cache`, `--update-cache`, `--repositories-file`, filepath.Join(c.b.RootfsPath, apkRepositories)}
+
+	keysdir := c.keysdir
+	if keysdir == "" {
+		keysdir = apkHostKeysDir
+	}
+
+	if fi, err := os.Stat(keysdir); err == nil && fi.IsDir() {
+		args = append(args, `--keys-dir`, keysdir)
+	} else if c.keysdir != "" {
+		return nil, fmt.Errorf("apk keys directory %s not found", c.keysdir)
+	} else if c.allowuntrusted {
+		sylog.Warningf("No apk keys found in %s, skipping package signature verification as requested", apkHostKeysDir)
+		args = append(args, `--allow-untrusted`)
+	} else {
+		return nil, fmt.Errorf("no apk keys found in %s to verify packages: set the KeysDir header to a directory holding the Alpine keys, or AllowUntrusted to yes to skip package signature verification", apkHostKeysDir)
+	}
+
+	args = append(args, `add`)
+	return append(args, strings.Fields(c.include)...), nil
+}
+
+// Pack puts relevant objects in a Bundle!
+func (cp *ApkConveyorPacker) Pack(context.Context) (b *types.Bundle, err error) {
+	err = cp.insertBaseEnv()
+	if err != nil {
+		return nil, fmt.Errorf("while inserting base environment: %v", err)
+	}
+
+	err = cp.insertRunScript()
+	if err != nil {
+		return nil, fmt.Errorf("while inserting runscript: %v", err)
+	}
+
+	return cp.b, nil
+}
+
+func (c *ApkConveyor) getBootstrapOptions() (err error) {
+	var ok bool
+
+	// get mirrorURL, updateURL, OSVersion, and Includes components to definition
+	c.mirrorurl, ok = c.b.Recipe.Header["mirrorurl"]
+	if !ok {
+		return fmt.Errorf("invalid apk header, no mirrorurl specified")
+	}
+
+	c.updateurl = c.b.Recipe.Header["updateurl"]
+
+	// look for an OS version if a mirror specifies it
+	regex := regexp.MustCompile(`(?i)%{OSVERSION}`)
+	if regex.MatchString(c.mirrorurl) || regex.MatchString(c.updateurl) {
+		c.osversion, ok = c.b.Recipe.Header["osversion"]
+		if !ok {
+			return fmt.Errorf("invalid apk header, osversion referenced in mirror but no osversion specified")
+		}
+		c.mirrorurl = regex.ReplaceAllString(c.mirrorurl, c.osversion)
+		c.updateurl = regex.ReplaceAllString(c.updateurl, c.osversion)
+	}
+
+	c.keysdir = c.b.Recipe.Header["keysdir"]
+
+	if v, ok := c.b.Recipe.Header["allowuntrusted"]; ok {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "yes", "true":
+			c.allowuntrusted = true
+		case "no", "false":
+		default:
+			return fmt.Errorf("invalid apk header, allowuntrusted must be yes or no")
+		}
+	}
+
+	include := c.b.Recipe.Header["include"]
+
+	// check for include environment variable and add it to requires string
+	include += ` ` + os.Getenv("INCLUDE")
+
+	// trim leading and trailing whitespace
+	include = strings.TrimSpace(include)
+
+	// add a minimal base system to start of include list by default
+	include = `alpine-baselayout alpine-keys apk-tools busybox ` + include
+
+	c.include = strings.TrimSpace(include)
+
+	return nil
+}
+
+func (c *ApkConveyor) genApkConfig() (err error) {
+	// the repositories file is kept in the container so that apk
+	// can be used from %post and at runtime
+	fileContent := c.mirrorurl + "\n"
+	if c.updateurl != "" {
+		fileContent += c.updateurl + "\n"
+	}
+
+	apkDir := filepath.Join(c.b.RootfsPath, filepath.Dir(apkRepositories))
+	err = os.MkdirAll(apkDir, 0o755)
+	if err != nil {
+		return fmt.Errorf("while creating %v: %v", apkDir, err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(c.b.RootfsPath, apkRepositories), []byte(fileContent), 0o644)
+	if err != nil {
+		return fmt.Errorf("while creating %v: %v", filepath.Join(c.b.RootfsPath, apkRepositories), err)
+	}
+
+	return nil
+}
+
+//nolint:dupl
+func (c *ApkConveyor) makePseudoDevices() (err error) {
+	devPath := filepath.Join(c.b.RootfsPath, "dev")
+	err = os.Mkdir(devPath, 0o775)
+	if err != nil {
+		return fmt.Errorf("while creating %v: %v", devPath, err)
+	}
+
+	devs := []struct {
+		major int
+		minor int
+		path  string
+		mode  uint32
+	}{
+		{1, 3, "/dev/null", syscall.S_IFCHR | 0o666},
+		{1, 8, "/dev/random", syscall.S_IFCHR | 0o666},
+		{1, 9, "/dev/urandom", syscall.S_IFCHR | 0o666},
+		{1, 5, "/dev/zero", syscall.S_IFCHR | 0o666},
+	}
+
+	for _, dev := range devs {
+		d := int((dev.major << 8) | (dev.minor & 0xff) | ((dev.minor & 0xfff00) << 12))
+		path := filepath.Join(c.b.RootfsPath, dev.path)
+
+		if err := syscall.Mknod(path, dev.mode, d); err != nil {
+			return fmt.Errorf("while creating %s: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+func (cp *ApkConveyorPacker) insertBaseEnv() (err error) {
+	if err = makeBaseEnv(cp.b.RootfsPath); err != nil {
+		return
+	}
+	return nil
+}
+
+func (cp *ApkConveyorPacker) insertRunScript() (err error) {
+	err = ioutil.WriteFile(filepath.Join(cp.b.RootfsPath, "/.singularity.d/runscript"), []byte("#!/bin/sh\n"), 0o755)
+	if err != nil {
+		return
+	}
+
+	return nil
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/test"
+	"github.com/hpcng/singularity/internal/pkg/util/bin"
+	"github.com/hpcng/singularity/pkg/build/types"
+)
+
+// apkMirrorEnv points to a local Alpine mirror directory, e.g. the
+// main repository of a given release synced with rsync, used to
+// bootstrap without network access.
+const apkMirrorEnv = "SINGULARITY_TEST_APK_MIRROR"
+
+// apkKeysEnv points to a directory holding the Alpine keys verifying
+// the packages of the mirror, when the host has none.
+const apkKeysEnv = "SINGULARITY_TEST_APK_KEYS"
+
+func TestApkBootstrapOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    map[string]string
+		mirrorurl string
+		updateurl string
+		include   string
+		shouldErr bool
+	}{
+		{
+			name:      "no mirror",
+			header:    map[string]string{"osversion": "v3.14"},
+			shouldErr: true,
+		},
+		{
+			name:      "osversion missing",
+			header:    map[string]string{"mirrorurl": "http://mirror/alpine/%{OSVERSION}/main"},
+			shouldErr: true,
+		},
+		{
+			name: "osversion",
+			header: map[string]string{
+				"mirrorurl": "http://mirror/alpine/%{OSVERSION}/main",
+				"updateurl": "http://mirror/alpine/%{osversion}/community",
+				"osversion": "v3.14",
+			},
+			mirrorurl: "http://mirror/alpine/v3.14/main",
+			updateurl: "http://mirror/alpine/v3.14/community",
+			include:   "alpine-baselayout alpine-keys apk-tools busybox",
+		},
+		{
+			name: "invalid allowuntrusted",
+			header: map[string]string{
+				"mirrorurl":      "/srv/alpine/main",
+				"allowuntrusted": "maybe",
+			},
+			shouldErr: true,
+		},
+		{
+			name: "include",
+			header: map[string]string{
+				"mirrorurl": "/srv/alpine/main",
+				"include":   " bash curl ",
+			},
+			mirrorurl: "/srv/alpine/main",
+			include:   "alpine-baselayout alpine-keys apk-tools busybox bash curl",
+		},
+	}
+
+	os.Unsetenv("INCLUDE")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ApkConveyor{b: &types.Bundle{}}
+			c.b.Recipe.Header = tt.header
+
+			err := c.getBootstrapOptions()
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if c.mirrorurl != tt.mirrorurl {
+				t.Errorf("got mirrorurl %q, want %q", c.mirrorurl, tt.mirrorurl)
+			}
+			if c.updateurl != tt.updateurl {
+				t.Errorf("got updateurl %q, want %q", c.updateurl, tt.updateurl)
+			}
+			if c.include != tt.include {
+				t.Errorf("got include %q, want %q", c.include, tt.include)
+			}
+		})
+	}
+}
+
+func TestApkArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apk-keys-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostKeys := filepath.Join(dir, "host-keys")
+	userKeys := filepath.Join(dir, "user-keys")
+	if err := os.Mkdir(userKeys, 0o755); err != nil {
+		t.Fatalf("could not create keys directory: %v", err)
+	}
+
+	defer func(dir string) { apkHostKeysDir = dir }(apkHostKeysDir)
+
+	tests := []struct {
+		name           string
+		hostKeys       bool
+		keysdir        string
+		allowuntrusted bool
+		keyArgs        []string
+		shouldErr      bool
+	}{
+		{
+			name:      "no keys",
+			shouldErr: true,
+		},
+		{
+			name:     "host keys",
+			hostKeys: true,
+			keyArgs:  []string{"--keys-dir", hostKeys},
+		},
+		{
+			name:     "keysdir",
+			hostKeys: true,
+			keysdir:  userKeys,
+			keyArgs:  []string{"--keys-dir", userKeys},
+		},
+		{
+			name:           "missing keysdir",
+			keysdir:        filepath.Join(dir, "missing"),
+			allowuntrusted: true,
+			shouldErr:      true,
+		},
+		{
+			name:           "allow untrusted",
+			allowuntrusted: true,
+			keyArgs:        []string{"--allow-untrusted"},
+		},
+		{
+			name:           "host keys preferred to allow untrusted",
+			hostKeys:       true,
+			allowuntrusted: true,
+			keyArgs:        []string{"--keys-dir", hostKeys},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(hostKeys)
+			if tt.hostKeys {
+				if err := os.Mkdir(hostKeys, 0o755); err != nil {
+					t.Fatalf("could not create keys directory: %v", err)
+				}
+			}
+			apkHostKeysDir = hostKeys
+
+			c := &ApkConveyor{
+				b:              &types.Bundle{RootfsPath: "/rootfs"},
+				include:        "alpine-baselayout busybox",
+				keysdir:        tt.keysdir,
+				allowuntrusted: tt.allowuntrusted,
+			}
+
+			args, err := c.apkArgs()
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expected := []string{"--root", "/rootfs", "--initdb", "--no-cache", "--update-cache", "--repositories-file", "/rootfs/etc/apk/repositories"}
+			expected = append(expected, tt.keyArgs...)
+			expected = append(expected, "add", "alpine-baselayout", "busybox")
+			if !reflect.DeepEqual(args, expected) {
+				t.Errorf("got arguments %v, want %v", args, expected)
+			}
+		})
+	}
+}
+
+func TestApkConveyorPacker(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	mirror := os.Getenv(apkMirrorEnv)
+	if mirror == "" {
+		t.Skipf("skipping test, %s not set", apkMirrorEnv)
+	}
+
+	_, staticErr := bin.FindBin("apk.static")
+	_, apkErr := bin.FindBin("apk")
+	if staticErr != nil && apkErr != nil {
+		t.Skip("skipping test, neither apk.static nor apk found")
+	}
+
+	test.EnsurePrivilege(t)
+
+	b, err := types.NewBundle(filepath.Join(os.TempDir(), "sbuild-apk"), os.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create bundle: %v", err)
+	}
+
+	b.Recipe.Header = map[string]string{
+		"bootstrap": "apk",
+		"mirrorurl": mirror,
+	}
+	if keys := os.Getenv(apkKeysEnv); keys != "" {
+		b.Recipe.Header["keysdir"] = keys
+	}
+
+	cp := &ApkConveyorPacker{}
+
+	err = cp.Get(context.Background(), b)
+	// clean up bundle since assembler isn't called
+	defer cp.b.Remove()
+	if err != nil {
+		t.Fatalf("failed to Get from %s: %v", mirror, err)
+	}
+
+	_, err = cp.Pack(context.Background())
+	if err != nil {
+		t.Fatalf("failed to Pack from %s: %v", mirror, err)
+	}
+
+	repos, err := ioutil.ReadFile(filepath.Join(b.RootfsPath, apkRepositories))
+	if err != nil {
+		t.Fatalf("failed to read repositories: %v", err)
+	}
+	if strings.TrimSpace(string(repos)) != mirror {
+		t.Errorf("unexpected repositories %q", repos)
+	}
+	if _, err := os.Stat(filepath.Join(b.RootfsPath, "bin/busybox")); err != nil {
+		t.Errorf("busybox not installed: %v", err)
+	}
+}
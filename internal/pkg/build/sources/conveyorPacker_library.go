@@ -10,6 +10,7 @@ import (
 	"context"
 	"fmt"
 	"runtime"
+	"strings"
 
 	golog "github.com/go-log/log"
 
@@ -23,26 +24,18 @@ import (
 // as well as extra information about the library it's pulling from
 type LibraryConveyorPacker struct {
 	b *types.Bundle
+	// digest is the digest of the image returned by SourceDigest, the
+	// pulled image must match it.
+	digest string
 	LocalPacker
 }
 
-// Get downloads container from Sylabs Cloud Library.
-func (cp *LibraryConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err error) {
-	sylog.Debugf("Getting container from Library")
-
-	if b.Opts.ImgCache == nil {
-		return fmt.Errorf("invalid image cache")
-	}
-
-	cp.b = b
-
+// libraryRef returns the reference of the library image designated by the
+// definition header of b and the configuration of the library hosting it.
+func libraryRef(b *types.Bundle) (*client.Ref, *client.Config, error) {
 	libraryURL := b.Opts.LibraryURL
 	authToken := b.Opts.LibraryAuthToken
 
-	if err = makeBaseEnv(cp.b.RootfsPath); err != nil {
-		return fmt.Errorf("while inserting base environment: %v", err)
-	}
-
 	// check for custom library from definition
 	customLib, ok := b.Recipe.Header["library"]
 	if ok {
@@ -52,7 +45,7 @@ func (cp *LibraryConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err
 
 	imageRef, err := library.NormalizeLibraryRef(b.Recipe.Header["from"])
 	if err != nil {
-		return fmt.Errorf("error parsing libraryRef: %v", err)
+		return nil, nil, fmt.Errorf("error parsing libraryRef: %v", err)
 	}
 
 	if imageRef.Host != "" {
@@ -71,12 +64,50 @@ func (cp *LibraryConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err
 		AuthToken: authToken,
 		Logger:    (golog.Logger)(sylog.DebugLogger{}),
 	}
+	return imageRef, libraryConfig, nil
+}
+
+// libraryDigest returns the "<algorithm>:<hex>" digest of a library image
+// hash, recorded as "<algorithm>.<hex>".
+func libraryDigest(hash string) string {
+	return strings.Replace(hash, ".", ":", 1)
+}
+
+// Get downloads container from Sylabs Cloud Library.
+func (cp *LibraryConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err error) {
+	sylog.Debugf("Getting container from Library")
+
+	if b.Opts.ImgCache == nil {
+		return fmt.Errorf("invalid image cache")
+	}
+
+	cp.b = b
+
+	if err = makeBaseEnv(cp.b.RootfsPath); err != nil {
+		return fmt.Errorf("while inserting base environment: %v", err)
+	}
+
+	imageRef, libraryConfig, err := libraryRef(b)
+	if err != nil {
+		return err
+	}
 
 	imagePath, err := library.Pull(ctx, b.Opts.ImgCache, imageRef, runtime.GOARCH, cp.b.TmpDir, libraryConfig)
 	if err != nil {
 		return fmt.Errorf("while fetching library image: %v", err)
 	}
 
+	// the image was identified before being pulled
+	if cp.digest != "" {
+		hash, err := client.ImageHash(imagePath)
+		if err != nil {
+			return fmt.Errorf("while computing library image hash: %v", err)
+		}
+		if d := libraryDigest(hash); d != cp.digest {
+			return fmt.Errorf("library image %s changed during the build: got digest %s, expected %s", b.Recipe.Header["from"], d, cp.digest)
+		}
+	}
+
 	// insert base metadata before unpacking fs
 	if err = makeBaseEnv(cp.b.RootfsPath); err != nil {
 		return fmt.Errorf("while inserting base environment: %v", err)
@@ -87,6 +118,23 @@ func (cp *LibraryConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err
 	return err
 }
 
+// SourceDigest returns the digest of the library image designated by the
+// definition header of b, as recorded by the library, it is used to identify
+// the source of cached build stages.
+func (cp *LibraryConveyorPacker) SourceDigest(ctx context.Context, b *types.Bundle) (string, error) {
+	imageRef, libraryConfig, err := libraryRef(b)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := library.GetImageHash(ctx, imageRef, runtime.GOARCH, libraryConfig)
+	if err != nil {
+		return "", err
+	}
+	cp.digest = libraryDigest(hash)
+	return cp.digest, nil
+}
+
 // CleanUp removes any files owned by the conveyorPacker on the filesystem.
 func (cp *LibraryConveyorPacker) CleanUp() {
 	cp.b.Remove()
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -7,7 +7,11 @@ package sources
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -115,3 +119,30 @@ func (cp *LocalConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err er
 	cp.LocalPacker, err = GetLocalPacker(ctx, cp.src, b)
 	return err
 }
+
+// SourceDigest returns the digest of the local image file designated by
+// the definition header of b, it is used to identify the source of cached
+// build stages. Sandbox images have no digest and return an error.
+func (cp *LocalConveyorPacker) SourceDigest(ctx context.Context, b *types.Bundle) (string, error) {
+	src := filepath.Clean(b.Recipe.Header["from"])
+
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("%s is a sandbox image", src)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
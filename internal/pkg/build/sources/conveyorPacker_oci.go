@@ -7,26 +7,23 @@
 package sources
 
 import (
-	"archive/tar"
-	"bufio"
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/containers/image/v5/copy"
 	"github.com/containers/image/v5/docker"
 	dockerarchive "github.com/containers/image/v5/docker/archive"
 	dockerdaemon "github.com/containers/image/v5/docker/daemon"
-	ociarchive "github.com/containers/image/v5/oci/archive"
+	"github.com/containers/image/v5/docker/reference"
 	ocilayout "github.com/containers/image/v5/oci/layout"
 	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports"
 	"github.com/containers/image/v5/types"
 	"github.com/hpcng/singularity/internal/pkg/build/oci"
 	"github.com/hpcng/singularity/internal/pkg/util/shell"
@@ -35,6 +32,7 @@ import (
 	"github.com/hpcng/singularity/pkg/syfs"
 	"github.com/hpcng/singularity/pkg/sylog"
 	useragent "github.com/hpcng/singularity/pkg/util/user-agent"
+	digest "github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -46,6 +44,8 @@ type OCIConveyorPacker struct {
 	policyCtx *signature.PolicyContext
 	imgConfig imgspecv1.ImageConfig
 	sysCtx    *types.SystemContext
+	// digest is the digest of the image resolved by Get
+	digest string
 }
 
 // Get downloads container information from the specified source
@@ -58,31 +58,9 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 		return err
 	}
 
-	// DockerInsecureSkipTLSVerify is set only if --no-https is specified to honor
-	// configuration from /etc/containers/registries.conf because DockerInsecureSkipTLSVerify
-	// can have three possible values true/false and undefined, so we left it as undefined instead
-	// of forcing it to false in order to delegate decision to /etc/containers/registries.conf:
-	// https://github.com/hpcng/singularity/issues/5172
-	cp.sysCtx = &types.SystemContext{
-		OCIInsecureSkipTLSVerify: cp.b.Opts.NoHTTPS,
-		DockerAuthConfig:         cp.b.Opts.DockerAuthConfig,
-		OSChoice:                 "linux",
-		AuthFilePath:             syfs.DockerConf(),
-		DockerRegistryUserAgent:  useragent.Value(),
-		BigFilesTemporaryDir:     b.TmpDir,
-	}
-	if cp.b.Opts.NoHTTPS {
-		cp.sysCtx.DockerInsecureSkipTLSVerify = types.NewOptionalBool(true)
-	}
+	cp.sysCtx = systemContext(b)
 
-	// add registry and namespace to reference if specified
-	ref := b.Recipe.Header["from"]
-	if b.Recipe.Header["namespace"] != "" {
-		ref = b.Recipe.Header["namespace"] + "/" + ref
-	}
-	if b.Recipe.Header["registry"] != "" {
-		ref = b.Recipe.Header["registry"] + "/" + ref
-	}
+	ref := imageReference(b)
 	sylog.Debugf("Reference: %v", ref)
 
 	switch b.Recipe.Header["bootstrap"] {
@@ -93,37 +71,10 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 		cp.srcRef, err = dockerarchive.ParseReference(ref)
 	case "docker-daemon":
 		cp.srcRef, err = dockerdaemon.ParseReference(ref)
-	case "oci":
-		cp.srcRef, err = ocilayout.ParseReference(ref)
-	case "oci-archive":
-		if os.Geteuid() == 0 {
-			// As root, the direct oci-archive handling will work
-			cp.srcRef, err = ociarchive.ParseReference(ref)
-		} else {
-			// As non-root we need to do a dumb tar extraction first
-			tmpDir, err := ioutil.TempDir(b.TmpDir, "temp-oci-")
-			if err != nil {
-				return fmt.Errorf("could not create temporary oci directory: %v", err)
-			}
-			defer os.RemoveAll(tmpDir)
-
-			refParts := strings.SplitN(b.Recipe.Header["from"], ":", 2)
-			err = cp.extractArchive(refParts[0], tmpDir)
-			if err != nil {
-				return fmt.Errorf("error extracting the OCI archive file: %v", err)
-			}
-			// We may or may not have had a ':tag' in the source to handle
-			if len(refParts) == 2 {
-				cp.srcRef, err = ocilayout.ParseReference(tmpDir + ":" + refParts[1])
-			} else {
-				cp.srcRef, err = ocilayout.ParseReference(tmpDir)
-			}
-
-			if err != nil {
-				return fmt.Errorf("error parsing reference: %v", err)
-			}
-		}
-
+	case "oci", "oci-archive":
+		var cleanup func()
+		cp.srcRef, cleanup, err = oci.ParseLocalReference(b.Recipe.Header["bootstrap"], ref, b.TmpDir, cp.sysCtx)
+		defer cleanup()
 	default:
 		return fmt.Errorf("oci conveyorPacker does not support %s", b.Recipe.Header["bootstrap"])
 	}
@@ -132,6 +83,20 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 		return fmt.Errorf("invalid image source: %v", err)
 	}
 
+	// the image digest is resolved once, registry images are then pulled
+	// by digest so that the image pulled is the one resolved
+	sum, err := oci.ImageDigest(ctx, cp.srcRef, cp.sysCtx)
+	if err != nil {
+		return fmt.Errorf("while resolving image digest: %v", err)
+	}
+	cp.digest = "sha256:" + sum
+	if b.Recipe.Header["bootstrap"] == "docker" {
+		cp.srcRef, err = pinDigest(cp.srcRef, cp.digest)
+		if err != nil {
+			return fmt.Errorf("while pinning image digest: %v", err)
+		}
+	}
+
 	if !cp.b.Opts.NoCache {
 		// Grab the modified source ref from the cache
 		cp.srcRef, err = oci.ConvertReference(ctx, b.Opts.ImgCache, cp.srcRef, cp.sysCtx)
@@ -157,6 +122,82 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 	return nil
 }
 
+// SourceDigest returns the digest of the image designated by the
+// definition header of b, it is used to identify the source of cached
+// build stages.
+func (cp *OCIConveyorPacker) SourceDigest(ctx context.Context, b *sytypes.Bundle) (string, error) {
+	bootstrap := b.Recipe.Header["bootstrap"]
+	ref := imageReference(b)
+	if bootstrap == "docker" {
+		ref = "//" + ref
+	}
+	digest, err := oci.ImageSHA(ctx, bootstrap+":"+ref, systemContext(b))
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + digest, nil
+}
+
+// ResolvedDigest returns the digest of the image resolved and pulled by
+// Get, it's empty if Get wasn't called.
+func (cp *OCIConveyorPacker) ResolvedDigest() string {
+	return cp.digest
+}
+
+// pinDigest returns the reference of the registry image ref designated
+// by its digest.
+func pinDigest(ref types.ImageReference, d string) (types.ImageReference, error) {
+	named := ref.DockerReference()
+	if named == nil {
+		return nil, fmt.Errorf("%s is not a registry image", transports.ImageName(ref))
+	}
+	canonical, err := reference.WithDigest(reference.TrimNamed(named), digest.Digest(d))
+	if err != nil {
+		return nil, err
+	}
+	return docker.NewReference(canonical)
+}
+
+// systemContext returns the containers/image system context used to
+// retrieve the image designated by the definition header of b.
+func systemContext(b *sytypes.Bundle) *types.SystemContext {
+	// DockerInsecureSkipTLSVerify is set only if --no-https is specified to honor
+	// configuration from /etc/containers/registries.conf because DockerInsecureSkipTLSVerify
+	// can have three possible values true/false and undefined, so we left it as undefined instead
+	// of forcing it to false in order to delegate decision to /etc/containers/registries.conf:
+	// https://github.com/hpcng/singularity/issues/5172
+	sysCtx := &types.SystemContext{
+		OCIInsecureSkipTLSVerify: b.Opts.NoHTTPS,
+		DockerAuthConfig:         b.Opts.DockerAuthConfig,
+		OSChoice:                 "linux",
+		AuthFilePath:             syfs.DockerConf(),
+		DockerRegistryUserAgent:  useragent.Value(),
+		BigFilesTemporaryDir:     b.TmpDir,
+	}
+	// select the requested platform from multi-architecture images, the
+	// host architecture is selected by default
+	if b.Opts.Arch != "" && b.Opts.Arch != runtime.GOARCH {
+		sysCtx.ArchitectureChoice = b.Opts.Arch
+	}
+	if b.Opts.NoHTTPS {
+		sysCtx.DockerInsecureSkipTLSVerify = types.NewOptionalBool(true)
+	}
+	return sysCtx
+}
+
+// imageReference returns the image reference from the definition
+// header of b, with the registry and namespace added if specified.
+func imageReference(b *sytypes.Bundle) string {
+	ref := b.Recipe.Header["from"]
+	if b.Recipe.Header["namespace"] != "" {
+		ref = b.Recipe.Header["namespace"] + "/" + ref
+	}
+	if b.Recipe.Header["registry"] != "" {
+		ref = b.Recipe.Header["registry"] + "/" + ref
+	}
+	return ref
+}
+
 // Pack puts relevant objects in a Bundle.
 func (cp *OCIConveyorPacker) Pack(ctx context.Context) (*sytypes.Bundle, error) {
 	err := cp.unpackTmpfs(ctx)
@@ -225,83 +266,6 @@ func (cp *OCIConveyorPacker) insertOCIConfig() error {
 	return nil
 }
 
-// Perform a dumb tar(gz) extraction with no chown, id remapping etc.
-// This is needed for non-root handling of `oci-archive` as the extraction
-// by containers/archive is failing when uid/gid don't match local machine
-// and we're not root
-func (cp *OCIConveyorPacker) extractArchive(src string, dst string) error {
-	f, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	r := bufio.NewReader(f)
-	header, err := r.Peek(10) // read a few bytes without consuming
-	if err != nil {
-		return err
-	}
-	gzipped := strings.Contains(http.DetectContentType(header), "x-gzip")
-
-	if gzipped {
-		r, err := gzip.NewReader(f)
-		if err != nil {
-			return err
-		}
-		defer r.Close()
-	}
-
-	tr := tar.NewReader(r)
-
-	for {
-		header, err := tr.Next()
-
-		switch {
-
-		// if no more files are found return
-		case err == io.EOF:
-			return nil
-
-		// return any other error
-		case err != nil:
-			return err
-
-		// if the header is nil, just skip it (not sure how this happens)
-		case header == nil:
-			continue
-		}
-
-		// ZipSlip protection - don't escape from dst
-		target := filepath.Join(dst, header.Name)
-		if !strings.HasPrefix(target, filepath.Clean(dst)+string(os.PathSeparator)) {
-			return fmt.Errorf("%s: illegal extraction path", target)
-		}
-
-		// check the file type
-		switch header.Typeflag {
-		// if its a dir and it doesn't exist create it
-		case tar.TypeDir:
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, 0o755); err != nil {
-					return err
-				}
-			}
-		// if it's a file create it
-		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-
-			// copy over contents
-			if _, err := io.Copy(f, tr); err != nil {
-				return err
-			}
-		}
-	}
-}
-
 func (cp *OCIConveyorPacker) unpackTmpfs(ctx context.Context) error {
 	return unpackRootfs(ctx, cp.b, cp.tmpfsRef, cp.sysCtx)
 }
@@ -17,6 +17,9 @@ import (
 // OrasConveyorPacker only needs to hold a packer to pack the image it pulls
 // as well as extra information about the library it's pulling from.
 type OrasConveyorPacker struct {
+	// digest is the digest of the image returned by SourceDigest, the
+	// pulled image must match it.
+	digest string
 	LocalPacker
 }
 
@@ -34,6 +37,17 @@ func (cp *OrasConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err err
 		return fmt.Errorf("while fetching library image: %v", err)
 	}
 
+	// the image was identified before being pulled
+	if cp.digest != "" {
+		digest, err := oras.ImageHash(imagePath)
+		if err != nil {
+			return fmt.Errorf("while computing image digest: %v", err)
+		}
+		if digest != cp.digest {
+			return fmt.Errorf("image %s changed during the build: got digest %s, expected %s", fullRef, digest, cp.digest)
+		}
+	}
+
 	// insert base metadata before unpacking fs
 	if err = makeBaseEnv(b.RootfsPath); err != nil {
 		return fmt.Errorf("while inserting base environment: %v", err)
@@ -42,3 +56,15 @@ func (cp *OrasConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err err
 	cp.LocalPacker, err = GetLocalPacker(ctx, imagePath, b)
 	return err
 }
+
+// SourceDigest returns the digest of the SIF image designated by the
+// definition header of b, as stored in the registry, it is used to identify
+// the source of cached build stages.
+func (cp *OrasConveyorPacker) SourceDigest(ctx context.Context, b *types.Bundle) (string, error) {
+	digest, err := oras.ImageSHA(ctx, "//"+b.Recipe.Header["from"], b.Opts.DockerAuthConfig)
+	if err != nil {
+		return "", err
+	}
+	cp.digest = digest
+	return digest, nil
+}
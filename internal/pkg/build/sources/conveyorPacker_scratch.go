@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -31,6 +31,12 @@ func (c *ScratchConveyor) Get(ctx context.Context, b *types.Bundle) (err error)
 	return nil
 }
 
+// SourceDigest returns a constant digest as a scratch build has no
+// source, it is used to identify the source of cached build stages.
+func (c *ScratchConveyor) SourceDigest(context.Context, *types.Bundle) (string, error) {
+	return "scratch", nil
+}
+
 // Pack puts relevant objects in a Bundle!
 func (cp *ScratchConveyorPacker) Pack(context.Context) (b *types.Bundle, err error) {
 	err = cp.insertBaseEnv()
@@ -7,6 +7,7 @@ package build
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -29,6 +30,21 @@ type stage struct {
 	a Assembler
 	// b is an intermediate structure that encapsulates all information for the container, e.g., metadata, filesystems.
 	b *types.Bundle
+	// prefix is prepended to the log messages and output lines of the stage
+	// when stages are built concurrently.
+	prefix string
+	// stdout and stderr receive the output of the stage scripts.
+	stdout io.Writer
+	stderr io.Writer
+	// log captures the output of the stage scripts, it is nil when the
+	// build log is not stored in the image.
+	log *buildLog
+	// cgroupsPath is the path of the cgroups configuration applied to the
+	// %post and %test scripts, if any.
+	cgroupsPath string
+	// sourceDigest is the digest of the bootstrap source the stage was
+	// built from, if known.
+	sourceDigest string
 }
 
 const (
@@ -64,12 +80,13 @@ func (s *stage) runSectionScript(name string, script types.Script) error {
 
 		// Run script section here
 		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = s.stdout
+		cmd.Stderr = s.stderr
 		cmd.Env = os.Environ()
 		cmd.Env = append(cmd.Env, sEnvironment, sRootfs)
 
-		sylog.Infof("Running %s scriptlet", name)
+		sylog.Infof("%sRunning %s scriptlet", s.prefix, name)
+		s.logMarker("%%%s", name)
 		if err := cmd.Run(); err != nil {
 			return fmt.Errorf("failed to run %%%s script: %v", name, err)
 		}
@@ -88,6 +105,16 @@ func (s *stage) runPostScript(configFile, sessionResolv, sessionHosts string) er
 		if sessionHosts != "" {
 			cmdArgs = append(cmdArgs, "-B", sessionHosts+":/etc/hosts")
 		}
+		if s.cgroupsPath != "" {
+			cmdArgs = append(cmdArgs, "--apply-cgroups", s.cgroupsPath)
+		}
+
+		secretArgs, cleanupSecrets, err := s.mountSecrets()
+		if err != nil {
+			return fmt.Errorf("while preparing build secrets: %s", err)
+		}
+		defer cleanupSecrets()
+		cmdArgs = append(cmdArgs, secretArgs...)
 
 		script := s.b.Recipe.BuildData.Post
 		scriptPath := filepath.Join(s.b.RootfsPath, ".post.script")
@@ -106,12 +133,13 @@ func (s *stage) runPostScript(configFile, sessionResolv, sessionHosts string) er
 		cmdArgs = append(cmdArgs, s.b.RootfsPath)
 		cmdArgs = append(cmdArgs, args...)
 		cmd := exec.Command(exe, cmdArgs...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = s.stdout
+		cmd.Stderr = s.stderr
 		cmd.Dir = "/"
 		cmd.Env = currentEnvNoSingularity([]string{"NV", "NVCCLI", "ROCM", "BINDPATH", "MOUNT"})
 
-		sylog.Infof("Running post scriptlet")
+		sylog.Infof("%sRunning post scriptlet", s.prefix)
+		s.logMarker("%%post")
 		return cmd.Run()
 	}
 	return nil
@@ -127,17 +155,21 @@ func (s *stage) runTestScript(configFile, sessionResolv, sessionHosts string) er
 		if sessionHosts != "" {
 			cmdArgs = append(cmdArgs, "-B", sessionHosts+":/etc/hosts")
 		}
+		if s.cgroupsPath != "" {
+			cmdArgs = append(cmdArgs, "--apply-cgroups", s.cgroupsPath)
+		}
 
 		exe := filepath.Join(buildcfg.BINDIR, "singularity")
 
 		cmdArgs = append(cmdArgs, s.b.RootfsPath)
 		cmd := exec.Command(exe, cmdArgs...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = s.stdout
+		cmd.Stderr = s.stderr
 		cmd.Dir = "/"
 		cmd.Env = currentEnvNoSingularity([]string{"NV", "NVCCLI", "ROCM", "BINDPATH", "MOUNT", "WRITABLE_TMPFS"})
 
-		sylog.Infof("Running testscript")
+		sylog.Infof("%sRunning testscript", s.prefix)
+		s.logMarker("%%test")
 		return cmd.Run()
 	}
 	return nil
@@ -165,17 +197,17 @@ func (s *stage) copyFilesFrom(b *Build) error {
 		srcRootfsPath := b.stages[stageIndex].b.RootfsPath
 		dstRootfsPath := s.b.RootfsPath
 
-		sylog.Debugf("Copying files from stage: %s", args[1])
+		sylog.Debugf("%sCopying files from stage: %s", s.prefix, args[1])
 
 		// iterate through filetransfers
 		for _, transfer := range f.Files {
 			// sanity
 			if transfer.Src == "" {
-				sylog.Warningf("Attempt to copy file with no name, skipping.")
+				sylog.Warningf("%sAttempt to copy file with no name, skipping.", s.prefix)
 				continue
 			}
 			// copy each file into bundle rootfs
-			sylog.Infof("Copying %v to %v", transfer.Src, transfer.Dst)
+			sylog.Infof("%sCopying %v to %v", s.prefix, transfer.Src, transfer.Dst)
 			if err := files.CopyFromStage(transfer.Src, transfer.Dst, srcRootfsPath, dstRootfsPath); err != nil {
 				return err
 			}
@@ -199,11 +231,11 @@ func (s *stage) copyFiles() error {
 	for _, transfer := range filesSection.Files {
 		// sanity
 		if transfer.Src == "" {
-			sylog.Warningf("Attempt to copy file with no name, skipping.")
+			sylog.Warningf("%sAttempt to copy file with no name, skipping.", s.prefix)
 			continue
 		}
 		// copy each file into bundle rootfs
-		sylog.Infof("Copying %v to %v", transfer.Src, transfer.Dst)
+		sylog.Infof("%sCopying %v to %v", s.prefix, transfer.Src, transfer.Dst)
 		if err := files.CopyFromHost(transfer.Src, transfer.Dst, s.b.RootfsPath); err != nil {
 			return err
 		}
@@ -0,0 +1,239 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/cache"
+	"github.com/hpcng/singularity/internal/pkg/image/packer"
+	"github.com/hpcng/singularity/internal/pkg/image/unpacker"
+	"github.com/hpcng/singularity/internal/pkg/util/fs/squashfs"
+	"github.com/hpcng/singularity/pkg/build/types"
+)
+
+// sourceDigester is implemented by the conveyor packers able to identify
+// the content of their bootstrap source, only stages bootstrapped from
+// such sources can be cached.
+type sourceDigester interface {
+	SourceDigest(ctx context.Context, b *types.Bundle) (string, error)
+}
+
+// resolvedDigester is implemented by the conveyor packers recording the
+// digest of the bootstrap source they retrieved.
+type resolvedDigester interface {
+	ResolvedDigest() string
+}
+
+// stageKeyData holds everything determining the root filesystem produced
+// by a stage, the cache key of a stage is the digest of its JSON encoding.
+type stageKeyData struct {
+	Definition   types.Definition  `json:"definition"`
+	Arch         string            `json:"arch"`
+	Sections     []string          `json:"sections"`
+	FixPerms     bool              `json:"fixPerms"`
+	SourceDigest string            `json:"sourceDigest"`
+	FromStages   map[string]string `json:"fromStages"`
+	HostFiles    map[string]string `json:"hostFiles"`
+}
+
+// useStageCache returns whether the cache may be used for the intermediate
+// stages of the build.
+func (b *Build) useStageCache() bool {
+	opts := b.Conf.Opts
+	return len(b.stages) > 1 && !opts.NoCache && !opts.NoStageCache &&
+		opts.ImgCache != nil && !opts.ImgCache.IsDisabled()
+}
+
+// stageKey returns the cache key of the stage at index i, keys holds the
+// keys of the previous stages, an empty key denoting a stage which can't
+// be cached. An error is returned if the stage can't be cached.
+func (b *Build) stageKey(ctx context.Context, i int, keys []string) (string, error) {
+	s := &b.stages[i]
+
+	d, ok := s.c.(sourceDigester)
+	if !ok {
+		return "", fmt.Errorf("%s bootstrap source can't be identified", s.b.Recipe.Header["bootstrap"])
+	}
+	digest, err := d.SourceDigest(ctx, s.b)
+	if err != nil {
+		return "", fmt.Errorf("while computing bootstrap source digest: %v", err)
+	}
+	// a stage restored from the cache was built from this source
+	s.sourceDigest = digest
+
+	data := stageKeyData{
+		Definition:   s.b.Recipe,
+		Arch:         s.b.Opts.Arch,
+		Sections:     s.b.Opts.Sections,
+		FixPerms:     s.b.Opts.FixPerms,
+		SourceDigest: digest,
+		FromStages:   make(map[string]string),
+		HostFiles:    make(map[string]string),
+	}
+	// the raw definition holds the whole file for the last stage and
+	// comments, it's already represented by the parsed sections
+	data.Definition.Raw = nil
+
+	for _, f := range s.b.Recipe.BuildData.Files {
+		// Trim comments from args
+		args := strings.Fields(strings.Split(f.Args, "#")[0])
+		switch len(args) {
+		case 0:
+			for _, transfer := range f.Files {
+				if transfer.Src == "" {
+					continue
+				}
+				sum, err := hostFilesDigest(transfer.Src)
+				if err != nil {
+					return "", fmt.Errorf("while computing digest of %s: %v", transfer.Src, err)
+				}
+				data.HostFiles[transfer.Src] = sum
+			}
+		case 2:
+			stageIndex, err := b.findStageIndex(args[1])
+			if err != nil {
+				return "", err
+			}
+			if stageIndex >= i || keys[stageIndex] == "" {
+				return "", fmt.Errorf("stage %s can't be cached", args[1])
+			}
+			data.FromStages[args[1]] = keys[stageIndex]
+		}
+	}
+
+	content, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// hostFilesDigest returns a digest of the names, modes and contents of the
+// host files matching the pattern src, directories are walked recursively.
+func hostFilesDigest(src string) (string, error) {
+	paths, err := filepath.Glob(src)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "", fmt.Errorf("no such file or directory")
+	}
+
+	h := sha256.New()
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00%o\x00", path, fi.Mode())
+
+			switch {
+			case fi.Mode()&os.ModeSymlink != 0:
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(h, "%s\x00", target)
+			case fi.Mode().IsRegular():
+				f, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				if _, err := io.Copy(h, f); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// restoreStage extracts the cached root filesystem of the stage s, it
+// returns false if there is no cache entry for key.
+func restoreStage(s *stage, key string) (bool, error) {
+	entry, err := s.b.Opts.ImgCache.GetEntry(cache.StageCacheType, key)
+	if err != nil {
+		return false, fmt.Errorf("unable to check if stage exists in cache: %v", err)
+	}
+	if entry == nil {
+		return false, nil
+	}
+	defer entry.CleanTmp()
+	if !entry.Exists {
+		return false, nil
+	}
+
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if err := unpacker.NewSquashfs().ExtractAll(f, s.b.RootfsPath); err != nil {
+		return false, fmt.Errorf("while extracting cached stage: %v", err)
+	}
+	return true, nil
+}
+
+// storeStage stores the root filesystem of the stage s in the cache,
+// as a squashfs image.
+func storeStage(s *stage, key string) error {
+	entry, err := s.b.Opts.ImgCache.GetEntry(cache.StageCacheType, key)
+	if err != nil {
+		return fmt.Errorf("unable to check if stage exists in cache: %v", err)
+	}
+	if entry == nil {
+		return nil
+	}
+	defer entry.CleanTmp()
+	// the stage was cached concurrently
+	if entry.Exists {
+		return nil
+	}
+	entry.Source = "stage:" + s.name
+
+	sqfs := packer.NewSquashfs()
+	sqfs.MksquashfsPath, err = squashfs.GetPath()
+	if err != nil {
+		return fmt.Errorf("while searching for mksquashfs: %v", err)
+	}
+
+	flags := []string{"-noappend"}
+	mksquashfsProcs, err := squashfs.GetProcs()
+	if err != nil {
+		return fmt.Errorf("while searching for mksquashfs processor limits: %v", err)
+	}
+	mksquashfsMem, err := squashfs.GetMem()
+	if err != nil {
+		return fmt.Errorf("while searching for mksquashfs mem limits: %v", err)
+	}
+	if mksquashfsMem != "" {
+		flags = append(flags, "-mem", mksquashfsMem)
+	}
+	if mksquashfsProcs != 0 {
+		flags = append(flags, "-processors", fmt.Sprint(mksquashfsProcs))
+	}
+
+	if err := sqfs.Create([]string{s.b.RootfsPath}, entry.TmpPath, flags); err != nil {
+		return fmt.Errorf("while creating squashfs: %v", err)
+	}
+	return entry.Finalize()
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/build/sources"
+	"github.com/hpcng/singularity/pkg/build/types"
+)
+
+func newTestStage(name string, c ConveyorPacker, post string, files ...types.Files) stage {
+	b := &types.Bundle{}
+	b.Recipe.Header = map[string]string{"bootstrap": "scratch", "stage": name}
+	b.Recipe.BuildData.Post.Script = post
+	b.Recipe.BuildData.Files = files
+	return stage{name: name, c: c, b: b}
+}
+
+func TestStageKey(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "stage-key-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostFile := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(hostFile, []byte("one"), 0o644); err != nil {
+		t.Fatalf("could not create %s: %v", hostFile, err)
+	}
+
+	hostFiles := types.Files{Files: []types.FileTransport{{Src: filepath.Join(dir, "*"), Dst: "/opt"}}}
+	fromStage := types.Files{Args: "stage one", Files: []types.FileTransport{{Src: "/file", Dst: "/file"}}}
+
+	keys := func(b *Build) []string {
+		k := make([]string, len(b.stages))
+		for i := range b.stages {
+			k[i], _ = b.stageKey(ctx, i, k)
+		}
+		return k
+	}
+	newBuild := func(post string) *Build {
+		return &Build{stages: []stage{
+			newTestStage("one", &sources.ScratchConveyorPacker{}, post, hostFiles),
+			newTestStage("two", &sources.ScratchConveyorPacker{}, "true", fromStage),
+		}}
+	}
+
+	ref := keys(newBuild("true"))
+	if ref[0] == "" || ref[1] == "" {
+		t.Fatalf("unexpected uncacheable stage: %v", ref)
+	}
+	if got := keys(newBuild("true")); got[0] != ref[0] || got[1] != ref[1] {
+		t.Errorf("keys changed for the same build: %v != %v", got, ref)
+	}
+
+	// a change of a stage changes the keys of stages copying from it
+	if got := keys(newBuild("false")); got[0] == ref[0] || got[1] == ref[1] {
+		t.Errorf("keys unchanged after %%post change: %v", got)
+	}
+
+	if err := ioutil.WriteFile(hostFile, []byte("two"), 0o644); err != nil {
+		t.Fatalf("could not write %s: %v", hostFile, err)
+	}
+	if got := keys(newBuild("true")); got[0] == ref[0] || got[1] == ref[1] {
+		t.Errorf("keys unchanged after host file change: %v", got)
+	}
+
+	// stages without identifiable source and stages copying from
+	// them can't be cached
+	b := newBuild("true")
+	b.stages[0].c = &sources.YumConveyorPacker{}
+	if got := keys(b); got[0] != "" || got[1] != "" {
+		t.Errorf("unexpected cacheable stage: %v", got)
+	}
+}
@@ -24,7 +24,7 @@ import (
 )
 
 // ConvertOciToSIF will convert an OCI source into a SIF using the build routines
-func ConvertOciToSIF(ctx context.Context, imgCache *cache.Handle, image, cachedImgPath, tmpDir string, noHTTPS, noCleanUp bool, authConf *ocitypes.DockerAuthConfig) error {
+func ConvertOciToSIF(ctx context.Context, imgCache *cache.Handle, image, arch, cachedImgPath, tmpDir string, noHTTPS, noCleanUp bool, authConf *ocitypes.DockerAuthConfig) error {
 	if imgCache == nil {
 		return fmt.Errorf("image cache is undefined")
 	}
@@ -42,6 +42,7 @@ func ConvertOciToSIF(ctx context.Context, imgCache *cache.Handle, image, cachedI
 				NoHTTPS:          noHTTPS,
 				DockerAuthConfig: authConf,
 				ImgCache:         imgCache,
+				Arch:             arch,
 			},
 		},
 	)
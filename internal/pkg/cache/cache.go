@@ -29,6 +29,12 @@ const (
 	DirEnv = "SINGULARITY_CACHEDIR"
 	// DisableEnv specifies whether the image should be used
 	DisableEnv = "SINGULARITY_DISABLE_CACHE"
+	// MaxSizeEnv specifies the environment variable which can set the
+	// maximum total size of the cache, e.g. "20GiB"
+	MaxSizeEnv = "SINGULARITY_CACHE_MAXSIZE"
+	// TypeMaxSizeEnv specifies the environment variable which can set the
+	// maximum size of individual cache types, e.g. "library=5GiB,blob=10GiB"
+	TypeMaxSizeEnv = "SINGULARITY_CACHE_TYPE_MAXSIZE"
 	// SubDirName specifies the name of the directory relative to the
 	// ParentDir specified when the cache is created.
 	// By default the cache will be placed at "~/.singularity/cache" which
@@ -47,6 +53,9 @@ const (
 	OrasCacheType = "oras"
 	// NetCacheType specifies the cache holds images pulled from http(s) internet sources
 	NetCacheType = "net"
+	// StageCacheType specifies the cache holds root filesystems of intermediate
+	// stages of multi-stage builds
+	StageCacheType = "stage"
 )
 
 var (
@@ -57,6 +66,7 @@ var (
 		ShubCacheType,
 		OrasCacheType,
 		NetCacheType,
+		StageCacheType,
 	}
 	// OciCacheTypes specifies the OCI cache types.
 	OciCacheTypes = []string{
@@ -71,6 +81,21 @@ type Config struct {
 	ParentDir string
 	// Disable specifies whether the user request the cache to be disabled by default.
 	Disable bool
+	// MaxSize specifies the maximum total size in bytes of the cache, least
+	// recently used entries are evicted to honor it. Zero means no limit.
+	MaxSize int64
+	// TypeMaxSize specifies the maximum size in bytes of individual cache
+	// types, indexed by cache type.
+	TypeMaxSize map[string]int64
+	// SystemDir specifies the parent directory of a read-only cache,
+	// administered for all users, that is consulted before the user cache.
+	SystemDir string
+	// VerifyOnRead specifies whether entries are verified each time they are
+	// read from the cache, corrupted entries are then discarded.
+	VerifyOnRead bool
+	// LockTimeout specifies how long to wait for another process creating
+	// the same entry before creating it concurrently.
+	LockTimeout time.Duration
 }
 
 // Handle is an structure representing the image cache, it's location and subdirectories
@@ -85,6 +110,18 @@ type Handle struct {
 	rootDir string
 	// If the cache is disabled
 	disabled bool
+	// maxSize is the maximum total size of the cache, 0 if unlimited
+	maxSize int64
+	// typeMaxSize holds the maximum size of individual cache types
+	typeMaxSize map[string]int64
+	// readOnly is true for the system cache, which is never written to
+	readOnly bool
+	// system is the read-only system cache layered under this cache
+	system *Handle
+	// verifyOnRead is true if entries are verified when read from the cache
+	verifyOnRead bool
+	// lockTimeout is how long to wait for an entry created by another process
+	lockTimeout time.Duration
 }
 
 func (h *Handle) GetFileCacheDir(cacheType string) (cacheDir string, err error) {
@@ -107,7 +144,17 @@ func (h *Handle) GetEntry(cacheType string, hash string) (e *Entry, err error) {
 		return nil, nil
 	}
 
-	e = &Entry{}
+	// Entries found in the system cache are used in place
+	if se := h.systemEntry(cacheType, hash); se != nil {
+		sylog.Debugf("Using %s from system cache", se.Path)
+		return se, nil
+	}
+
+	if err := h.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	e = &Entry{CacheType: cacheType, handle: h}
 
 	cacheDir, err := h.GetFileCacheDir(cacheType)
 	if err != nil {
@@ -116,6 +163,15 @@ func (h *Handle) GetEntry(cacheType string, hash string) (e *Entry, err error) {
 
 	e.Path = filepath.Join(cacheDir, hash)
 
+	// Serialize the creation of the entry with other processes, so that a
+	// process waits for and reuses an entry being created by another one
+	// rather than creating it again. If the lock can't be acquired we still
+	// go ahead, concurrent creations are safe as entries are finalized
+	// atomically.
+	e.lock, err = h.lockEntry(cacheType, hash)
+	if err != nil {
+		sylog.Warningf("Could not lock cache entry %s, proceeding without lock: %v", hash, err)
+	}
 	// If there is a directory it's from an older version of Singularity
 	// We need to remove it as we work with single files per hash only now
 	if fs.IsDir(e.Path) {
@@ -123,6 +179,7 @@ func (h *Handle) GetEntry(cacheType string, hash string) (e *Entry, err error) {
 		err := os.RemoveAll(e.Path)
 		// Allow IsNotExist in case a concurrent process already removed it
 		if err != nil && !os.IsNotExist(err) {
+			e.releaseLock()
 			return nil, fmt.Errorf("could not remove old cache directory '%s': %v", e.Path, err)
 		}
 	}
@@ -131,34 +188,69 @@ func (h *Handle) GetEntry(cacheType string, hash string) (e *Entry, err error) {
 	// to use and then Finalize
 	pathExists, err := fs.PathExists(e.Path)
 	if err != nil {
+		e.releaseLock()
 		return nil, fmt.Errorf("could not check for cache entry '%s': %v", e.Path, err)
 	}
 
+	if pathExists {
+		// Double check that there isn't something else weird there
+		if !fs.IsFile(e.Path) {
+			e.releaseLock()
+			return nil, fmt.Errorf("path '%s' exists but is not a file", e.Path)
+		}
+
+		// Discard a corrupted entry so the caller can download it again
+		if h.verifyOnRead {
+			if err := verifyEntry(e); err != nil {
+				sylog.Warningf("Removing corrupted cache entry %s: %v", e.Path, err)
+				if err := h.removeEntryFile(cacheType, e.Path); err != nil {
+					e.releaseLock()
+					return nil, fmt.Errorf("could not remove corrupted cache entry '%s': %v", e.Path, err)
+				}
+				pathExists = false
+			}
+		}
+	}
+
 	if !pathExists {
 		e.Exists = false
+		if err := h.enforceQuota(""); err != nil {
+			sylog.Warningf("Could not enforce cache size limits: %v", err)
+		}
 		f, err := fs.MakeTmpFile(cacheDir, "tmp_", 0o700)
 		if err != nil {
+			e.releaseLock()
 			return nil, err
 		}
 		err = f.Close()
 		if err != nil {
+			e.releaseLock()
 			return nil, err
 		}
 		e.TmpPath = f.Name()
 		return e, nil
 	}
 
-	// Double check that there isn't something else weird there
-	if !fs.IsFile(e.Path) {
-		return nil, fmt.Errorf("path '%s' exists but is not a file", e.Path)
-	}
-
-	// It exists in the cache and it's a file. Caller can use the Path directly
+	// It exists in the cache and it's a file. Caller can use the Path directly.
+	// The lock is only kept by entries to create, until they are finalized.
 	e.Exists = true
+	e.releaseLock()
+	touch(e.Path)
+	if err := h.enforceQuota(e.Path); err != nil {
+		sylog.Warningf("Could not enforce cache size limits: %v", err)
+	}
 	return e, nil
 }
 
 func (h *Handle) CleanCache(cacheType string, dryRun bool, days int) (err error) {
+	if err := h.checkWritable(); err != nil {
+		return err
+	}
+
+	if err := h.cleanLocks(cacheType, dryRun); err != nil {
+		sylog.Warningf("Could not remove %s cache lock files: %v", cacheType, err)
+	}
+
 	dir := h.getCacheTypeDir(cacheType)
 
 	files, err := ioutil.ReadDir(dir)
@@ -179,8 +271,7 @@ func (h *Handle) CleanCache(cacheType string, dryRun bool, days int) (err error)
 
 		sylog.Infof("Removing %s cache entry: %s", cacheType, f.Name())
 		if !dryRun {
-			// We RemoveAll in case the entry is a directory from Singularity <3.6
-			err := os.RemoveAll(path.Join(dir, f.Name()))
+			err := h.removeEntryFile(cacheType, path.Join(dir, f.Name()))
 			if err != nil {
 				sylog.Errorf("Could not remove cache entry '%s': %v", f.Name(), err)
 				errCount = errCount + 1
@@ -254,6 +345,40 @@ func New(cfg Config) (h *Handle, err error) {
 	}
 	h.parentDir = parentDir
 
+	// Size limits requested through the configuration take precedence over
+	// the ones set in the environment
+	h.maxSize = cfg.MaxSize
+	if h.maxSize == 0 {
+		if v := os.Getenv(MaxSizeEnv); v != "" {
+			if h.maxSize, err = fs.ParseSize(v); err != nil {
+				return nil, fmt.Errorf("failed to parse environment variable %s: %s", MaxSizeEnv, err)
+			}
+		}
+	}
+	h.verifyOnRead = cfg.VerifyOnRead
+	if !h.verifyOnRead {
+		if v := os.Getenv(VerifyEnv); v != "" {
+			if h.verifyOnRead, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("failed to parse environment variable %s: %s", VerifyEnv, err)
+			}
+		}
+	}
+	h.lockTimeout = cfg.LockTimeout
+	if h.lockTimeout == 0 {
+		h.lockTimeout = defaultLockTimeout
+		if v := os.Getenv(LockTimeoutEnv); v != "" {
+			if h.lockTimeout, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("failed to parse environment variable %s: %s", LockTimeoutEnv, err)
+			}
+		}
+	}
+	h.typeMaxSize = cfg.TypeMaxSize
+	if h.typeMaxSize == nil {
+		if h.typeMaxSize, err = parseTypeMaxSize(os.Getenv(TypeMaxSizeEnv)); err != nil {
+			return nil, fmt.Errorf("failed to parse environment variable %s: %s", TypeMaxSizeEnv, err)
+		}
+	}
+
 	// If we can't access the parent of the cache directory then don't use the
 	// cache.
 	ep, err := fs.FirstExistingParent(parentDir)
@@ -271,6 +396,11 @@ func New(cfg Config) (h *Handle, err error) {
 		return h, nil
 	}
 
+	// The system cache is only layered under a distinct user cache
+	if cfg.SystemDir != "" && filepath.Clean(cfg.SystemDir) != filepath.Clean(parentDir) {
+		h.system = newSystemHandle(cfg.SystemDir)
+	}
+
 	// Initialize the root directory of the cache
 	rootDir := path.Join(parentDir, SubDirName)
 	h.rootDir = rootDir
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -9,6 +9,8 @@ package cache
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/hpcng/singularity/internal/pkg/util/fs"
 	"github.com/hpcng/singularity/pkg/sylog"
@@ -27,6 +29,17 @@ type Entry struct {
 	// tmpPath is the temporary location that should be used for a new cache entry as it
 	// is created
 	TmpPath string
+	// Source is the reference of the image the entry is created from, it is
+	// recorded in the entry metadata when the entry is finalized
+	Source string
+	// System is true if the entry exists in the read-only system cache, in
+	// which case it must be used in place from Path
+	System bool
+	// handle is the cache the entry belongs to
+	handle *Handle
+	// lock serializes the creation of the entry across processes, it is held
+	// until the entry is finalized or its temporary file is cleaned
+	lock *entryLock
 }
 
 // Finalize an entry by renaming it to its permanent path atomically
@@ -36,14 +49,33 @@ func (e *Entry) Finalize() error {
 	//   If newpath already exists and is not a directory, Rename replaces it.
 	//   https://golang.org/pkg/os/#Rename
 	err := os.Rename(e.TmpPath, e.Path)
+	e.releaseLock()
 	if err != nil {
 		return fmt.Errorf("could not finalize cached file: %v", err)
 	}
+	if e.handle != nil {
+		md := EntryMetadata{
+			Source:  e.Source,
+			Created: time.Now(),
+		}
+		if err := e.handle.writeMetadata(e.CacheType, filepath.Base(e.Path), md); err != nil {
+			sylog.Warningf("Could not record cache entry metadata: %v", err)
+		}
+	}
+	// The cache grew, make sure it still honors its size limits
+	if e.handle != nil {
+		if err := e.handle.enforceQuota(e.Path); err != nil {
+			sylog.Warningf("Could not enforce cache size limits: %v", err)
+		}
+	}
 	return nil
 }
 
 // CleanTmp should be defer'd when an Entry is created and will remove any temporary file
 func (e *Entry) CleanTmp() {
+	// Let other processes waiting for this entry go ahead
+	e.releaseLock()
+
 	// If there is no TmpPath / file there then there is nothing to clean up
 	if e.TmpPath == "" || !fs.IsFile(e.TmpPath) {
 		return
@@ -53,3 +85,9 @@ func (e *Entry) CleanTmp() {
 		sylog.Errorf("Could not remove cache temporary file '%s': %v", e.TmpPath, err)
 	}
 }
+
+// releaseLock releases the lock held by the entry, if any
+func (e *Entry) releaseLock() {
+	e.lock.release()
+	e.lock = nil
+}
@@ -0,0 +1,406 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hpcng/singularity/pkg/sylog"
+	"github.com/hpcng/singularity/pkg/util/fs/lock"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// LockTimeoutEnv specifies the environment variable which can set how
+	// long to wait for another process creating the same cache entry,
+	// e.g. "10m"
+	LockTimeoutEnv = "SINGULARITY_CACHE_LOCK_TIMEOUT"
+
+	// defaultLockTimeout is the default duration to wait for a cache entry
+	// lock held by another process.
+	defaultLockTimeout = 30 * time.Minute
+
+	// lockDirName is the name of the directory, relative to the cache root
+	// directory, holding the entry lock files.
+	lockDirName = "locks"
+)
+
+var (
+	errLockTimeout = errors.New("timed out waiting for cache entry lock")
+
+	// lockPollInterval is the interval between two attempts to acquire a
+	// cache entry lock held by another process.
+	lockPollInterval = 250 * time.Millisecond
+	// lockStaleAge is the duration after which a lock file that isn't
+	// refreshed by its owner is considered stale.
+	lockStaleAge = 2 * time.Minute
+)
+
+// entryLock is an advisory lock serializing the creation of a cache entry
+// across processes, possibly running on different hosts sharing the cache.
+type entryLock struct {
+	// fd is the lock file descriptor holding the byte-range lock, or -1
+	// when the filesystem doesn't support them
+	fd int
+	// ownerPath is the lock file exclusively created instead of a
+	// byte-range lock when the filesystem doesn't support them
+	ownerPath string
+	// stop ends the refresh of ownerPath
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+	// unlock releases the in-process lock of the entry
+	unlock func()
+}
+
+// processLock serializes the acquisition of an entry lock between the
+// goroutines of the current process: byte-range locks are owned by the
+// process, so they don't exclude each other within it, and closing any
+// file descriptor of the lock file would release them.
+type processLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+var processLocks = struct {
+	sync.Mutex
+	m map[string]*processLock
+}{m: make(map[string]*processLock)}
+
+// lockInProcess acquires the in-process lock for the lock file at path,
+// waiting at most until deadline, and returns the function releasing it.
+func lockInProcess(path string, deadline time.Time) (func(), error) {
+	processLocks.Lock()
+	pl, ok := processLocks.m[path]
+	if !ok {
+		pl = &processLock{ch: make(chan struct{}, 1)}
+		processLocks.m[path] = pl
+	}
+	pl.refs++
+	processLocks.Unlock()
+
+	done := func() {
+		processLocks.Lock()
+		pl.refs--
+		if pl.refs == 0 {
+			delete(processLocks.m, path)
+		}
+		processLocks.Unlock()
+	}
+
+	select {
+	case pl.ch <- struct{}{}:
+	default:
+		if time.Until(deadline) <= 0 {
+			done()
+			return nil, errLockTimeout
+		}
+		sylog.Infof("Waiting for the creation of the cache entry %s", filepath.Base(path))
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case pl.ch <- struct{}{}:
+		case <-timer.C:
+			done()
+			return nil, errLockTimeout
+		}
+	}
+
+	return func() {
+		<-pl.ch
+		done()
+	}, nil
+}
+
+// lockOwner returns the identifier of the current process written into
+// lock files, to help detecting stale locks.
+func lockOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s %d", hostname, os.Getpid())
+}
+
+// lockEntry acquires the lock of the entry for the specified cache type and
+// hash, waiting at most for the handle lock timeout if another process holds
+// it.
+func (h *Handle) lockEntry(cacheType string, hash string) (*entryLock, error) {
+	dir := filepath.Join(h.rootDir, lockDirName, cacheType)
+	if err := initCacheDir(filepath.Join(h.rootDir, lockDirName)); err != nil {
+		return nil, err
+	}
+	if err := initCacheDir(dir); err != nil {
+		return nil, err
+	}
+	return acquireEntryLock(filepath.Join(dir, hash), h.lockTimeout)
+}
+
+// acquireEntryLock acquires a byte-range lock on the lock file at path,
+// polling until timeout if it is held by another process or goroutine. The
+// lock is released by the kernel if its owner crashes. When the filesystem
+// doesn't support byte-range locks, an owner file is exclusively created
+// instead.
+func acquireEntryLock(path string, timeout time.Duration) (*entryLock, error) {
+	deadline := time.Now().Add(timeout)
+
+	unlock, err := lockInProcess(path, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := acquireFileLock(path, deadline)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	l.unlock = unlock
+	return l, nil
+}
+
+// acquireFileLock acquires the byte-range lock on the lock file at path,
+// polling until deadline if it is held by another process.
+func acquireFileLock(path string, deadline time.Time) (*entryLock, error) {
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_CREAT|unix.O_CLOEXEC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open cache lock file %s: %v", path, err)
+	}
+
+	br := lock.NewByteRange(fd, 0, 1)
+	waiting := false
+
+	for {
+		err := br.Lock()
+		if err == nil {
+			// the lock file may have been removed by a cache clean
+			// while waiting for the lock, lock the new one instead
+			if !isSameLockFile(fd, path) {
+				unix.Close(fd)
+				return acquireFileLock(path, deadline)
+			}
+			if err := unix.Ftruncate(fd, 0); err == nil {
+				unix.Pwrite(fd, []byte(lockOwner()+"\n"), 0)
+			}
+			return &entryLock{fd: fd}, nil
+		}
+
+		switch {
+		case errors.Is(err, lock.ErrLockNotSupported):
+			unix.Close(fd)
+			sylog.Debugf("Byte-range locks not supported for %s, using an owner file", path)
+			return acquireOwnerLock(path+".owner", time.Until(deadline))
+		case !errors.Is(err, lock.ErrByteRangeAcquired):
+			unix.Close(fd)
+			return nil, fmt.Errorf("could not lock cache lock file %s: %v", path, err)
+		}
+
+		if !waiting {
+			waiting = true
+			sylog.Infof("Waiting for another process to create the cache entry %s", filepath.Base(path))
+		}
+		if time.Now().After(deadline) {
+			unix.Close(fd)
+			return nil, errLockTimeout
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// isSameLockFile returns true if the lock file descriptor fd still
+// refers to the lock file at path.
+func isSameLockFile(fd int, path string) bool {
+	var fst, st unix.Stat_t
+
+	if err := unix.Fstat(fd, &fst); err != nil {
+		return false
+	}
+	if err := unix.Stat(path, &st); err != nil {
+		return false
+	}
+	return fst.Dev == st.Dev && fst.Ino == st.Ino
+}
+
+// acquireOwnerLock acquires a lock by exclusively creating the owner file at
+// path, recording the current process. The file is refreshed while the lock
+// is held so that locks left behind by crashed processes can be detected,
+// either because their owner file isn't refreshed anymore, or because their
+// owner process doesn't exist anymore on the current host.
+func acquireOwnerLock(path string, timeout time.Duration) (*entryLock, error) {
+	deadline := time.Now().Add(timeout)
+	waiting := false
+
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			_, err = f.WriteString(lockOwner() + "\n")
+			f.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("could not write cache lock file %s: %v", path, err)
+			}
+			l := &entryLock{fd: -1, ownerPath: path, stop: make(chan struct{})}
+			l.wg.Add(1)
+			go l.refresh()
+			return l, nil
+		} else if !os.IsExist(err) {
+			return nil, fmt.Errorf("could not create cache lock file %s: %v", path, err)
+		}
+
+		if isStaleOwnerLock(path) {
+			sylog.Debugf("Removing stale cache lock file %s", path)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("could not remove stale cache lock file %s: %v", path, err)
+			}
+			continue
+		}
+
+		if !waiting {
+			waiting = true
+			sylog.Infof("Waiting for another process to create the cache entry %s", strings.TrimSuffix(filepath.Base(path), ".owner"))
+		}
+		if time.Now().After(deadline) {
+			return nil, errLockTimeout
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// isStaleOwnerLock returns true if the owner file at path was left behind
+// by a crashed process.
+func isStaleOwnerLock(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if time.Since(fi.ModTime()) > lockStaleAge {
+		return true
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 {
+		return false
+	}
+	hostname, _ := os.Hostname()
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil || fields[0] != hostname {
+		return false
+	}
+	// Signal 0 checks for the process existence
+	return unix.Kill(pid, 0) == unix.ESRCH
+}
+
+// refresh periodically updates the modification time of the owner file
+// until the lock is released.
+func (l *entryLock) refresh() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(lockStaleAge / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if err := os.Chtimes(l.ownerPath, now, now); err != nil {
+				sylog.Debugf("Could not refresh cache lock file %s: %v", l.ownerPath, err)
+			}
+		}
+	}
+}
+
+// release releases the lock, it is safe to call it multiple times.
+func (l *entryLock) release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		if l.unlock != nil {
+			defer l.unlock()
+		}
+		if l.fd >= 0 {
+			if err := lock.NewByteRange(l.fd, 0, 1).Unlock(); err != nil {
+				sylog.Debugf("Could not unlock cache lock file: %v", err)
+			}
+			unix.Close(l.fd)
+			return
+		}
+		close(l.stop)
+		l.wg.Wait()
+		if err := os.Remove(l.ownerPath); err != nil && !os.IsNotExist(err) {
+			sylog.Debugf("Could not remove cache lock file %s: %v", l.ownerPath, err)
+		}
+	})
+}
+
+// cleanLocks removes the lock files of the cacheType entries which are
+// not held, the lock files are otherwise never removed.
+func (h *Handle) cleanLocks(cacheType string, dryRun bool) error {
+	dir := filepath.Join(h.rootDir, lockDirName, cacheType)
+
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read cache lock directory %s: %v", dir, err)
+	}
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+
+		if strings.HasSuffix(path, ".owner") {
+			if isStaleOwnerLock(path) {
+				sylog.Debugf("Removing stale cache lock file %s", path)
+				if !dryRun {
+					os.Remove(path)
+				}
+			}
+			continue
+		}
+
+		if !dryRun {
+			removeUnheldLock(path)
+		}
+	}
+	return nil
+}
+
+// removeUnheldLock removes the lock file at path if no process or
+// goroutine holds it. The lock is held while the file is removed, so that
+// processes waiting for it detect the removal and use a new lock file.
+func removeUnheldLock(path string) {
+	unlock, err := lockInProcess(path, time.Now())
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+
+	if err := lock.NewByteRange(fd, 0, 1).Lock(); err != nil {
+		return
+	}
+	if isSameLockFile(fd, path) {
+		sylog.Debugf("Removing cache lock file %s", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			sylog.Debugf("Could not remove cache lock file %s: %v", path, err)
+		}
+	}
+}
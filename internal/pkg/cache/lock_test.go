@@ -0,0 +1,192 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAcquireEntryLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-lock-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := acquireEntryLock(filepath.Join(dir, "entry"), time.Second)
+	if err != nil {
+		t.Fatalf("could not acquire lock: %v", err)
+	}
+	l.release()
+	// Releasing twice must be harmless
+	l.release()
+
+	l, err = acquireEntryLock(filepath.Join(dir, "entry"), time.Second)
+	if err != nil {
+		t.Fatalf("could not acquire released lock: %v", err)
+	}
+	l.release()
+}
+
+func TestAcquireOwnerLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-lock-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(interval time.Duration) { lockPollInterval = interval }(lockPollInterval)
+	lockPollInterval = 10 * time.Millisecond
+
+	path := filepath.Join(dir, "entry.owner")
+
+	l, err := acquireOwnerLock(path, time.Second)
+	if err != nil {
+		t.Fatalf("could not acquire lock: %v", err)
+	}
+
+	// A second acquisition times out while the lock is held
+	if _, err := acquireOwnerLock(path, 50*time.Millisecond); !errors.Is(err, errLockTimeout) {
+		t.Fatalf("unexpected error acquiring held lock: %v", err)
+	}
+
+	// and succeeds once it is released
+	acquired := make(chan error)
+	go func() {
+		l2, err := acquireOwnerLock(path, 5*time.Second)
+		if err == nil {
+			l2.release()
+		}
+		acquired <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	l.release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("could not acquire released lock: %v", err)
+	}
+
+	// Lock files left behind by a crashed process are removed
+	hostname, _ := os.Hostname()
+	tests := []struct {
+		name    string
+		owner   string
+		modTime time.Time
+	}{
+		{
+			name:    "dead process",
+			owner:   fmt.Sprintf("%s %d\n", hostname, 1<<22+1),
+			modTime: time.Now(),
+		},
+		{
+			name:    "not refreshed",
+			owner:   "otherhost 1\n",
+			modTime: time.Now().Add(-2 * lockStaleAge),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(path, []byte(tt.owner), 0o600); err != nil {
+				t.Fatalf("could not write lock file: %v", err)
+			}
+			if err := os.Chtimes(path, tt.modTime, tt.modTime); err != nil {
+				t.Fatalf("could not set lock file times: %v", err)
+			}
+			l, err := acquireOwnerLock(path, 50*time.Millisecond)
+			if err != nil {
+				t.Fatalf("could not acquire stale lock: %v", err)
+			}
+			l.release()
+		})
+	}
+}
+
+func TestAcquireEntryLockGoroutines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-lock-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "entry")
+
+	l, err := acquireEntryLock(path, time.Second)
+	if err != nil {
+		t.Fatalf("could not acquire lock: %v", err)
+	}
+
+	// Another goroutine of the same process can't acquire the held lock
+	if _, err := acquireEntryLock(path, 50*time.Millisecond); !errors.Is(err, errLockTimeout) {
+		t.Fatalf("unexpected error acquiring held lock: %v", err)
+	}
+
+	// Goroutines contending for the lock hold it one at a time
+	const workers = 8
+	var holders int32
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			l, err := acquireEntryLock(path, 5*time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if n := atomic.AddInt32(&holders, 1); n != 1 {
+				err = fmt.Errorf("%d goroutines hold the lock", n)
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&holders, -1)
+			l.release()
+			errs <- err
+		}()
+	}
+	l.release()
+	for i := 0; i < workers; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("lock contention: %v", err)
+		}
+	}
+}
+
+func TestCleanLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-lock-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := &Handle{rootDir: dir}
+
+	held, err := h.lockEntry(NetCacheType, "held")
+	if err != nil {
+		t.Fatalf("could not acquire lock: %v", err)
+	}
+	defer held.release()
+	unheld, err := h.lockEntry(NetCacheType, "unheld")
+	if err != nil {
+		t.Fatalf("could not acquire lock: %v", err)
+	}
+	unheld.release()
+
+	if err := h.cleanLocks(NetCacheType, false); err != nil {
+		t.Fatalf("could not clean locks: %v", err)
+	}
+
+	lockDir := filepath.Join(dir, lockDirName, NetCacheType)
+	if _, err := os.Stat(filepath.Join(lockDir, "held")); err != nil {
+		t.Errorf("held lock file removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lockDir, "unheld")); !os.IsNotExist(err) {
+		t.Errorf("unheld lock file not removed: %v", err)
+	}
+}
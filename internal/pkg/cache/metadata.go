@@ -0,0 +1,155 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// metadataDirName is the name of the directory, relative to the cache root
+// directory, holding the entry metadata sidecar files.
+const metadataDirName = "metadata"
+
+// EntryMetadata holds the information persisted alongside a cache entry.
+type EntryMetadata struct {
+	// Source is the reference of the image the entry was created from
+	Source string `json:"source,omitempty"`
+	// Created is the time the entry was added to the cache
+	Created time.Time `json:"created"`
+}
+
+// EntryInfo describes an entry held in the cache.
+type EntryInfo struct {
+	// CacheType is the type of the cache holding the entry
+	CacheType string `json:"type"`
+	// Key is the cache key of the entry, i.e. its hash or digest
+	Key string `json:"key"`
+	// Path is the location of the entry
+	Path string `json:"path"`
+	// Size is the size of the entry in bytes
+	Size int64 `json:"size"`
+	// ModTime is the modification time of the entry
+	ModTime time.Time `json:"mtime"`
+	// AccessTime is the last access time recorded by the cache
+	AccessTime time.Time `json:"lastAccess"`
+	// Source is the reference of the image the entry was created from, if
+	// known
+	Source string `json:"source,omitempty"`
+}
+
+// metadataPath returns the location of the metadata sidecar file of the
+// entry for the specified cache type and key.
+func (h *Handle) metadataPath(cacheType string, key string) string {
+	return filepath.Join(h.rootDir, metadataDirName, cacheType, key+".json")
+}
+
+// writeMetadata persists the metadata of the entry for the specified cache
+// type and key.
+func (h *Handle) writeMetadata(cacheType string, key string, md EntryMetadata) error {
+	p := h.metadataPath(cacheType, key)
+	if err := initCacheDir(filepath.Join(h.rootDir, metadataDirName)); err != nil {
+		return err
+	}
+	if err := initCacheDir(filepath.Dir(p)); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(md)
+	if err != nil {
+		return fmt.Errorf("could not marshal cache entry metadata: %v", err)
+	}
+
+	f, err := fs.MakeTmpFile(filepath.Dir(p), "tmp_", 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create cache entry metadata: %v", err)
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not write cache entry metadata %s: %v", p, err)
+	}
+
+	return nil
+}
+
+// readMetadata returns the metadata of the entry for the specified cache
+// type and key. Entries created by older versions of Singularity, or OCI
+// blobs, have no metadata and an empty EntryMetadata is returned.
+func (h *Handle) readMetadata(cacheType string, key string) EntryMetadata {
+	var md EntryMetadata
+
+	b, err := ioutil.ReadFile(h.metadataPath(cacheType, key))
+	if err != nil {
+		return md
+	}
+	if err := json.Unmarshal(b, &md); err != nil {
+		sylog.Debugf("Could not parse metadata of %s cache entry %s: %v", cacheType, key, err)
+	}
+
+	return md
+}
+
+// removeEntryFile removes the file at path from the cache, along with its
+// metadata.
+func (h *Handle) removeEntryFile(cacheType string, path string) error {
+	// We RemoveAll in case the entry is a directory from Singularity <3.6
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	// Allow IsNotExist as entries don't necessarily have metadata
+	err := os.Remove(h.metadataPath(cacheType, filepath.Base(path)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ListEntries returns the entries held in the cache for the specified cache
+// type, along with their metadata.
+func (h *Handle) ListEntries(cacheType string) ([]EntryInfo, error) {
+	if h.disabled {
+		return nil, nil
+	}
+	if !stringInSlice(cacheType, append(FileCacheTypes, OciCacheTypes...)) {
+		return nil, fmt.Errorf("%w: %s", errInvalidCacheType, cacheType)
+	}
+
+	files, err := h.cacheFiles(cacheType)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]EntryInfo, 0, len(files))
+	for _, f := range files {
+		key := filepath.Base(f.path)
+		md := h.readMetadata(cacheType, key)
+		entries = append(entries, EntryInfo{
+			CacheType:  cacheType,
+			Key:        key,
+			Path:       f.path,
+			Size:       f.size,
+			ModTime:    f.modified,
+			AccessTime: f.accessed,
+			Source:     md.Source,
+		})
+	}
+
+	return entries, nil
+}
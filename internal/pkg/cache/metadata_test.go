@@ -0,0 +1,74 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestListEntries(t *testing.T) {
+	parent, err := ioutil.TempDir("", "cache-metadata-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(parent)
+
+	h, err := New(Config{ParentDir: parent})
+	if err != nil {
+		t.Fatalf("could not create cache handle: %v", err)
+	}
+
+	const source = "https://example.com/image.sif"
+
+	e, err := h.GetEntry(NetCacheType, "pulled")
+	if err != nil {
+		t.Fatalf("could not get cache entry: %v", err)
+	}
+	defer e.CleanTmp()
+	e.Source = source
+	if err := ioutil.WriteFile(e.TmpPath, []byte("image"), 0o600); err != nil {
+		t.Fatalf("could not write cache entry: %v", err)
+	}
+	if err := e.Finalize(); err != nil {
+		t.Fatalf("could not finalize cache entry: %v", err)
+	}
+
+	// Entries from older versions have no metadata
+	addEntry(t, h, NetCacheType, "legacy", 10, time.Now())
+
+	entries, err := h.ListEntries(NetCacheType)
+	if err != nil {
+		t.Fatalf("could not list cache entries: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	for _, entry := range entries {
+		switch entry.Key {
+		case "pulled":
+			if entry.Source != source || entry.Size != 5 || entry.CacheType != NetCacheType {
+				t.Errorf("unexpected entry information: %+v", entry)
+			}
+		case "legacy":
+			if entry.Source != "" || entry.Size != 10 {
+				t.Errorf("unexpected entry information: %+v", entry)
+			}
+		default:
+			t.Errorf("unexpected entry %s", entry.Key)
+		}
+	}
+
+	// Metadata is removed along with its entry
+	if err := h.CleanCache(NetCacheType, false, -1); err != nil {
+		t.Fatalf("could not clean cache: %v", err)
+	}
+	if _, err := os.Stat(h.metadataPath(NetCacheType, "pulled")); !os.IsNotExist(err) {
+		t.Errorf("cache entry metadata was not removed")
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// cacheFile describes a single file held in the cache, as considered when
+// enforcing size quotas.
+type cacheFile struct {
+	cacheType string
+	path      string
+	size      int64
+	modified  time.Time
+	accessed  time.Time
+}
+
+// parseTypeMaxSize parses a comma separated list of type=size pairs, as
+// found in the environment variable specified by TypeMaxSizeEnv.
+func parseTypeMaxSize(value string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid cache size specification %q, expected type=size", pair)
+		}
+		cacheType := strings.TrimSpace(kv[0])
+		if !stringInSlice(cacheType, append(FileCacheTypes, OciCacheTypes...)) {
+			return nil, fmt.Errorf("%w: %s", errInvalidCacheType, cacheType)
+		}
+		size, err := fs.ParseSize(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		sizes[cacheType] = size
+	}
+
+	return sizes, nil
+}
+
+// accessTime returns the last access time recorded for a cache file. As the
+// cache records accesses itself by setting the access time explicitly, this
+// doesn't depend on the filesystem being mounted with atime updates. The
+// modification time is used if it is more recent, which is the case for
+// entries that were never accessed since their creation.
+func accessTime(fi os.FileInfo) time.Time {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fi.ModTime()
+	}
+	atime := time.Unix(st.Atim.Unix())
+	if atime.Before(fi.ModTime()) {
+		return fi.ModTime()
+	}
+	return atime
+}
+
+// touch records an access to the cache file at path, preserving its
+// modification time which is used to clean entries by age.
+func touch(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if err := os.Chtimes(path, time.Now(), fi.ModTime()); err != nil {
+		sylog.Debugf("Could not record access time of cache entry %s: %v", path, err)
+	}
+}
+
+// cacheTypeFilesDir returns the directory holding the files of a cache type.
+// OCI blobs are held one level deeper, in an OCI layout.
+func (h *Handle) cacheTypeFilesDir(cacheType string) string {
+	if cacheType == OciBlobCacheType {
+		return filepath.Join(h.getCacheTypeDir(cacheType), "blobs", "sha256")
+	}
+	return h.getCacheTypeDir(cacheType)
+}
+
+// cacheFiles returns the files currently held in the cache for the specified
+// cache type. Temporary files of entries in progress are ignored.
+func (h *Handle) cacheFiles(cacheType string) ([]cacheFile, error) {
+	dir := h.cacheTypeFilesDir(cacheType)
+
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read %s cache directory: %v", cacheType, err)
+	}
+
+	files := make([]cacheFile, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), "tmp_") {
+			continue
+		}
+		files = append(files, cacheFile{
+			cacheType: cacheType,
+			path:      filepath.Join(dir, fi.Name()),
+			size:      fi.Size(),
+			modified:  fi.ModTime(),
+			accessed:  accessTime(fi),
+		})
+	}
+
+	return files, nil
+}
+
+// evictLRU removes the least recently used files until their total size is
+// less than or equal to maxSize. The file at path keep is never removed. The
+// files that were, or would be in dry run mode, removed are returned.
+func (h *Handle) evictLRU(files []cacheFile, maxSize int64, keep string, dryRun bool) ([]cacheFile, error) {
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	if total <= maxSize {
+		return nil, nil
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].accessed.Before(files[j].accessed)
+	})
+
+	var evicted []cacheFile
+	errCount := 0
+
+	for _, f := range files {
+		if total <= maxSize {
+			break
+		}
+		if f.path == keep {
+			continue
+		}
+		if !dryRun {
+			if err := h.removeEntryFile(f.cacheType, f.path); err != nil {
+				sylog.Errorf("Could not remove cache entry '%s': %v", f.path, err)
+				errCount++
+				continue
+			}
+		}
+		total -= f.size
+		evicted = append(evicted, f)
+	}
+
+	if errCount > 0 {
+		return evicted, fmt.Errorf("failed to remove %d cache entries", errCount)
+	}
+
+	return evicted, nil
+}
+
+// enforceQuota evicts least recently used entries so that each cache type,
+// and the cache as a whole, fit within the configured maximum sizes. The
+// entry at path keep is never evicted.
+func (h *Handle) enforceQuota(keep string) error {
+	if h.disabled || h.readOnly || (h.maxSize <= 0 && len(h.typeMaxSize) == 0) {
+		return nil
+	}
+
+	var all []cacheFile
+
+	for _, ct := range append(FileCacheTypes, OciCacheTypes...) {
+		files, err := h.cacheFiles(ct)
+		if err != nil {
+			return err
+		}
+		if max, ok := h.typeMaxSize[ct]; ok && max > 0 {
+			evicted, err := h.evictLRU(files, max, keep, false)
+			logEvicted(evicted)
+			if err != nil {
+				return err
+			}
+			files = withoutFiles(files, evicted)
+		}
+		all = append(all, files...)
+	}
+
+	if h.maxSize > 0 {
+		evicted, err := h.evictLRU(all, h.maxSize, keep, false)
+		logEvicted(evicted)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TrimCache removes the least recently used entries of the specified cache
+// types until their total size is less than or equal to maxSize.
+func (h *Handle) TrimCache(cacheTypes []string, maxSize int64, dryRun bool) error {
+	if h.disabled {
+		return nil
+	}
+	if err := h.checkWritable(); err != nil {
+		return err
+	}
+
+	var all []cacheFile
+
+	for _, ct := range cacheTypes {
+		if !stringInSlice(ct, append(FileCacheTypes, OciCacheTypes...)) {
+			return fmt.Errorf("%w: %s", errInvalidCacheType, ct)
+		}
+		files, err := h.cacheFiles(ct)
+		if err != nil {
+			return err
+		}
+		all = append(all, files...)
+
+		if err := h.cleanLocks(ct, dryRun); err != nil {
+			sylog.Warningf("Could not remove %s cache lock files: %v", ct, err)
+		}
+	}
+
+	evicted, err := h.evictLRU(all, maxSize, "", dryRun)
+	if len(evicted) == 0 && err == nil {
+		sylog.Infof("Cache already fits within %s, nothing to remove", fs.FindSize(maxSize))
+		return nil
+	}
+	for _, f := range evicted {
+		sylog.Infof("Removing %s cache entry: %s", f.cacheType, filepath.Base(f.path))
+	}
+
+	return err
+}
+
+func logEvicted(files []cacheFile) {
+	for _, f := range files {
+		sylog.Verbosef("Evicted least recently used %s cache entry: %s", f.cacheType, filepath.Base(f.path))
+	}
+}
+
+func withoutFiles(files, removed []cacheFile) []cacheFile {
+	if len(removed) == 0 {
+		return files
+	}
+	removedPaths := make(map[string]struct{}, len(removed))
+	for _, f := range removed {
+		removedPaths[f.path] = struct{}{}
+	}
+	kept := files[:0]
+	for _, f := range files {
+		if _, ok := removedPaths[f.path]; !ok {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
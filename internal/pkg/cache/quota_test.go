@@ -0,0 +1,163 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// addEntry creates a finalized cache entry of the given size, last accessed
+// at the given time.
+func addEntry(t *testing.T, h *Handle, cacheType, hash string, size int, accessed time.Time) string {
+	t.Helper()
+
+	dir, err := h.GetFileCacheDir(cacheType)
+	if err != nil {
+		t.Fatalf("could not get %s cache directory: %v", cacheType, err)
+	}
+	path := filepath.Join(dir, hash)
+	if err := ioutil.WriteFile(path, make([]byte, size), 0o600); err != nil {
+		t.Fatalf("could not create cache entry %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, accessed, accessed); err != nil {
+		t.Fatalf("could not set times of cache entry %s: %v", path, err)
+	}
+	return path
+}
+
+func TestEnforceQuota(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		maxSize     int64
+		typeMaxSize map[string]int64
+		hit         string
+		wantEvicted []string
+	}{
+		{
+			name:        "unlimited",
+			wantEvicted: []string{},
+		},
+		{
+			name:        "total limit",
+			maxSize:     250,
+			wantEvicted: []string{"oldest"},
+		},
+		{
+			name:        "total limit with hit",
+			maxSize:     250,
+			hit:         "oldest",
+			wantEvicted: []string{"old"},
+		},
+		{
+			name:        "type limit",
+			typeMaxSize: map[string]int64{LibraryCacheType: 100},
+			wantEvicted: []string{"oldest"},
+		},
+		{
+			name:        "type and total limits",
+			maxSize:     100,
+			typeMaxSize: map[string]int64{NetCacheType: 1000},
+			wantEvicted: []string{"oldest", "old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, err := ioutil.TempDir("", "cache-quota-")
+			if err != nil {
+				t.Fatalf("could not create temporary directory: %v", err)
+			}
+			defer os.RemoveAll(parent)
+
+			h, err := New(Config{ParentDir: parent})
+			if err != nil {
+				t.Fatalf("could not create cache handle: %v", err)
+			}
+
+			paths := map[string]string{
+				"oldest": addEntry(t, h, LibraryCacheType, "oldest", 100, now.Add(-3*time.Hour)),
+				"old":    addEntry(t, h, LibraryCacheType, "old", 100, now.Add(-2*time.Hour)),
+				"recent": addEntry(t, h, NetCacheType, "recent", 100, now.Add(-1*time.Hour)),
+			}
+
+			h.maxSize = tt.maxSize
+			h.typeMaxSize = tt.typeMaxSize
+
+			if tt.hit != "" {
+				cacheType := LibraryCacheType
+				if tt.hit == "recent" {
+					cacheType = NetCacheType
+				}
+				e, err := h.GetEntry(cacheType, tt.hit)
+				if err != nil {
+					t.Fatalf("could not get cache entry: %v", err)
+				}
+				if !e.Exists {
+					t.Fatalf("cache entry %s unexpectedly missing", tt.hit)
+				}
+			} else if err := h.enforceQuota(""); err != nil {
+				t.Fatalf("could not enforce quota: %v", err)
+			}
+
+			evicted := make(map[string]bool)
+			for _, name := range tt.wantEvicted {
+				evicted[name] = true
+			}
+			for name, path := range paths {
+				_, err := os.Stat(path)
+				if evicted[name] && !os.IsNotExist(err) {
+					t.Errorf("cache entry %s was not evicted", name)
+				} else if !evicted[name] && err != nil {
+					t.Errorf("cache entry %s was unexpectedly evicted: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTrimCache(t *testing.T) {
+	parent, err := ioutil.TempDir("", "cache-trim-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(parent)
+
+	h, err := New(Config{ParentDir: parent})
+	if err != nil {
+		t.Fatalf("could not create cache handle: %v", err)
+	}
+
+	now := time.Now()
+	oldest := addEntry(t, h, LibraryCacheType, "oldest", 100, now.Add(-2*time.Hour))
+	recent := addEntry(t, h, OrasCacheType, "recent", 100, now.Add(-1*time.Hour))
+
+	if err := h.TrimCache([]string{LibraryCacheType, OrasCacheType}, 100, true); err != nil {
+		t.Fatalf("unexpected error in dry run mode: %v", err)
+	}
+	if _, err := os.Stat(oldest); err != nil {
+		t.Fatalf("cache entry removed in dry run mode: %v", err)
+	}
+
+	if err := h.TrimCache([]string{LibraryCacheType, OrasCacheType}, 100, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(oldest); !os.IsNotExist(err) {
+		t.Errorf("least recently used cache entry was not removed")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("most recently used cache entry was removed: %v", err)
+	}
+
+	if err := h.TrimCache([]string{"invalid"}, 0, false); err == nil {
+		t.Errorf("unexpected success trimming an invalid cache type")
+	}
+}
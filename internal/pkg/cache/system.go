@@ -0,0 +1,137 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	"github.com/hpcng/singularity/pkg/sylog"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var errReadOnlyCache = errors.New("cache is read-only")
+
+// newSystemHandle returns a read-only handle for the system cache found in
+// parentDir, or nil if parentDir doesn't hold a cache that can be read.
+func newSystemHandle(parentDir string) *Handle {
+	rootDir := path.Join(parentDir, SubDirName)
+
+	if !fs.IsDir(rootDir) {
+		sylog.Debugf("System cache directory %s doesn't exist, ignoring it", rootDir)
+		return nil
+	}
+
+	return &Handle{
+		parentDir: parentDir,
+		rootDir:   rootDir,
+		readOnly:  true,
+	}
+}
+
+// System returns a read-only handle for the system cache layered under the
+// user cache, or nil if no system cache is configured.
+func (h *Handle) System() *Handle {
+	if h.disabled {
+		return nil
+	}
+	return h.system
+}
+
+// IsReadOnly returns true if entries can't be added to or removed from the
+// cache, as it is the case for the system cache.
+func (h *Handle) IsReadOnly() bool {
+	return h.readOnly
+}
+
+// systemEntry returns the entry for a specified file cache type and hash
+// from the system cache, or nil if the system cache doesn't hold it.
+func (h *Handle) systemEntry(cacheType string, hash string) *Entry {
+	if h.system == nil {
+		return nil
+	}
+
+	cacheDir, err := h.system.GetFileCacheDir(cacheType)
+	if err != nil {
+		return nil
+	}
+
+	p := filepath.Join(cacheDir, hash)
+	if !fs.IsFile(p) {
+		return nil
+	}
+
+	// Entries are used in place, the system cache is never written to
+	e := &Entry{
+		CacheType: cacheType,
+		Exists:    true,
+		Path:      p,
+		System:    true,
+	}
+
+	// A corrupted system entry can't be removed, fall back to the user cache
+	if h.verifyOnRead {
+		if err := verifyEntry(e); err != nil {
+			sylog.Warningf("Ignoring corrupted system cache entry %s: %v", p, err)
+			return nil
+		}
+	}
+
+	return e
+}
+
+// LookupOciCacheDir returns the OCI layout directory of the specified cache
+// type to use for the image tagged with tag. If the system cache holds the
+// image, its read-only directory is returned and system is true. Otherwise
+// the user cache directory is returned.
+func (h *Handle) LookupOciCacheDir(cacheType string, tag string) (cacheDir string, system bool, err error) {
+	if h.system != nil {
+		sysDir, err := h.system.GetOciCacheDir(cacheType)
+		if err == nil && layoutHasTag(sysDir, tag) {
+			sylog.Debugf("Using %s from system cache %s", tag, sysDir)
+			return sysDir, true, nil
+		}
+	}
+
+	cacheDir, err = h.GetOciCacheDir(cacheType)
+	return cacheDir, false, err
+}
+
+// layoutHasTag returns true if the OCI layout in dir has an image
+// referenced by tag in its index.
+func layoutHasTag(dir string, tag string) bool {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return false
+	}
+
+	var index imgspecv1.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		sylog.Debugf("Could not parse OCI index in %s: %v", dir, err)
+		return false
+	}
+
+	for _, m := range index.Manifests {
+		if m.Annotations[imgspecv1.AnnotationRefName] == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkWritable returns an error if the cache is read-only.
+func (h *Handle) checkWritable() error {
+	if h.readOnly {
+		return fmt.Errorf("cannot modify cache at %s: %w", h.rootDir, errReadOnlyCache)
+	}
+	return nil
+}
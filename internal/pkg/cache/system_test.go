@@ -0,0 +1,88 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSystemCache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cache-system-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	systemDir := filepath.Join(tmpDir, "system")
+	userDir := filepath.Join(tmpDir, "user")
+
+	// Seed the system cache like an administrator would
+	seed, err := New(Config{ParentDir: systemDir})
+	if err != nil {
+		t.Fatalf("could not create system cache: %v", err)
+	}
+	systemPath := addEntry(t, seed, LibraryCacheType, "shared", 10, time.Now())
+
+	blobDir := filepath.Join(systemDir, SubDirName, OciBlobCacheType)
+	if err := os.MkdirAll(blobDir, 0o755); err != nil {
+		t.Fatalf("could not create system blob cache: %v", err)
+	}
+	index := `{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1,` +
+		`"annotations":{"org.opencontainers.image.ref.name":"sharedtag"}}]}`
+	if err := ioutil.WriteFile(filepath.Join(blobDir, "index.json"), []byte(index), 0o644); err != nil {
+		t.Fatalf("could not write system blob cache index: %v", err)
+	}
+
+	h, err := New(Config{ParentDir: userDir, SystemDir: systemDir})
+	if err != nil {
+		t.Fatalf("could not create cache: %v", err)
+	}
+	if h.System() == nil || !h.System().IsReadOnly() {
+		t.Fatalf("expected a read-only system cache")
+	}
+
+	e, err := h.GetEntry(LibraryCacheType, "shared")
+	if err != nil {
+		t.Fatalf("could not get cache entry: %v", err)
+	}
+	if !e.Exists || !e.System || e.Path != systemPath {
+		t.Errorf("expected entry from system cache at %s, got %+v", systemPath, e)
+	}
+
+	e, err = h.GetEntry(LibraryCacheType, "private")
+	if err != nil {
+		t.Fatalf("could not get cache entry: %v", err)
+	}
+	defer e.CleanTmp()
+	if e.Exists || e.System || filepath.Dir(e.Path) != filepath.Join(userDir, SubDirName, LibraryCacheType) {
+		t.Errorf("expected new entry in user cache, got %+v", e)
+	}
+
+	dir, system, err := h.LookupOciCacheDir(OciBlobCacheType, "sharedtag")
+	if err != nil {
+		t.Fatalf("could not lookup OCI cache directory: %v", err)
+	}
+	if !system || dir != blobDir {
+		t.Errorf("expected system OCI cache directory %s, got %s", blobDir, dir)
+	}
+
+	_, system, err = h.LookupOciCacheDir(OciBlobCacheType, "privatetag")
+	if err != nil {
+		t.Fatalf("could not lookup OCI cache directory: %v", err)
+	}
+	if system {
+		t.Errorf("unexpected system OCI cache directory for missing tag")
+	}
+
+	if err := h.System().CleanCache(LibraryCacheType, false, 0); err == nil {
+		t.Errorf("unexpected success cleaning the system cache")
+	}
+}
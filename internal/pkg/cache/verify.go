@@ -0,0 +1,243 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hpcng/sif/v2/pkg/sif"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+const (
+	// VerifyEnv specifies the environment variable which enables the
+	// verification of cache entries each time they are read from the cache
+	VerifyEnv = "SINGULARITY_CACHE_VERIFY"
+
+	// orphanAge is the duration after which a temporary file that is no
+	// longer written to is considered as left behind by an interrupted
+	// download.
+	orphanAge = time.Hour
+)
+
+// VerifyStatus describes the outcome of the verification of a cache file.
+type VerifyStatus int
+
+const (
+	// VerifyOK means the cache file content matches its cache key or digest,
+	// or has a valid SIF structure when its key doesn't derive from it.
+	VerifyOK VerifyStatus = iota
+	// VerifyUnchecked means the cache file content can't be verified.
+	VerifyUnchecked
+	// VerifyCorrupt means the cache file content is corrupted.
+	VerifyCorrupt
+	// VerifyOrphaned means the cache file is a temporary file left behind by
+	// an interrupted download.
+	VerifyOrphaned
+)
+
+func (s VerifyStatus) String() string {
+	switch s {
+	case VerifyOK:
+		return "ok"
+	case VerifyUnchecked:
+		return "unchecked"
+	case VerifyCorrupt:
+		return "corrupt"
+	case VerifyOrphaned:
+		return "orphaned"
+	}
+	return "unknown"
+}
+
+// VerifyResult holds the outcome of the verification of a cache file.
+type VerifyResult struct {
+	// CacheType is the type of the cache holding the file
+	CacheType string
+	// Path is the location of the file
+	Path string
+	// Status is the outcome of the verification
+	Status VerifyStatus
+	// Reason explains why the file is corrupted
+	Reason error
+	// Removed is true if the file was removed from the cache
+	Removed bool
+}
+
+// VerifyCache verifies the files held in the cache for the specified cache
+// types. Entries are re-hashed against their cache key when it is a content
+// digest, OCI blobs against their digest, and other entries are checked for
+// a valid SIF structure. Temporary files left behind by interrupted
+// downloads are reported as orphaned. If remove is true, corrupted and
+// orphaned files are removed from the cache.
+func (h *Handle) VerifyCache(cacheTypes []string, remove bool) ([]VerifyResult, error) {
+	if h.disabled {
+		return nil, nil
+	}
+	if remove {
+		if err := h.checkWritable(); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []VerifyResult
+
+	for _, ct := range cacheTypes {
+		if !stringInSlice(ct, append(FileCacheTypes, OciCacheTypes...)) {
+			return nil, fmt.Errorf("%w: %s", errInvalidCacheType, ct)
+		}
+
+		dir := h.cacheTypeFilesDir(ct)
+		fis, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read %s cache directory: %v", ct, err)
+		}
+
+		for _, fi := range fis {
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+
+			r := VerifyResult{
+				CacheType: ct,
+				Path:      filepath.Join(dir, fi.Name()),
+			}
+
+			if strings.HasPrefix(fi.Name(), "tmp_") {
+				// A recently written temporary file is a download in progress
+				if time.Since(fi.ModTime()) < orphanAge {
+					continue
+				}
+				r.Status = VerifyOrphaned
+			} else {
+				r.Status, r.Reason = verifyFile(ct, r.Path)
+			}
+
+			if remove && (r.Status == VerifyCorrupt || r.Status == VerifyOrphaned) {
+				if err := h.removeEntryFile(ct, r.Path); err != nil {
+					sylog.Errorf("Could not remove cache entry '%s': %v", r.Path, err)
+				} else {
+					r.Removed = true
+				}
+			}
+
+			results = append(results, r)
+		}
+	}
+
+	return results, nil
+}
+
+// verifyEntry returns an error if the verification of the cache entry e
+// fails.
+func verifyEntry(e *Entry) error {
+	status, reason := verifyFile(e.CacheType, e.Path)
+	if status == VerifyCorrupt {
+		return reason
+	}
+	return nil
+}
+
+// verifyFile verifies the content of a cache file of the specified type.
+func verifyFile(cacheType string, path string) (VerifyStatus, error) {
+	name := filepath.Base(path)
+
+	// The cache key of these entries is the digest of their content
+	var digest string
+	switch {
+	case cacheType == LibraryCacheType && strings.HasPrefix(name, "sha256."):
+		digest = strings.TrimPrefix(name, "sha256.")
+	case cacheType == OrasCacheType && strings.HasPrefix(name, "sha256:"):
+		digest = strings.TrimPrefix(name, "sha256:")
+	case cacheType == OciBlobCacheType:
+		digest = name
+	}
+
+	if digest != "" {
+		sum, err := sha256File(path)
+		if err != nil {
+			return VerifyCorrupt, err
+		}
+		if sum != digest {
+			return VerifyCorrupt, fmt.Errorf("content digest sha256:%s doesn't match sha256:%s", sum, digest)
+		}
+		return VerifyOK, nil
+	}
+
+	return verifySIF(path)
+}
+
+// verifySIF checks that the SIF image at path is complete, i.e. that all its
+// data objects lie within the file. Files that aren't SIF images can't be
+// verified.
+func verifySIF(path string) (VerifyStatus, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return VerifyCorrupt, err
+	}
+
+	fimg, err := sif.LoadContainerFromPath(path, sif.OptLoadWithFlag(os.O_RDONLY))
+	if err != nil {
+		// Images pulled from Singularity Hub may not be SIF images
+		if !isSIF(path) {
+			return VerifyUnchecked, nil
+		}
+		return VerifyCorrupt, fmt.Errorf("invalid SIF image: %v", err)
+	}
+	defer fimg.UnloadContainer()
+
+	var reason error
+	fimg.WithDescriptors(func(d sif.Descriptor) bool {
+		if end := d.Offset() + d.Size(); end > fi.Size() {
+			reason = fmt.Errorf("truncated SIF image: object %d ends at offset %d beyond file size %d", d.ID(), end, fi.Size())
+			return true
+		}
+		return false
+	})
+	if reason != nil {
+		return VerifyCorrupt, reason
+	}
+
+	return VerifyOK, nil
+}
+
+// isSIF returns true if the file at path starts with a SIF launch script or
+// magic.
+func isSIF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	b := make([]byte, 64)
+	n, _ := io.ReadFull(f, b)
+	return strings.Contains(string(b[:n]), "SIF_MAGIC")
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
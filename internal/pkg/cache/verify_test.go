@@ -0,0 +1,143 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hpcng/sif/v2/pkg/sif"
+)
+
+// createSIF creates a SIF image holding a single generic data object at
+// path.
+func createSIF(t *testing.T, path string) {
+	t.Helper()
+
+	di, err := sif.NewDescriptorInput(sif.DataGeneric, bytes.NewReader(make([]byte, 4096)))
+	if err != nil {
+		t.Fatalf("could not create descriptor input: %v", err)
+	}
+	fimg, err := sif.CreateContainerAtPath(path, sif.OptCreateWithDescriptors(di))
+	if err != nil {
+		t.Fatalf("could not create SIF image: %v", err)
+	}
+	if err := fimg.UnloadContainer(); err != nil {
+		t.Fatalf("could not unload SIF image: %v", err)
+	}
+}
+
+func TestVerifyCache(t *testing.T) {
+	parent, err := ioutil.TempDir("", "cache-verify-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(parent)
+
+	h, err := New(Config{ParentDir: parent})
+	if err != nil {
+		t.Fatalf("could not create cache handle: %v", err)
+	}
+
+	libDir, _ := h.GetFileCacheDir(LibraryCacheType)
+	netDir, _ := h.GetFileCacheDir(NetCacheType)
+
+	// A library entry matching its key, and one that doesn't
+	content := []byte("library image")
+	sum := sha256.Sum256(content)
+	goodLib := filepath.Join(libDir, "sha256."+hex.EncodeToString(sum[:]))
+	badLib := filepath.Join(libDir, "sha256."+hex.EncodeToString(make([]byte, sha256.Size)))
+	for _, p := range []string{goodLib, badLib} {
+		if err := ioutil.WriteFile(p, content, 0o600); err != nil {
+			t.Fatalf("could not write %s: %v", p, err)
+		}
+	}
+
+	// A complete SIF image, and a truncated one
+	goodNet := filepath.Join(netDir, "good")
+	badNet := filepath.Join(netDir, "truncated")
+	createSIF(t, goodNet)
+	createSIF(t, badNet)
+	fi, err := os.Stat(badNet)
+	if err != nil {
+		t.Fatalf("could not stat %s: %v", badNet, err)
+	}
+	if err := os.Truncate(badNet, fi.Size()-1024); err != nil {
+		t.Fatalf("could not truncate %s: %v", badNet, err)
+	}
+
+	// An old temporary file, and one still being written
+	orphan := filepath.Join(netDir, "tmp_orphan")
+	inProgress := filepath.Join(netDir, "tmp_download")
+	for _, p := range []string{orphan, inProgress} {
+		if err := ioutil.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatalf("could not write %s: %v", p, err)
+		}
+	}
+	old := time.Now().Add(-2 * orphanAge)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatalf("could not set times of %s: %v", orphan, err)
+	}
+
+	want := map[string]VerifyStatus{
+		goodLib: VerifyOK,
+		badLib:  VerifyCorrupt,
+		goodNet: VerifyOK,
+		badNet:  VerifyCorrupt,
+		orphan:  VerifyOrphaned,
+	}
+
+	results, err := h.VerifyCache([]string{LibraryCacheType, NetCacheType}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != len(want) {
+		t.Errorf("got %d results, want %d", len(results), len(want))
+	}
+	for _, r := range results {
+		if r.Status != want[r.Path] {
+			t.Errorf("got status %s for %s, want %s (%v)", r.Status, r.Path, want[r.Path], r.Reason)
+		}
+	}
+
+	if _, err := h.VerifyCache([]string{NetCacheType}, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range []string{badNet, orphan} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", p)
+		}
+	}
+	for _, p := range []string{goodNet, inProgress} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s was unexpectedly removed: %v", p, err)
+		}
+	}
+
+	// Corrupted entries are discarded on read when verification is enabled
+	h.verifyOnRead = true
+	e, err := h.GetEntry(LibraryCacheType, filepath.Base(badLib))
+	if err != nil {
+		t.Fatalf("could not get cache entry: %v", err)
+	}
+	defer e.CleanTmp()
+	if e.Exists {
+		t.Errorf("corrupted cache entry was not discarded")
+	}
+	e, err = h.GetEntry(LibraryCacheType, filepath.Base(goodLib))
+	if err != nil {
+		t.Fatalf("could not get cache entry: %v", err)
+	}
+	if !e.Exists {
+		t.Errorf("valid cache entry was discarded")
+	}
+}
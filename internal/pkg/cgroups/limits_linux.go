@@ -0,0 +1,61 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cgroups
+
+import "fmt"
+
+const (
+	// CPUPeriod is the CPU period, in microseconds, used to enforce CPUs limits.
+	CPUPeriod = 100000
+	// minCPUQuota is the minimum CPU quota, in microseconds, accepted by the kernel.
+	minCPUQuota = 1000
+)
+
+// Limits are resource limits overriding the ones of a cgroups configuration,
+// zero values leave the configuration unchanged.
+type Limits struct {
+	// Memory is the memory limit in bytes.
+	Memory int64
+	// CPUs is the number of CPUs, enforced as a CPU quota.
+	CPUs float64
+	// Pids is the maximum number of processes.
+	Pids int64
+}
+
+// IsZero returns whether no limit is set.
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Apply sets the limits in the cgroups configuration config.
+func (l Limits) Apply(config *Config) error {
+	if l.Memory > 0 {
+		if config.Memory == nil {
+			config.Memory = &LinuxMemory{}
+		}
+		limit := l.Memory
+		config.Memory.Limit = &limit
+	}
+
+	if l.CPUs > 0 {
+		period := uint64(CPUPeriod)
+		quota := int64(l.CPUs * CPUPeriod)
+		if quota < minCPUQuota {
+			return fmt.Errorf("CPUs limit %g is below the minimum of %g", l.CPUs, float64(minCPUQuota)/CPUPeriod)
+		}
+		if config.CPU == nil {
+			config.CPU = &LinuxCPU{}
+		}
+		config.CPU.Period = &period
+		config.CPU.Quota = &quota
+	}
+
+	if l.Pids > 0 {
+		config.Pids = &LinuxPids{Limit: l.Pids}
+	}
+
+	return nil
+}
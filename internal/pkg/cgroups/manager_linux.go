@@ -46,6 +46,9 @@ type Manager interface {
 	Pause() error
 	// Resume unfreezes process in the managed cgroup.
 	Resume() error
+	// GetStats returns the resource usage of the processes in the managed
+	// cgroup.
+	GetStats() (*Stats, error)
 }
 
 // NewManagerFromFile creates a Manager, applies the configuration at specPath, and adds pid to the cgroup.
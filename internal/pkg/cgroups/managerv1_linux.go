@@ -163,3 +163,18 @@ func (m *ManagerV1) Resume() error {
 	}
 	return m.cgroup.Thaw()
 }
+
+// GetStats returns the resource usage of the processes in the managed cgroup.
+func (m *ManagerV1) GetStats() (*Stats, error) {
+	if m.cgroup == nil {
+		if err := m.load(); err != nil {
+			return nil, err
+		}
+	}
+	// controllers which are not mounted are ignored
+	metrics, err := m.cgroup.Stat(cgroups.IgnoreNotExist)
+	if err != nil {
+		return nil, err
+	}
+	return statsFromV1(metrics), nil
+}
@@ -55,6 +55,14 @@ func TestCgroupsV1(t *testing.T) {
 	cpuShares := filepath.Join(rootPath, "cpu", path, "cpu.shares")
 	ensureIntInFile(t, cpuShares, 1024)
 
+	stats, err := manager.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pids != 1 || stats.PidsLimit != 1024 {
+		t.Errorf("got %d/%d pids, expected 1/1024", stats.Pids, stats.PidsLimit)
+	}
+
 	content := []byte("[cpu]\nshares = 512")
 	tmpfile, err := ioutil.TempFile("", "cgroups")
 	if err != nil {
@@ -191,6 +191,20 @@ func (m *ManagerV2) Resume() (err error) {
 	return m.cgroup.Thaw()
 }
 
+// GetStats returns the resource usage of the processes in the managed cgroup.
+func (m *ManagerV2) GetStats() (*Stats, error) {
+	if m.cgroup == nil {
+		if err := m.load(); err != nil {
+			return nil, err
+		}
+	}
+	metrics, err := m.cgroup.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return statsFromV2(metrics), nil
+}
+
 // v2FixDevices modifies device entries to use an explicit, rather than implied
 // wildcard.
 //
@@ -56,6 +56,14 @@ func TestCgroupsV2(t *testing.T) {
 	pidsMax := filepath.Join(mountPoint, group, "pids.max")
 	ensureIntInFile(t, pidsMax, 1024)
 
+	stats, err := manager.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pids != 1 || stats.PidsLimit != 1024 {
+		t.Errorf("got %d/%d pids, expected 1/1024", stats.Pids, stats.PidsLimit)
+	}
+
 	// Write a new config with [pids] limit = 512
 	content := []byte("[pids]\nlimit = 512")
 	tmpfile, err := ioutil.TempFile("", "cgroups")
@@ -0,0 +1,92 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cgroups
+
+import (
+	"math"
+	"strings"
+
+	v1stats "github.com/containerd/cgroups/stats/v1"
+	v2stats "github.com/containerd/cgroups/v2/stats"
+)
+
+// Stats is the resource usage of the processes in a cgroup, common to the
+// v1 and v2 cgroups hierarchies.
+type Stats struct {
+	// CPUUsage is the total CPU time consumed, in nanoseconds.
+	CPUUsage uint64 `json:"cpuUsage"`
+	// MemoryUsage is the memory used, in bytes.
+	MemoryUsage uint64 `json:"memoryUsage"`
+	// MemoryLimit is the memory limit, in bytes, or 0 if there is none.
+	MemoryLimit uint64 `json:"memoryLimit"`
+	// Pids is the number of processes.
+	Pids uint64 `json:"pids"`
+	// PidsLimit is the maximum number of processes, or 0 if there is none.
+	PidsLimit uint64 `json:"pidsLimit"`
+	// IORead is the number of bytes read from block devices.
+	IORead uint64 `json:"ioRead"`
+	// IOWrite is the number of bytes written to block devices.
+	IOWrite uint64 `json:"ioWrite"`
+}
+
+// limit returns the limit l, or 0 if l means there is no limit: v1 reports
+// the largest page aligned int64 value and v2 the largest uint64 value.
+func limit(l uint64) uint64 {
+	if l >= math.MaxInt64/2 {
+		return 0
+	}
+	return l
+}
+
+// statsFromV1 returns the Stats of cgroups v1 metrics.
+func statsFromV1(m *v1stats.Metrics) *Stats {
+	s := &Stats{}
+	if m.CPU != nil && m.CPU.Usage != nil {
+		s.CPUUsage = m.CPU.Usage.Total
+	}
+	if m.Memory != nil && m.Memory.Usage != nil {
+		s.MemoryUsage = m.Memory.Usage.Usage
+		s.MemoryLimit = limit(m.Memory.Usage.Limit)
+	}
+	if m.Pids != nil {
+		s.Pids = m.Pids.Current
+		s.PidsLimit = limit(m.Pids.Limit)
+	}
+	if m.Blkio != nil {
+		for _, e := range m.Blkio.IoServiceBytesRecursive {
+			switch {
+			case strings.EqualFold(e.Op, "read"):
+				s.IORead += e.Value
+			case strings.EqualFold(e.Op, "write"):
+				s.IOWrite += e.Value
+			}
+		}
+	}
+	return s
+}
+
+// statsFromV2 returns the Stats of cgroups v2 metrics.
+func statsFromV2(m *v2stats.Metrics) *Stats {
+	s := &Stats{}
+	if m.CPU != nil {
+		s.CPUUsage = m.CPU.UsageUsec * 1000
+	}
+	if m.Memory != nil {
+		s.MemoryUsage = m.Memory.Usage
+		s.MemoryLimit = limit(m.Memory.UsageLimit)
+	}
+	if m.Pids != nil {
+		s.Pids = m.Pids.Current
+		s.PidsLimit = limit(m.Pids.Limit)
+	}
+	if m.Io != nil {
+		for _, e := range m.Io.Usage {
+			s.IORead += e.Rbytes
+			s.IOWrite += e.Wbytes
+		}
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cgroups
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	v1stats "github.com/containerd/cgroups/stats/v1"
+	v2stats "github.com/containerd/cgroups/v2/stats"
+)
+
+func TestStatsFromMetrics(t *testing.T) {
+	v1 := &v1stats.Metrics{
+		CPU:    &v1stats.CPUStat{Usage: &v1stats.CPUUsage{Total: 2000000000}},
+		Memory: &v1stats.MemoryStat{Usage: &v1stats.MemoryEntry{Usage: 1 << 20, Limit: math.MaxInt64 &^ 4095}},
+		Pids:   &v1stats.PidsStat{Current: 3, Limit: 1024},
+		Blkio: &v1stats.BlkIOStat{IoServiceBytesRecursive: []*v1stats.BlkIOEntry{
+			{Op: "Read", Major: 8, Value: 4096},
+			{Op: "Write", Major: 8, Value: 512},
+			{Op: "Total", Major: 8, Value: 4608},
+			{Op: "Read", Major: 253, Value: 4096},
+		}},
+	}
+	want := &Stats{
+		CPUUsage:    2000000000,
+		MemoryUsage: 1 << 20,
+		Pids:        3,
+		PidsLimit:   1024,
+		IORead:      8192,
+		IOWrite:     512,
+	}
+	if got := statsFromV1(v1); !reflect.DeepEqual(got, want) {
+		t.Errorf("got v1 stats %+v, want %+v", got, want)
+	}
+
+	v2 := &v2stats.Metrics{
+		CPU:    &v2stats.CPUStat{UsageUsec: 2000000},
+		Memory: &v2stats.MemoryStat{Usage: 1 << 20, UsageLimit: 1 << 30},
+		Pids:   &v2stats.PidsStat{Current: 3},
+		Io: &v2stats.IOStat{Usage: []*v2stats.IOEntry{
+			{Major: 8, Rbytes: 4096, Wbytes: 512},
+			{Major: 253, Rbytes: 4096},
+		}},
+	}
+	want.MemoryLimit = 1 << 30
+	want.PidsLimit = 0
+	if got := statsFromV2(v2); !reflect.DeepEqual(got, want) {
+		t.Errorf("got v2 stats %+v, want %+v", got, want)
+	}
+
+	// missing controllers are reported as zero usage
+	if got := statsFromV2(&v2stats.Metrics{}); !reflect.DeepEqual(got, &Stats{}) {
+		t.Errorf("got empty stats %+v", got)
+	}
+}
@@ -28,6 +28,35 @@ import (
 // ErrLibraryPullUnsigned indicates that the interactive portion of the pull was aborted.
 var ErrLibraryPullUnsigned = errors.New("failed to verify container")
 
+// getImage returns the library image imageRef for arch.
+func getImage(ctx context.Context, c *libclient.Client, imageRef *libclient.Ref, arch string) (*libclient.Image, error) {
+	ref := fmt.Sprintf("%s:%s", imageRef.Path, imageRef.Tags[0])
+
+	libraryImage, err := c.GetImage(ctx, arch, ref)
+	if err != nil {
+		if errors.Is(err, libclient.ErrNotFound) {
+			return nil, fmt.Errorf("image does not exist in the library: %s (%s)", ref, arch)
+		}
+		return nil, err
+	}
+	return libraryImage, nil
+}
+
+// GetImageHash returns the hash of the library image imageRef for arch, as
+// recorded by the library, without pulling it.
+func GetImageHash(ctx context.Context, imageRef *libclient.Ref, arch string, libraryConfig *libclient.Config) (string, error) {
+	c, err := libclient.NewClient(libraryConfig)
+	if err != nil {
+		return "", fmt.Errorf("unable to initialize client library: %v", err)
+	}
+
+	libraryImage, err := getImage(ctx, c, imageRef, arch)
+	if err != nil {
+		return "", err
+	}
+	return libraryImage.Hash, nil
+}
+
 // pull will pull a library image into the cache if directTo="", or a specific file if directTo is set.
 func pull(ctx context.Context, imgCache *cache.Handle, directTo string, imageRef *libclient.Ref, arch string, libraryConfig *libclient.Config) (string, error) {
 	c, err := libclient.NewClient(libraryConfig)
@@ -35,13 +64,8 @@ func pull(ctx context.Context, imgCache *cache.Handle, directTo string, imageRef
 		return "", fmt.Errorf("unable to initialize client library: %v", err)
 	}
 
-	ref := fmt.Sprintf("%s:%s", imageRef.Path, imageRef.Tags[0])
-
-	libraryImage, err := c.GetImage(ctx, arch, ref)
+	libraryImage, err := getImage(ctx, c, imageRef, arch)
 	if err != nil {
-		if errors.Is(err, libclient.ErrNotFound) {
-			return "", fmt.Errorf("image does not exist in the library: %s (%s)", ref, arch)
-		}
 		return "", err
 	}
 
@@ -63,6 +87,7 @@ func pull(ctx context.Context, imgCache *cache.Handle, directTo string, imageRef
 		return "", fmt.Errorf("unable to check if %v exists in cache: %v", libraryImage.Hash, err)
 	}
 	defer cacheEntry.CleanTmp()
+	cacheEntry.Source = imageRef.String()
 
 	if !cacheEntry.Exists {
 		if err := downloadWrapper(ctx, c, cacheEntry.TmpPath, arch, imageRef, progressBar); err != nil {
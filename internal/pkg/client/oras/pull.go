@@ -1,4 +1,4 @@
-// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2020-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -36,6 +36,7 @@ func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string
 			return "", fmt.Errorf("unable to check if %v exists in cache: %v", hash, err)
 		}
 		defer cacheEntry.CleanTmp()
+		cacheEntry.Source = pullFrom
 		if !cacheEntry.Exists {
 			sylog.Infof("Downloading oras image")
 
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -153,6 +153,7 @@ func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string
 			return "", fmt.Errorf("unable to check if %v exists in cache: %v", manifest.Commit, err)
 		}
 		defer cacheEntry.CleanTmp()
+		cacheEntry.Source = pullFrom
 		if !cacheEntry.Exists {
 			sylog.Infof("Downloading shub image")
 
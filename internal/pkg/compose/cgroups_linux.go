@@ -0,0 +1,47 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+)
+
+// Config returns the cgroups configuration enforcing the resource limits,
+// made of the cgroups configuration file, relative to dir, and of the
+// memory, CPUs and processes limits.
+func (cg *Cgroups) Config(dir string) (*cgroups.Config, error) {
+	var config cgroups.Config
+
+	if cg.File != "" {
+		path := cg.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		c, err := cgroups.LoadConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("while loading cgroups configuration %s: %v", path, err)
+		}
+		config = c
+	}
+
+	limits := cgroups.Limits{CPUs: cg.CPUs, Pids: cg.Pids}
+	if cg.Memory != "" {
+		limit, err := fs.ParseSize(cg.Memory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory limit: %v", err)
+		}
+		limits.Memory = limit
+	}
+	if err := limits.Apply(&config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package compose reads compose files, describing a set of cooperating
+// instances started and stopped together.
+package compose
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"github.com/hpcng/singularity/internal/pkg/util/fs"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultFile is the compose file used when none is specified.
+const DefaultFile = "compose.yaml"
+
+// Config describes the instances of a compose file.
+type Config struct {
+	// Name is the project name, prefixing the names of the instances. It
+	// defaults to the name of the directory of the compose file.
+	Name      string               `yaml:"name,omitempty"`
+	Instances map[string]*Instance `yaml:"instances"`
+
+	// Dir is the directory of the compose file, relative paths of the
+	// compose file are relative to this directory.
+	Dir string `yaml:"-"`
+}
+
+// Instance describes an instance of a compose file.
+type Instance struct {
+	// Image is the container image of the instance.
+	Image string `yaml:"image"`
+	// Args are the arguments passed to the instance start script.
+	Args []string `yaml:"args,omitempty"`
+	// Binds are the bind paths of the instance, in the --bind format.
+	Binds []string `yaml:"binds,omitempty"`
+	// Env are the environment variables set in the instance.
+	Env map[string]string `yaml:"env,omitempty"`
+	// Network is the network type of the instance, in the --network format.
+	Network string `yaml:"network,omitempty"`
+	// NetworkArgs are the network arguments, in the --network-args format.
+	NetworkArgs []string `yaml:"network-args,omitempty"`
+	// Cgroups are the resource limits of the instance.
+	Cgroups *Cgroups `yaml:"cgroups,omitempty"`
+	// DependsOn lists the instances which must be started before this one.
+	DependsOn []string `yaml:"depends-on,omitempty"`
+	// Restart is the restart policy of the instance, in the --restart format.
+	Restart string `yaml:"restart,omitempty"`
+	// HealthCmd is the command checking the instance health.
+	HealthCmd string `yaml:"health-cmd,omitempty"`
+	// Options are additional options passed to instance start.
+	Options []string `yaml:"options,omitempty"`
+}
+
+// Cgroups describes the resource limits of an instance.
+type Cgroups struct {
+	// File is a cgroups configuration file, in the --apply-cgroups format.
+	File string `yaml:"file,omitempty"`
+	// Memory is the memory limit, e.g. 512MiB, overriding the one of File.
+	Memory string `yaml:"memory,omitempty"`
+	// CPUs is the number of CPUs, overriding the CPU quota of File.
+	CPUs float64 `yaml:"cpus,omitempty"`
+	// Pids is the maximum number of processes, overriding the one of File.
+	Pids int64 `yaml:"pids,omitempty"`
+}
+
+// Load reads and validates the compose file path.
+func Load(path string) (*Config, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not determine compose file path: %v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read compose file: %v", err)
+	}
+
+	c := new(Config)
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("could not parse compose file %s: %v", path, err)
+	}
+	c.Dir = filepath.Dir(path)
+	if c.Name == "" {
+		c.Name = filepath.Base(c.Dir)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid compose file %s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	if len(c.Instances) == 0 {
+		return fmt.Errorf("no instance defined")
+	}
+
+	for _, name := range c.names() {
+		i := c.Instances[name]
+		if i == nil || i.Image == "" {
+			return fmt.Errorf("instance %s: no image specified", name)
+		}
+		if err := instance.CheckName(c.InstanceName(name)); err != nil {
+			return fmt.Errorf("instance %s: %v", name, err)
+		}
+		for _, dep := range i.DependsOn {
+			if _, ok := c.Instances[dep]; !ok {
+				return fmt.Errorf("instance %s: depends on undefined instance %s", name, dep)
+			}
+		}
+		if _, err := instance.ParseRestartPolicy(i.Restart); err != nil {
+			return fmt.Errorf("instance %s: %v", name, err)
+		}
+		if i.Cgroups != nil && i.Cgroups.Memory != "" {
+			if _, err := fs.ParseSize(i.Cgroups.Memory); err != nil {
+				return fmt.Errorf("instance %s: invalid memory limit: %v", name, err)
+			}
+		}
+	}
+
+	_, err := c.StartOrder()
+	return err
+}
+
+// names returns the sorted names of the instances.
+func (c *Config) names() []string {
+	names := make([]string, 0, len(c.Instances))
+	for name := range c.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InstanceName returns the name of the instance started for the
+// instance name of the compose file.
+func (c *Config) InstanceName(name string) string {
+	return c.Name + "-" + name
+}
+
+// StartOrder returns the names of the instances in the order they are
+// started, each instance coming after the instances it depends on.
+// Instances without dependency between them are sorted by name.
+func (c *Config) StartOrder() ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(c.Instances))
+	order := make([]string, 0, len(c.Instances))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle between instances: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+
+		deps := append([]string(nil), c.Instances[name].DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range c.names() {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
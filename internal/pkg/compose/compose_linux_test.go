@@ -0,0 +1,193 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package compose
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hpcng/singularity/internal/pkg/cgroups"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("could not write %s: %s", path, err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compose-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name        string
+		content     string
+		expectError string
+	}{
+		{
+			name: "valid",
+			content: `
+instances:
+  db:
+    image: db.sif
+    restart: on-failure:3
+    cgroups:
+      memory: 512MiB
+  web:
+    image: web.sif
+    depends-on: [db]
+`,
+		},
+		{
+			name:        "no instance",
+			content:     "name: app\n",
+			expectError: "no instance defined",
+		},
+		{
+			name:        "unknown key",
+			content:     "instances:\n  db:\n    image: db.sif\n    volumes: [data]\n",
+			expectError: "field volumes not found",
+		},
+		{
+			name:        "no image",
+			content:     "instances:\n  db:\n    args: [run]\n",
+			expectError: "instance db: no image specified",
+		},
+		{
+			name:        "bad instance name",
+			content:     "instances:\n  db/1:\n    image: db.sif\n",
+			expectError: "instance db/1:",
+		},
+		{
+			name:        "undefined dependency",
+			content:     "instances:\n  web:\n    image: web.sif\n    depends-on: [db]\n",
+			expectError: "instance web: depends on undefined instance db",
+		},
+		{
+			name:        "bad restart policy",
+			content:     "instances:\n  db:\n    image: db.sif\n    restart: sometimes\n",
+			expectError: "instance db:",
+		},
+		{
+			name:        "bad memory limit",
+			content:     "instances:\n  db:\n    image: db.sif\n    cgroups:\n      memory: lots\n",
+			expectError: "instance db: invalid memory limit",
+		},
+		{
+			name: "dependency cycle",
+			content: `
+instances:
+  a:
+    image: a.sif
+    depends-on: [b]
+  b:
+    image: b.sif
+    depends-on: [a]
+`,
+			expectError: "dependency cycle between instances: a -> b -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, dir, DefaultFile, tt.content)
+
+			c, err := Load(path)
+			if tt.expectError != "" {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				} else if !strings.Contains(err.Error(), tt.expectError) {
+					t.Fatalf("got error %q, want %q", err, tt.expectError)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if c.Dir != dir {
+				t.Errorf("got directory %s, want %s", c.Dir, dir)
+			}
+			if c.Name != filepath.Base(dir) {
+				t.Errorf("got name %s, want %s", c.Name, filepath.Base(dir))
+			}
+			if name := c.InstanceName("db"); name != c.Name+"-db" {
+				t.Errorf("got instance name %s, want %s", name, c.Name+"-db")
+			}
+		})
+	}
+}
+
+func TestStartOrder(t *testing.T) {
+	c := &Config{
+		Name: "app",
+		Instances: map[string]*Instance{
+			"web":    {Image: "web.sif", DependsOn: []string{"db", "cache"}},
+			"db":     {Image: "db.sif"},
+			"cache":  {Image: "cache.sif", DependsOn: []string{"db"}},
+			"worker": {Image: "worker.sif", DependsOn: []string{"cache"}},
+			"admin":  {Image: "admin.sif"},
+		},
+	}
+
+	order, err := c.StartOrder()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{"admin", "db", "cache", "web", "worker"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("got start order %v, want %v", order, expected)
+	}
+}
+
+func TestCgroupsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compose-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "cgroups.toml", "[memory]\n  limit = 1024\n[pids]\n  limit = 10\n")
+
+	cg := &Cgroups{
+		File:   "cgroups.toml",
+		Memory: "1MiB",
+		CPUs:   1.5,
+	}
+	config, err := cg.Config(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if config.Memory == nil || config.Memory.Limit == nil || *config.Memory.Limit != 1<<20 {
+		t.Errorf("memory limit not overridden: %+v", config.Memory)
+	}
+	if config.CPU == nil || config.CPU.Quota == nil || *config.CPU.Quota != 150000 ||
+		config.CPU.Period == nil || *config.CPU.Period != cgroups.CPUPeriod {
+		t.Errorf("unexpected CPU limit: %+v", config.CPU)
+	}
+	if config.Pids == nil || config.Pids.Limit != 10 {
+		t.Errorf("pids limit not loaded from file: %+v", config.Pids)
+	}
+
+	cg = &Cgroups{File: "missing.toml"}
+	if _, err := cg.Config(dir); err == nil {
+		t.Errorf("unexpected success with missing cgroups configuration")
+	}
+
+	cg = &Cgroups{CPUs: 0.005}
+	if _, err := cg.Config(dir); err == nil {
+		t.Errorf("unexpected success with a CPU quota below the kernel minimum")
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package instance
+
+import "time"
+
+const (
+	// HealthStarting is the health status of an instance until its
+	// health check succeeds or fails enough times in a row.
+	HealthStarting = "starting"
+	// HealthHealthy is the health status of an instance whose last
+	// health check succeeded.
+	HealthHealthy = "healthy"
+	// HealthUnhealthy is the health status of an instance whose health
+	// check failed the maximum number of times in a row.
+	HealthUnhealthy = "unhealthy"
+)
+
+const (
+	// DefaultHealthInterval is the default interval between two health
+	// checks of an instance.
+	DefaultHealthInterval = 30 * time.Second
+	// DefaultHealthRetries is the default number of consecutive health
+	// check failures before an instance is unhealthy.
+	DefaultHealthRetries = 3
+)
@@ -50,6 +50,16 @@ type File struct {
 	IP         string `json:"ip"`
 	LogErrPath string `json:"logErrPath"`
 	LogOutPath string `json:"logOutPath"`
+	// Restart is the restart policy of the instance start script.
+	Restart string `json:"restart,omitempty"`
+	// Restarts is the number of times the start script was restarted.
+	Restarts int `json:"restarts,omitempty"`
+	// ExitCode is the last exit status of the start script, nil
+	// while it never exited.
+	ExitCode *int `json:"exitCode,omitempty"`
+	// Health is the health status of the instance, empty if it has
+	// no health check.
+	Health string `json:"health,omitempty"`
 }
 
 // ProcName returns processus name based on instance name
@@ -0,0 +1,210 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package instance
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultLogMaxSize is the default size from which an instance
+	// log file is rotated.
+	DefaultLogMaxSize = "10MiB"
+	// DefaultLogMaxFiles is the default number of rotated log files
+	// kept for each instance log file.
+	DefaultLogMaxFiles = 3
+)
+
+const (
+	// StdoutStream is the stream name of the instance standard output.
+	StdoutStream = "stdout"
+	// StderrStream is the stream name of the instance standard error.
+	StderrStream = "stderr"
+)
+
+// LogLine is a line of an instance log file.
+type LogLine struct {
+	Time   time.Time
+	Stream string
+	Text   string
+}
+
+// FormatLogLine returns the line text written at time t as stored
+// in instance log files.
+func FormatLogLine(t time.Time, text string) string {
+	return fmt.Sprintf("%s %s\n", t.Format(time.RFC3339Nano), text)
+}
+
+// ParseLogLine returns the time and the text of a line stored in an
+// instance log file, ok is false if the line has no timestamp.
+func ParseLogLine(line string) (t time.Time, text string, ok bool) {
+	line = strings.TrimSuffix(line, "\n")
+
+	s := strings.SplitN(line, " ", 2)
+	if len(s) != 2 {
+		return t, line, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, s[0])
+	if err != nil {
+		return t, line, false
+	}
+	return t, s[1], true
+}
+
+// RotatedLogPath returns the path of the n-th rotated log file of
+// the log file path, the most recent being the first one.
+func RotatedLogPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// LogFilePaths returns the paths of the existing rotated log files of
+// the log file path, from the oldest to the most recent, followed by path.
+func LogFilePaths(path string) []string {
+	var paths []string
+
+	for n := 1; ; n++ {
+		p := RotatedLogPath(path, n)
+		if _, err := os.Stat(p); err != nil {
+			break
+		}
+		paths = append([]string{p}, paths...)
+	}
+	return append(paths, path)
+}
+
+// ReadLogLines reads the lines of stream from r until EOF, a line
+// without timestamp gets the time of the previous line.
+func ReadLogLines(r io.Reader, stream string, previous time.Time) ([]LogLine, error) {
+	var lines []LogLine
+
+	br := bufio.NewReader(r)
+	for {
+		s, err := br.ReadString('\n')
+		if s != "" {
+			t, text, ok := ParseLogLine(s)
+			if !ok {
+				t = previous
+			}
+			previous = t
+			lines = append(lines, LogLine{Time: t, Stream: stream, Text: text})
+		}
+		if err == io.EOF {
+			return lines, nil
+		} else if err != nil {
+			return lines, err
+		}
+	}
+}
+
+// LogWriter writes timestamped lines to an instance log file, rotating
+// it once it reaches a maximum size.
+type LogWriter struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewLogWriter opens the log file path for writing. The log file is
+// rotated before exceeding maxSize bytes, keeping at most maxFiles rotated
+// log files, it's never rotated if maxSize is zero.
+func NewLogWriter(path string, maxSize int64, maxFiles int) (*LogWriter, error) {
+	w := &LogWriter{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *LogWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND|syscall.O_NOFOLLOW, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = fi.Size()
+	return nil
+}
+
+// rotate shifts the rotated log files, removing the oldest one, and
+// re-opens an empty log file.
+func (w *LogWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	err := w.shift()
+	// the log file is re-opened even if the rotation failed to
+	// keep writing to it
+	if oerr := w.open(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+func (w *LogWriter) shift() error {
+	if w.maxFiles <= 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.Remove(RotatedLogPath(w.path, w.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := w.maxFiles - 1; n > 0; n-- {
+		err := os.Rename(RotatedLogPath(w.path, n), RotatedLogPath(w.path, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(w.path, RotatedLogPath(w.path, 1))
+}
+
+// WriteLine writes the line text written at time t to the log file.
+func (w *LogWriter) WriteLine(t time.Time, text string) error {
+	s := FormatLogLine(t, text)
+
+	var rotateErr error
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(s)) > w.maxSize {
+		rotateErr = w.rotate()
+	}
+
+	// the line is written even if the rotation failed
+	n, err := w.file.WriteString(s)
+	w.size += int64(n)
+	if rotateErr != nil {
+		return fmt.Errorf("while rotating log file %s: %s", w.path, rotateErr)
+	}
+	return err
+}
+
+// Fd returns the file descriptor of the current log file, which changes
+// when the log file is rotated.
+func (w *LogWriter) Fd() uintptr {
+	return w.file.Fd()
+}
+
+// Close closes the log file.
+func (w *LogWriter) Close() error {
+	return w.file.Close()
+}
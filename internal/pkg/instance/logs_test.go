@@ -0,0 +1,136 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package instance
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	ts := time.Date(2021, 6, 1, 15, 4, 5, 123456789, time.UTC)
+
+	tests := []struct {
+		line string
+		time time.Time
+		text string
+		ok   bool
+	}{
+		{line: FormatLogLine(ts, "hello world"), time: ts, text: "hello world", ok: true},
+		{line: FormatLogLine(ts, ""), time: ts, text: "", ok: true},
+		{line: "2021-06-01T15:04:05+02:00 hello\n", time: ts.Truncate(time.Second).Add(-2 * time.Hour), text: "hello", ok: true},
+		{line: "hello world\n", text: "hello world"},
+		{line: "2021-06-01T15:04:05Z", text: "2021-06-01T15:04:05Z"},
+		{line: "", text: ""},
+	}
+
+	for _, tt := range tests {
+		tm, text, ok := ParseLogLine(tt.line)
+		if ok != tt.ok || text != tt.text || !tm.Equal(tt.time) {
+			t.Errorf("got %s/%q/%v for %q, want %s/%q/%v", tm, text, ok, tt.line, tt.time, tt.text, tt.ok)
+		}
+	}
+}
+
+func TestReadLogLines(t *testing.T) {
+	ts := time.Date(2021, 6, 1, 15, 4, 5, 0, time.UTC)
+	previous := ts.Add(-time.Minute)
+
+	r := strings.NewReader("no timestamp\n" + FormatLogLine(ts, "first") + "continued\n" + "partial")
+
+	lines, err := ReadLogLines(r, StdoutStream, previous)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []LogLine{
+		{Time: previous, Stream: StdoutStream, Text: "no timestamp"},
+		{Time: ts, Stream: StdoutStream, Text: "first"},
+		{Time: ts, Stream: StdoutStream, Text: "continued"},
+		{Time: ts, Stream: StdoutStream, Text: "partial"},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got lines %+v, want %+v", lines, expected)
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "instance-logs-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.out")
+	ts := time.Date(2021, 6, 1, 15, 4, 5, 0, time.UTC)
+	lineSize := int64(len(FormatLogLine(ts, "line 0")))
+
+	w, err := NewLogWriter(path, 2*lineSize, 2)
+	if err != nil {
+		t.Fatalf("could not open log writer: %s", err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := w.WriteLine(ts, "line "+string(rune('0'+i))); err != nil {
+			t.Fatalf("unexpected error while writing line %d: %s", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error while closing log writer: %s", err)
+	}
+
+	paths := LogFilePaths(path)
+	expectedPaths := []string{RotatedLogPath(path, 2), RotatedLogPath(path, 1), path}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Fatalf("got log files %v, want %v", paths, expectedPaths)
+	}
+
+	// the oldest rotated log file holding lines 0 and 1 was removed
+	expectedLines := [][]string{{"line 2", "line 3"}, {"line 4", "line 5"}, {"line 6"}}
+	for i, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatalf("could not open %s: %s", p, err)
+		}
+		lines, err := ReadLogLines(f, StdoutStream, time.Time{})
+		f.Close()
+		if err != nil {
+			t.Fatalf("could not read %s: %s", p, err)
+		}
+
+		var texts []string
+		for _, l := range lines {
+			texts = append(texts, l.Text)
+		}
+		if !reflect.DeepEqual(texts, expectedLines[i]) {
+			t.Errorf("got lines %v in %s, want %v", texts, p, expectedLines[i])
+		}
+	}
+
+	// a log file without rotation limit is appended
+	w, err = NewLogWriter(path, 0, 2)
+	if err != nil {
+		t.Fatalf("could not open log writer: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.WriteLine(ts, "more"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	w.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("could not stat %s: %s", path, err)
+	}
+	if expected := lineSize + 3*int64(len(FormatLogLine(ts, "more"))); fi.Size() != expected {
+		t.Errorf("got log file size %d, want %d", fi.Size(), expected)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package instance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RestartNo never restarts the instance start script.
+	RestartNo = "no"
+	// RestartOnFailure restarts the instance start script when it
+	// exits with a non zero status.
+	RestartOnFailure = "on-failure"
+	// RestartAlways restarts the instance start script whatever its
+	// exit status is.
+	RestartAlways = "always"
+)
+
+// RestartPolicy represents when the start script of an instance is
+// restarted once it exited.
+type RestartPolicy struct {
+	// Mode is one of RestartNo, RestartOnFailure or RestartAlways.
+	Mode string
+	// MaxRetries is the maximum number of restarts with RestartOnFailure,
+	// 0 means unlimited.
+	MaxRetries int
+}
+
+// ParseRestartPolicy parses a restart policy with the format
+// no|on-failure[:max]|always, an empty string means no restart.
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	fields := strings.SplitN(s, ":", 2)
+	mode := fields[0]
+	hasMax := len(fields) == 2
+
+	var max string
+	if hasMax {
+		max = fields[1]
+	}
+
+	switch mode {
+	case "", RestartNo, RestartAlways:
+		if hasMax {
+			return RestartPolicy{}, fmt.Errorf("maximum restart count is only supported with %s policy", RestartOnFailure)
+		}
+		if mode == "" {
+			mode = RestartNo
+		}
+		return RestartPolicy{Mode: mode}, nil
+	case RestartOnFailure:
+		p := RestartPolicy{Mode: mode}
+		if hasMax {
+			n, err := strconv.Atoi(max)
+			if err != nil || n < 0 {
+				return RestartPolicy{}, fmt.Errorf("invalid maximum restart count %q", max)
+			}
+			p.MaxRetries = n
+		}
+		return p, nil
+	}
+
+	return RestartPolicy{}, fmt.Errorf("unknown restart policy %q, must be one of %s, %s[:max] or %s", mode, RestartNo, RestartOnFailure, RestartAlways)
+}
+
+// String returns the restart policy with the format accepted by
+// ParseRestartPolicy.
+func (p RestartPolicy) String() string {
+	if p.Mode == RestartOnFailure && p.MaxRetries > 0 {
+		return fmt.Sprintf("%s:%d", p.Mode, p.MaxRetries)
+	}
+	if p.Mode == "" {
+		return RestartNo
+	}
+	return p.Mode
+}
+
+// Enabled returns if the policy may restart the instance start script.
+func (p RestartPolicy) Enabled() bool {
+	return p.Mode == RestartOnFailure || p.Mode == RestartAlways
+}
+
+// ShouldRestart returns if the instance start script must be restarted
+// after it exited with exitCode, restarts is the number of times it was
+// already restarted.
+func (p RestartPolicy) ShouldRestart(exitCode int, restarts int) bool {
+	switch p.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exitCode != 0 && (p.MaxRetries == 0 || restarts < p.MaxRetries)
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package instance
+
+import (
+	"testing"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy      string
+		expected    RestartPolicy
+		str         string
+		expectError bool
+	}{
+		{policy: "", expected: RestartPolicy{Mode: RestartNo}, str: "no"},
+		{policy: "no", expected: RestartPolicy{Mode: RestartNo}, str: "no"},
+		{policy: "always", expected: RestartPolicy{Mode: RestartAlways}, str: "always"},
+		{policy: "on-failure", expected: RestartPolicy{Mode: RestartOnFailure}, str: "on-failure"},
+		{policy: "on-failure:3", expected: RestartPolicy{Mode: RestartOnFailure, MaxRetries: 3}, str: "on-failure:3"},
+		{policy: "on-failure:0", expected: RestartPolicy{Mode: RestartOnFailure}, str: "on-failure"},
+		{policy: "on-failure:", expectError: true},
+		{policy: "on-failure:-1", expectError: true},
+		{policy: "on-failure:x", expectError: true},
+		{policy: "always:3", expectError: true},
+		{policy: "unless-stopped", expectError: true},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseRestartPolicy(tt.policy)
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("unexpected success while parsing %q", tt.policy)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("unexpected error while parsing %q: %s", tt.policy, err)
+			continue
+		}
+		if p != tt.expected {
+			t.Errorf("got %+v for %q, want %+v", p, tt.policy, tt.expected)
+		}
+		if p.String() != tt.str {
+			t.Errorf("got %q for %q, want %q", p.String(), tt.policy, tt.str)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy   RestartPolicy
+		exitCode int
+		restarts int
+		expected bool
+	}{
+		{policy: RestartPolicy{Mode: RestartNo}, exitCode: 1, expected: false},
+		{policy: RestartPolicy{Mode: RestartAlways}, exitCode: 0, restarts: 100, expected: true},
+		{policy: RestartPolicy{Mode: RestartOnFailure}, exitCode: 0, expected: false},
+		{policy: RestartPolicy{Mode: RestartOnFailure}, exitCode: 137, restarts: 100, expected: true},
+		{policy: RestartPolicy{Mode: RestartOnFailure, MaxRetries: 2}, exitCode: 1, restarts: 1, expected: true},
+		{policy: RestartPolicy{Mode: RestartOnFailure, MaxRetries: 2}, exitCode: 1, restarts: 2, expected: false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.ShouldRestart(tt.exitCode, tt.restarts); got != tt.expected {
+			t.Errorf("got %v for %s with exit code %d after %d restarts, want %v", got, tt.policy, tt.exitCode, tt.restarts, tt.expected)
+		}
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,12 +6,41 @@
 package singularity
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/hpcng/singularity/internal/pkg/instance"
 	"github.com/hpcng/singularity/internal/pkg/plugin"
 	singularitycallback "github.com/hpcng/singularity/pkg/plugin/callback/runtime/engine/singularity"
+	singularityConfig "github.com/hpcng/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/hpcng/singularity/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// restartBaseDelay is the delay before restarting the instance start
+	// script, doubled for each consecutive restart.
+	restartBaseDelay = 100 * time.Millisecond
+	// restartMaxDelay is the maximum delay before restarting the instance
+	// start script.
+	restartMaxDelay = time.Minute
+	// restartResetTime is how long the instance start script must run
+	// for the restart delay to be reset to restartBaseDelay.
+	restartResetTime = 10 * time.Second
+	// maxLogLineSize is the size from which the lines written by the
+	// instance processes are split.
+	maxLogLineSize = 1 << 20
+	// exitFlushTimeout is how long the container process waits for its
+	// last lines to be forwarded to the master before exiting.
+	exitFlushTimeout = time.Second
 )
 
 // MonitorContainer is called from master once the container has
@@ -69,3 +98,550 @@ func (e *EngineOperations) MonitorContainer(pid int, signals chan os.Signal) (sy
 		}
 	}
 }
+
+// healthcheckPath is the path of the image healthcheck in the container.
+const healthcheckPath = "/.singularity.d/healthcheck"
+
+// instanceEvent is sent by the container process to the master each
+// time the instance start script exits or the instance health changes,
+// or with Log set for each line written by the start script.
+type instanceEvent struct {
+	Restarts int          `json:"restarts,omitempty"`
+	ExitCode *int         `json:"exitCode,omitempty"`
+	Health   string       `json:"health,omitempty"`
+	Log      *instanceLog `json:"log,omitempty"`
+}
+
+// instanceLog is a line written by an instance process on its standard
+// output or error stream. Line is a byte slice, encoded in base64, to keep
+// the output which isn't valid UTF-8 unchanged.
+type instanceLog struct {
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+	Line   []byte    `json:"line"`
+}
+
+// readLines calls fn with each line read from r, the stream of an instance
+// process, until EOF. Lines longer than maxLogLineSize are split.
+func readLines(r io.Reader, stream string, fn func(instanceLog)) {
+	br := bufio.NewReader(r)
+
+	var line []byte
+	for {
+		b, isPrefix, err := br.ReadLine()
+		if err != nil {
+			if len(line) > 0 {
+				fn(instanceLog{Stream: stream, Time: time.Now(), Line: line})
+			}
+			return
+		}
+		line = append(line, b...)
+		if isPrefix && len(line) < maxLogLineSize {
+			continue
+		}
+		fn(instanceLog{Stream: stream, Time: time.Now(), Line: line})
+		line = nil
+	}
+}
+
+// forwardOutput sends the lines read from r, the stream of the instance
+// start script, to logChan until EOF. The start script blocks on its
+// output while the lines aren't forwarded, none of them is dropped.
+func forwardOutput(r *os.File, stream string, logChan chan<- *instanceLog) {
+	defer r.Close()
+
+	readLines(r, stream, func(l instanceLog) {
+		logChan <- &l
+	})
+}
+
+// redirectOutput replaces the file descriptor fd, the standard output or
+// error of the current process, by a pipe whose lines are passed to fn.
+// wg is done once the pipe is closed by all its writers and read.
+func redirectOutput(fd int, stream string, wg *sync.WaitGroup, fn func(instanceLog)) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("while creating output pipe: %s", err)
+	}
+	defer w.Close()
+
+	if err := unix.Dup3(int(w.Fd()), fd, 0); err != nil {
+		r.Close()
+		return fmt.Errorf("while redirecting %s: %s", stream, err)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer r.Close()
+		readLines(r, stream, fn)
+	}()
+
+	return nil
+}
+
+// instanceHealth runs the instance health check periodically in the
+// container and tracks the instance health status.
+type instanceHealth struct {
+	args    []string
+	env     []string
+	retries int
+	ticker  *time.Ticker
+	// pid is the process ID of the running health check, if any.
+	pid      int
+	failures int
+	status   string
+}
+
+// newInstanceHealth returns the health check of the instance, running cmd
+// or the image healthcheck if cmd is empty, or nil if there is none.
+func newInstanceHealth(cmd string, interval time.Duration, retries int, env []string) *instanceHealth {
+	var args []string
+
+	if cmd != "" {
+		args = []string{defaultShell, "-c", cmd}
+	} else if fi, err := os.Stat(healthcheckPath); err == nil && fi.Mode()&0o111 != 0 {
+		args = []string{healthcheckPath}
+	} else {
+		return nil
+	}
+
+	if interval <= 0 {
+		interval = instance.DefaultHealthInterval
+	}
+	if retries <= 0 {
+		retries = instance.DefaultHealthRetries
+	}
+
+	return &instanceHealth{
+		args:    args,
+		env:     env,
+		retries: retries,
+		ticker:  time.NewTicker(interval),
+		status:  instance.HealthStarting,
+	}
+}
+
+// run starts the health check and returns if the health status changed.
+// A health check still running since the previous interval is killed,
+// its exit is then recorded as a failure.
+func (h *instanceHealth) run() bool {
+	if h.pid > 0 {
+		sylog.Debugf("Health check still running, killing it")
+		syscall.Kill(-h.pid, syscall.SIGKILL)
+		return false
+	}
+
+	cmd := exec.Command(h.args[0], h.args[1:]...)
+	cmd.Stderr = os.Stderr
+	cmd.Env = h.env
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	if err := cmd.Start(); err != nil {
+		sylog.Warningf("Could not run health check: %s", err)
+		return h.checked(false)
+	}
+	// the health check is reaped by the container process
+	h.pid = cmd.Process.Pid
+	cmd.Process.Release()
+
+	return false
+}
+
+// checked records the result of the health check and returns if the
+// health status changed.
+func (h *instanceHealth) checked(success bool) bool {
+	previous := h.status
+
+	h.pid = 0
+	if success {
+		h.failures = 0
+		h.status = instance.HealthHealthy
+	} else {
+		h.failures++
+		if h.failures >= h.retries {
+			h.status = instance.HealthUnhealthy
+		}
+	}
+
+	return h.status != previous
+}
+
+// instanceSupervisor restarts the instance start script according to the
+// instance restart policy, checks the instance health and reports both to
+// the master, along with the output of the start script and of the
+// container process itself.
+//
+// The master socket is only written by a dedicated goroutine, so that a
+// slow master never delays the handling of signals by the container
+// process.
+type instanceSupervisor struct {
+	policy   instance.RestartPolicy
+	health   *instanceHealth
+	restarts int
+	exitCode *int
+	// backoff is the number of consecutive restarts of a start script
+	// which didn't run for restartResetTime.
+	backoff  int
+	started  time.Time
+	stopping bool
+	// logs receives the lines forwarded to the master, a nil line
+	// requests flushed to be closed once the previous ones are forwarded.
+	logs    chan *instanceLog
+	flushed chan struct{}
+	// state holds the instance state not reported to the master yet.
+	state chan instanceEvent
+	// output is done once the output of the container process is read.
+	output sync.WaitGroup
+}
+
+// newInstanceSupervisor returns a supervisor of the instance. The master
+// socket is kept open to forward the lines received on logs and the output
+// of the container process, and to report the exits of the start script and
+// the instance health to the master.
+func newInstanceSupervisor(engineConfig *singularityConfig.EngineConfig, env []string, masterConnFd int, logs chan *instanceLog) (*instanceSupervisor, error) {
+	p, err := instance.ParseRestartPolicy(engineConfig.GetRestartPolicy())
+	if err != nil {
+		return nil, err
+	}
+
+	health := newInstanceHealth(
+		engineConfig.GetHealthCmd(),
+		engineConfig.GetHealthInterval(),
+		engineConfig.GetHealthRetries(),
+		env,
+	)
+
+	conn := os.NewFile(uintptr(masterConnFd), "master-socket")
+	if conn == nil {
+		return nil, fmt.Errorf("bad master socket file descriptor")
+	}
+	// any byte other than 'f' tells the master that the container
+	// process was started
+	if _, err := conn.Write([]byte("s")); err != nil {
+		return nil, fmt.Errorf("while writing master socket: %s", err)
+	}
+
+	s := &instanceSupervisor{
+		policy:  p,
+		health:  health,
+		started: time.Now(),
+		logs:    logs,
+		flushed: make(chan struct{}),
+		state:   make(chan instanceEvent, 1),
+	}
+	go s.forward(json.NewEncoder(conn))
+
+	// the output of the container process goes to the instance log
+	// files rotated by the master instead of the ones opened at start
+	for fd, stream := range map[int]string{1: instance.StdoutStream, 2: instance.StderrStream} {
+		if err := redirectOutput(fd, stream, &s.output, s.log); err != nil {
+			return nil, err
+		}
+	}
+
+	if health != nil {
+		s.report()
+	}
+
+	return s, nil
+}
+
+// forward writes the instance events to the master. Once the master is
+// gone, the events are dropped as the instance log files can't be rotated
+// anymore, but they are still read to never block their writers.
+func (s *instanceSupervisor) forward(enc *json.Encoder) {
+	gone := false
+	send := func(event instanceEvent) {
+		if gone {
+			return
+		}
+		if err := enc.Encode(event); err != nil {
+			gone = true
+			sylog.Debugf("Could not forward instance event to master: %s", err)
+		}
+	}
+
+	for {
+		select {
+		case event := <-s.state:
+			send(event)
+		case l := <-s.logs:
+			if l != nil {
+				send(instanceEvent{Log: l})
+				continue
+			}
+			select {
+			case event := <-s.state:
+				send(event)
+			default:
+			}
+			close(s.flushed)
+			return
+		}
+	}
+}
+
+// report sends the instance state to the master, replacing the previous
+// state if it's not sent yet.
+func (s *instanceSupervisor) report() {
+	event := instanceEvent{Restarts: s.restarts, ExitCode: s.exitCode}
+	if s.health != nil {
+		event.Health = s.health.status
+	}
+	// report is only called by the container process main loop, the
+	// state channel can't be filled again before the send
+	select {
+	case <-s.state:
+	default:
+	}
+	s.state <- event
+}
+
+// log forwards the line l written by the container process to the master.
+// The line is dropped if the master doesn't keep up, so that the container
+// process never blocks on its own output.
+func (s *instanceSupervisor) log(l instanceLog) {
+	select {
+	case s.logs <- &l:
+	default:
+	}
+}
+
+// exit exits the container process with code once its output and the
+// lines already read are forwarded to the master, or after
+// exitFlushTimeout.
+func (s *instanceSupervisor) exit(code int) {
+	timeout := time.After(exitFlushTimeout)
+
+	// close the write end of the output pipes
+	if null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		unix.Dup3(int(null.Fd()), 1, 0)
+		unix.Dup3(int(null.Fd()), 2, 0)
+		null.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.output.Wait()
+		s.logs <- nil
+		<-s.flushed
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-timeout:
+	}
+	os.Exit(code)
+}
+
+// start records that the start script was (re)started.
+func (s *instanceSupervisor) start() {
+	s.started = time.Now()
+}
+
+// stop prevents any further restart of the start script, it's called
+// when the instance is requested to stop.
+func (s *instanceSupervisor) stop() {
+	s.stopping = true
+}
+
+// exited reports the exit status of the start script to the master and
+// returns the delay before restarting it, or false if it must not be
+// restarted.
+func (s *instanceSupervisor) exited(status syscall.WaitStatus) (time.Duration, bool) {
+	exitCode := status.ExitStatus()
+	if status.Signaled() {
+		exitCode = 128 + int(status.Signal())
+	}
+	s.exitCode = &exitCode
+
+	var delay time.Duration
+
+	restart := !s.stopping && s.policy.ShouldRestart(exitCode, s.restarts)
+	if restart {
+		if time.Since(s.started) >= restartResetTime {
+			s.backoff = 0
+		}
+		delay = restartDelay(s.backoff)
+		s.backoff++
+		s.restarts++
+	}
+	s.report()
+
+	return delay, restart
+}
+
+// healthTick returns the channel ticking each time the instance health
+// must be checked, or nil if there is no health check.
+func (s *instanceSupervisor) healthTick() <-chan time.Time {
+	if s.health == nil {
+		return nil
+	}
+	return s.health.ticker.C
+}
+
+// checkHealth runs the instance health check.
+func (s *instanceSupervisor) checkHealth() {
+	if s.health.run() {
+		s.report()
+	}
+}
+
+// reaped is called when the container process reaped the process pid,
+// recording the result of the health check if pid is the health check.
+func (s *instanceSupervisor) reaped(pid int, status syscall.WaitStatus) {
+	if s.health == nil || s.health.pid != pid {
+		return
+	}
+	if s.health.checked(status.Exited() && status.ExitStatus() == 0) {
+		s.report()
+	}
+}
+
+// restartDelay returns the delay before the n-th consecutive restart of
+// the start script, growing exponentially up to restartMaxDelay.
+func restartDelay(n int) time.Duration {
+	delay := restartBaseDelay
+	for i := 0; i < n && delay < restartMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > restartMaxDelay {
+		return restartMaxDelay
+	}
+	return delay
+}
+
+// isStopSignal returns if the signal s requests the instance to stop.
+func isStopSignal(s syscall.Signal) bool {
+	return s == syscall.SIGINT || s == syscall.SIGTERM || s == syscall.SIGQUIT
+}
+
+// instanceLogs writes the lines of the instance processes to the instance
+// log files.
+type instanceLogs struct {
+	mu      sync.Mutex
+	writers map[string]*instance.LogWriter
+	// output is done once the redirected output of the master is read.
+	output sync.WaitGroup
+}
+
+// write writes line to the log file of its stream.
+func (l *instanceLogs) write(line instanceLog) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.writers[line.Stream]
+	if !ok {
+		return nil
+	}
+	return w.WriteLine(line.Time, string(line.Line))
+}
+
+// restoreOutput makes the standard output and error of the master write
+// directly to the current log files.
+func (l *instanceLogs) restoreOutput() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for fd, stream := range map[int]string{1: instance.StdoutStream, 2: instance.StderrStream} {
+		unix.Dup3(int(l.writers[stream].Fd()), fd, 0)
+	}
+}
+
+// close stops the redirection of the master output and closes the log
+// files once the output is written.
+func (l *instanceLogs) close() {
+	l.restoreOutput()
+	l.output.Wait()
+	// the remaining lines may have rotated the log files
+	l.restoreOutput()
+
+	for _, w := range l.writers {
+		w.Close()
+	}
+}
+
+// MonitorInstance is called from master once the instance has been
+// started. It writes the output of the instance processes and of the
+// master to the instance log files, rotating them, and records the exits
+// of the start script and the instance health in the instance file, as
+// reported by the container process, until the container process closes
+// the master socket.
+//
+// No additional privileges are gained here, the instance file and log
+// files belong to the user.
+func (e *EngineOperations) MonitorInstance(r io.Reader) error {
+	if !e.EngineConfig.GetInstance() {
+		return nil
+	}
+	// the container process blocks while its events are not read
+	defer io.Copy(ioutil.Discard, r)
+
+	name := e.CommonConfig.ContainerID
+
+	logErrPath, logOutPath, err := instance.GetLogFilePaths(name, instance.LogSubDir)
+	if err != nil {
+		return fmt.Errorf("while getting instance %s log files: %s", name, err)
+	}
+	logs := &instanceLogs{writers: make(map[string]*instance.LogWriter)}
+	for stream, path := range map[string]string{
+		instance.StdoutStream: logOutPath,
+		instance.StderrStream: logErrPath,
+	} {
+		w, err := instance.NewLogWriter(path, e.EngineConfig.GetLogMaxSize(), e.EngineConfig.GetLogMaxFiles())
+		if err != nil {
+			for _, w := range logs.writers {
+				w.Close()
+			}
+			return fmt.Errorf("while opening instance %s log file: %s", name, err)
+		}
+		logs.writers[stream] = w
+	}
+	defer logs.close()
+
+	// the output of the master, opened at start, is written through the
+	// rotated log files too
+	writeOutput := func(l instanceLog) {
+		logs.write(l)
+	}
+	for fd, stream := range map[int]string{1: instance.StdoutStream, 2: instance.StderrStream} {
+		if err := redirectOutput(fd, stream, &logs.output, writeOutput); err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(r)
+
+	for {
+		var event instanceEvent
+
+		if err := dec.Decode(&event); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("while reading instance event: %s", err)
+		}
+
+		if event.Log != nil {
+			if err := logs.write(*event.Log); err != nil {
+				sylog.Warningf("While writing instance %s log: %s", name, err)
+			}
+			continue
+		}
+
+		file, err := instance.Get(name, instance.SingSubDir)
+		if err != nil {
+			sylog.Warningf("While reading instance %s file: %s", name, err)
+			continue
+		}
+		file.Restarts = event.Restarts
+		file.ExitCode = event.ExitCode
+		file.Health = event.Health
+
+		if err := file.Update(); err != nil {
+			sylog.Warningf("While updating instance %s file: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hpcng/singularity/internal/pkg/instance"
+	"golang.org/x/sys/unix"
+)
+
+func TestRestartDelay(t *testing.T) {
+	tests := []struct {
+		n        int
+		expected time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{4, 1600 * time.Millisecond},
+		{9, 51200 * time.Millisecond},
+		{10, time.Minute},
+		{1000, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if d := restartDelay(tt.n); d != tt.expected {
+			t.Errorf("got delay %s for restart %d, want %s", d, tt.n, tt.expected)
+		}
+	}
+}
+
+func TestInstanceHealthChecked(t *testing.T) {
+	h := &instanceHealth{retries: 2, status: instance.HealthStarting}
+
+	checks := []struct {
+		success  bool
+		status   string
+		changed  bool
+		failures int
+	}{
+		{false, instance.HealthStarting, false, 1},
+		{true, instance.HealthHealthy, true, 0},
+		{false, instance.HealthHealthy, false, 1},
+		{false, instance.HealthUnhealthy, true, 2},
+		{false, instance.HealthUnhealthy, false, 3},
+		{true, instance.HealthHealthy, true, 0},
+	}
+
+	for i, c := range checks {
+		h.pid = 42
+		changed := h.checked(c.success)
+		if changed != c.changed || h.status != c.status || h.failures != c.failures {
+			t.Errorf("check %d: got %s/%v with %d failures, want %s/%v with %d failures",
+				i, h.status, changed, h.failures, c.status, c.changed, c.failures)
+		}
+		if h.pid != 0 {
+			t.Errorf("check %d: health check pid not reset", i)
+		}
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	long := strings.Repeat("a", 10000)
+	binary := "\xff\xfe binary"
+	split := strings.Repeat("b", maxLogLineSize+10)
+
+	input := "first\n" + long + "\n" + binary + "\n" + split + "\nlast"
+
+	var lines []string
+	readLines(strings.NewReader(input), instance.StdoutStream, func(l instanceLog) {
+		if l.Stream != instance.StdoutStream {
+			t.Errorf("got stream %s, want %s", l.Stream, instance.StdoutStream)
+		}
+		// lines are forwarded to the master as JSON
+		b, err := json.Marshal(l)
+		if err != nil {
+			t.Fatalf("could not encode line: %s", err)
+		}
+		var decoded instanceLog
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Fatalf("could not decode line: %s", err)
+		}
+		lines = append(lines, string(decoded.Line))
+	})
+
+	expected := []string{"first", long, binary, split[:maxLogLineSize], split[maxLogLineSize:], "last"}
+	if len(lines) != len(expected) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(expected))
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("line %d: got %d bytes %.20q, want %d bytes %.20q", i, len(lines[i]), lines[i], len(expected[i]), expected[i])
+		}
+	}
+}
+
+func TestRedirectOutput(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("could not open %s: %s", os.DevNull, err)
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+
+	var mu sync.Mutex
+	var lines []string
+	var wg sync.WaitGroup
+
+	err = redirectOutput(fd, instance.StderrStream, &wg, func(l instanceLog) {
+		mu.Lock()
+		lines = append(lines, string(l.Line))
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := unix.Write(fd, []byte("one\ntwo\nthree")); err != nil {
+		t.Fatalf("could not write to redirected file descriptor: %s", err)
+	}
+
+	// closing the write end of the pipe ends the redirection
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("could not open %s: %s", os.DevNull, err)
+	}
+	defer null.Close()
+	if err := unix.Dup3(int(null.Fd()), fd, 0); err != nil {
+		t.Fatalf("could not restore file descriptor: %s", err)
+	}
+	wg.Wait()
+
+	if expected := []string{"one", "two", "three"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got lines %v, want %v", lines, expected)
+	}
+}
+
+func TestInstanceSupervisorForward(t *testing.T) {
+	var buf bytes.Buffer
+
+	s := &instanceSupervisor{
+		logs:    make(chan *instanceLog, 4),
+		flushed: make(chan struct{}),
+		state:   make(chan instanceEvent, 1),
+	}
+
+	// only the last state not reported yet is sent
+	s.report()
+	s.restarts = 2
+	s.report()
+	s.log(instanceLog{Stream: instance.StdoutStream, Line: []byte("line")})
+	s.logs <- nil
+
+	s.forward(json.NewEncoder(&buf))
+
+	var events []instanceEvent
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e instanceEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("could not decode event: %s", err)
+		}
+		events = append(events, e)
+	}
+
+	var restarts []int
+	logs := 0
+	for _, e := range events {
+		if e.Log != nil {
+			logs++
+		} else {
+			restarts = append(restarts, e.Restarts)
+		}
+	}
+	if logs != 1 || !reflect.DeepEqual(restarts, []int{2}) {
+		t.Errorf("got events %+v, want one line and one state with 2 restarts", events)
+	}
+}
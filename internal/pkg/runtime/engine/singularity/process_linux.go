@@ -184,34 +184,20 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 	statusChan := make(chan syscall.WaitStatus, 1)
 	cmdPid := -2
 
+	// logChan receives the output of the instance start script
+	var logChan chan *instanceLog
+	if isInstance {
+		logChan = make(chan *instanceLog, 64)
+	}
+
 	args, env, err := runActionScript(e.EngineConfig)
 	if err != nil {
 		return err
 	} else if len(args) > 0 {
-	cmdexec:
-		// Spawn and wait container process, signal handler
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Stdin = os.Stdin
-		cmd.Env = env
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Setpgid: isInstance,
-		}
-		if err := cmd.Start(); err != nil {
-			if e, ok := err.(*os.PathError); ok {
-				if e.Err.(syscall.Errno) == syscall.ENOEXEC && args[0] != defaultShell {
-					args = append([]string{defaultShell}, args...)
-					goto cmdexec
-				}
-			}
-			return fmt.Errorf("exec %s failed: %s", args[0], err)
+		cmdPid, err = startProcess(args, env, isInstance, errChan, logChan)
+		if err != nil {
+			return err
 		}
-		cmdPid = cmd.Process.Pid
-
-		go func() {
-			errChan <- cmd.Wait()
-		}()
 	}
 
 	// Modify argv argument and program name shown in /proc/self/comm
@@ -233,7 +219,29 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 		return syscall.Errno(err)
 	}
 
-	syscall.Close(masterConnFd)
+	// instances keep the master socket open to forward the output of
+	// the start script and to report its exits and their health
+	var supervisor *instanceSupervisor
+
+	if isInstance {
+		supervisor, err = newInstanceSupervisor(e.EngineConfig, env, masterConnFd, logChan)
+		if err != nil {
+			return fmt.Errorf("while setting up instance supervision: %s", err)
+		}
+	}
+	if supervisor == nil {
+		syscall.Close(masterConnFd)
+	}
+
+	// exitChan receives the exit status of the start script to
+	// restart it, restartChan fires once it must be restarted
+	var exitChan chan syscall.WaitStatus
+	var restartChan, healthChan <-chan time.Time
+
+	if supervisor != nil {
+		exitChan = statusChan
+		healthChan = supervisor.healthTick()
+	}
 
 	for {
 		select {
@@ -251,8 +259,12 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 					}
 
 					if wpid == cmdPid {
-						e.stopFuseDrivers()
+						if supervisor == nil {
+							e.stopFuseDrivers()
+						}
 						statusChan <- status
+					} else if supervisor != nil {
+						supervisor.reaped(wpid, status)
 					}
 				}
 			case syscall.SIGURG:
@@ -262,6 +274,19 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 				break
 			default:
 				signal := s.(syscall.Signal)
+				if supervisor != nil {
+					if isStopSignal(signal) {
+						supervisor.stop()
+					}
+					// the start script is waiting to be restarted
+					if restartChan != nil {
+						if supervisor.stopping {
+							sylog.Debugf("No child process, exiting ...")
+							supervisor.exit(128 + int(signal))
+						}
+						break
+					}
+				}
 				// EPERM and EINVAL are deliberately ignored because they can't be
 				// returned in this context, this process is PID 1, so it has the
 				// permissions to send signals to its childs and EINVAL would
@@ -270,6 +295,9 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 				if isInstance && cmdPid > 0 {
 					if err := syscall.Kill(-cmdPid, signal); err == syscall.ESRCH {
 						sylog.Debugf("No child process, exiting ...")
+						if supervisor != nil {
+							supervisor.exit(128 + int(signal))
+						}
 						os.Exit(128 + int(signal))
 					}
 				} else if e.EngineConfig.GetSignalPropagation() && cmdPid > 0 {
@@ -292,6 +320,8 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 				if e.Err.(syscall.Errno) != syscall.ECHILD {
 					sylog.Fatalf("error while waiting container process: %s", e.Error())
 				}
+			} else if err == nil && supervisor != nil {
+				statusChan <- 0
 			}
 			if !isInstance {
 				if len(statusChan) > 0 {
@@ -305,10 +335,82 @@ func (e *EngineOperations) StartProcess(masterConnFd int) error {
 				}
 				sylog.Fatalf("command exited with unknown error: %s", err)
 			}
+		case status := <-exitChan:
+			delay, restart := supervisor.exited(status)
+			if !restart {
+				e.stopFuseDrivers()
+				break
+			}
+			sylog.Debugf("Restarting instance start script in %s", delay)
+			restartChan = time.After(delay)
+		case <-restartChan:
+			restartChan = nil
+			cmdPid, err = startProcess(args, env, isInstance, errChan, logChan)
+			if err != nil {
+				sylog.Fatalf("while restarting instance start script: %s", err)
+			}
+			supervisor.start()
+		case <-healthChan:
+			supervisor.checkHealth()
 		}
 	}
 }
 
+// startProcess spawns the container process, the result of its wait
+// is sent to errChan once it exits. If logChan is not nil, the lines
+// written by the process on its standard output and error streams are
+// sent to logChan.
+func startProcess(args, env []string, isInstance bool, errChan chan<- error, logChan chan<- *instanceLog) (int, error) {
+	stdout, stderr := os.Stdout, os.Stderr
+
+	if logChan != nil {
+		outr, outw, err := os.Pipe()
+		if err != nil {
+			return -1, fmt.Errorf("while creating output pipe: %s", err)
+		}
+		errr, errw, err := os.Pipe()
+		if err != nil {
+			outr.Close()
+			outw.Close()
+			return -1, fmt.Errorf("while creating output pipe: %s", err)
+		}
+		// write ends are only kept open by the process and its
+		// children, the forwarding stops once they are all closed
+		defer outw.Close()
+		defer errw.Close()
+
+		go forwardOutput(outr, instance.StdoutStream, logChan)
+		go forwardOutput(errr, instance.StderrStream, logChan)
+
+		stdout, stderr = outw, errw
+	}
+
+cmdexec:
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Stdin = os.Stdin
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: isInstance,
+	}
+	if err := cmd.Start(); err != nil {
+		if e, ok := err.(*os.PathError); ok {
+			if e.Err.(syscall.Errno) == syscall.ENOEXEC && args[0] != defaultShell {
+				args = append([]string{defaultShell}, args...)
+				goto cmdexec
+			}
+		}
+		return -1, fmt.Errorf("exec %s failed: %s", args[0], err)
+	}
+
+	go func() {
+		errChan <- cmd.Wait()
+	}()
+
+	return cmd.Process.Pid, nil
+}
+
 // PostStartProcess is called from master after successful
 // execution of the container process. It will write instance
 // state/config files (if any).
@@ -361,6 +463,7 @@ func (e *EngineOperations) PostStartProcess(ctx context.Context, pid int) error
 		file.Image = e.EngineConfig.GetImage()
 		file.LogErrPath = logErrPath
 		file.LogOutPath = logOutPath
+		file.Restart = e.EngineConfig.GetRestartPolicy()
 
 		ip, err := e.getIP()
 		if err != nil {
@@ -12,6 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -602,3 +603,44 @@ func FindSize(size int64) string {
 	}
 	return fmt.Sprintf("%.2f %s", float64(size)/factor, unit)
 }
+
+// ParseSize takes a human-readable size string such as "512MiB", "10G" or
+// "1024" and returns the corresponding size in bytes. Units are always
+// interpreted as powers of 1024, and a missing unit means bytes.
+func ParseSize(s string) (int64, error) {
+	str := strings.TrimSpace(s)
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == 0 || str == "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	num, unit := str, ""
+	if i > 0 {
+		num, unit = str[:i], strings.TrimSpace(str[i:])
+	}
+
+	var factor float64
+	switch strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(unit), "b"), "i") {
+	case "":
+		factor = 1
+	case "k":
+		factor = kiB
+	case "m":
+		factor = miB
+	case "g":
+		factor = giB
+	case "t":
+		factor = tiB
+	default:
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+
+	value, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", s, err)
+	}
+
+	return int64(value * factor), nil
+}
@@ -787,3 +787,38 @@ func TestForceRemoveAll(t *testing.T) {
 		t.Errorf("ForceRemoveAll failed to remove %s", testDir)
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{name: "bytes", size: "1024", want: 1024},
+		{name: "kib", size: "4KiB", want: 4 * kiB},
+		{name: "short mib", size: "512M", want: 512 * miB},
+		{name: "gb", size: "10 GB", want: 10 * giB},
+		{name: "fractional", size: "1.5g", want: giB + giB/2},
+		{name: "tib", size: "2TiB", want: 2 * tiB},
+		{name: "lowercase gb", size: "10gb", want: 10 * giB},
+		{name: "lowercase gib", size: "10 gib", want: 10 * giB},
+		{name: "lowercase kb", size: "2kb", want: 2 * kiB},
+		{name: "empty", size: "", wantErr: true},
+		{name: "no number", size: "GiB", wantErr: true},
+		{name: "negative", size: "-1G", wantErr: true},
+		{name: "unknown unit", size: "1PB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error for %q: %v", tt.size, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
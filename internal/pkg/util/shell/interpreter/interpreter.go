@@ -1,4 +1,4 @@
-// Copyright (c) 2020, Sylabs, Inc. All rights reserved.
+// Copyright (c) 2020-2021, Sylabs, Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license.  Please
 // consult LICENSE.md file distributed with the sources of this project regarding
 // your rights to use or distribute this software.
@@ -8,6 +8,7 @@ package interpreter
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -325,3 +326,19 @@ func EvaluateEnv(script []byte, args []string, envs []string) ([]string, error)
 
 	return env, nil
 }
+
+// CheckSyntax parses the shell script without running it and returns the
+// first syntax error found along with the line where it occurs in the
+// script, or a nil error if the script is valid.
+func CheckSyntax(script []byte, name string) (uint, error) {
+	_, err := syntax.NewParser().Parse(bytes.NewReader(script), name)
+	if err == nil {
+		return 0, nil
+	}
+
+	var perr syntax.ParseError
+	if errors.As(err, &perr) {
+		return perr.Pos.Line(), fmt.Errorf("%s", perr.Text)
+	}
+	return 0, err
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2020, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	ocitypes "github.com/containers/image/v5/types"
 	"github.com/hpcng/singularity/internal/pkg/cache"
@@ -26,6 +27,7 @@ const OCIConfigJSON = "oci-config"
 // Bundle is the temporary environment used during the image building process.
 type Bundle struct {
 	JSONObjects map[string][]byte `json:"jsonObjects"`
+	DataObjects map[string][]byte `json:"dataObjects"` // generic binary data objects stored in the image
 	Recipe      Definition        `json:"rawDeffile"`
 	Opts        Options           `json:"opts"`
 
@@ -35,6 +37,15 @@ type Bundle struct {
 	parentPath string // parent directory for RootfsPath
 }
 
+// Secret is a host file bind mounted read-only under /run/secrets during
+// the %post section of a build.
+type Secret struct {
+	// ID is the name of the secret file under /run/secrets.
+	ID string `json:"id"`
+	// Source is the path of the secret file on the host.
+	Source string `json:"source"`
+}
+
 // Options defines build time behavior to be executed on the bundle.
 type Options struct {
 	// Sections are the parts of the definition to run during the build.
@@ -68,6 +79,24 @@ type Options struct {
 	NoCleanUp bool `json:"noCleanUp"`
 	// NoCache when true, will not use any cache, or make cache.
 	NoCache bool
+	// NoStageCache when true, will not reuse the cached root filesystems
+	// of intermediate stages of a multi-stage build, nor cache them.
+	NoStageCache bool `json:"noStageCache"`
+	// Arch is the architecture of the image to select from multi-architecture
+	// OCI sources, the host architecture is selected when empty.
+	Arch string `json:"arch"`
+	// BuildArgs are the build arguments used to resolve the definition,
+	// recorded in the build provenance.
+	BuildArgs map[string]string `json:"buildArgs"`
+	// Secrets are host files made available to the %post section only,
+	// they are never stored in the image.
+	Secrets []Secret
+	// Reproducible when true, makes the build output deterministic by
+	// using SourceDateEpoch for all timestamps and for the image ID.
+	Reproducible bool `json:"reproducible"`
+	// SourceDateEpoch is the time, in seconds since the Unix epoch, recorded
+	// in the image of a reproducible build.
+	SourceDateEpoch int64 `json:"sourceDateEpoch"`
 	// FixPerms controls if we will ensure owner rwX on container content
 	// to preserve <=3.4 behavior.
 	// TODO: Deprecate in 3.6, remove in 3.8
@@ -102,6 +131,15 @@ func (b *Bundle) RunSection(s string) bool {
 	return false
 }
 
+// BuildTime returns the build time recorded in the image, which is the
+// source date epoch of reproducible builds or the current time otherwise.
+func (b *Bundle) BuildTime() time.Time {
+	if b.Opts.Reproducible {
+		return time.Unix(b.Opts.SourceDateEpoch, 0).UTC()
+	}
+	return time.Now()
+}
+
 // Remove cleans up any bundle files.
 func (b *Bundle) Remove() error {
 	var errors []string
@@ -226,6 +264,7 @@ func newBundle(parentPath, tempDir string, keyInfo *cryptkey.KeyInfo) (*Bundle,
 		RootfsPath:  rootfsPath,
 		TmpDir:      tmpPath,
 		JSONObjects: make(map[string][]byte),
+		DataObjects: make(map[string][]byte),
 		Opts: Options{
 			EncryptionKeyInfo: keyInfo,
 		},
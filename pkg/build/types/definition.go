@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -41,6 +42,7 @@ type ImageScripts struct {
 	Runscript   Script `json:"runScript"`
 	Test        Script `json:"test"`
 	Startscript Script `json:"startScript"`
+	Healthcheck Script `json:"healthCheck"`
 }
 
 // Data contains any scripts, metadata, etc... that the Builder may
@@ -94,13 +96,19 @@ func NewDefinitionFromURI(uri string) (d Definition, err error) {
 		},
 	}
 
-	var buf bytes.Buffer
-	populateRaw(&d, &buf)
-	d.Raw = buf.Bytes()
+	d.PopulateRaw()
 
 	return d, nil
 }
 
+// PopulateRaw sets the raw data of the definition to the definition file
+// describing it.
+func (d *Definition) PopulateRaw() {
+	var buf bytes.Buffer
+	populateRaw(d, &buf)
+	d.Raw = buf.Bytes()
+}
+
 // NewDefinitionFromJSON creates a new Definition using the supplied JSON.
 func NewDefinitionFromJSON(r io.Reader) (d Definition, err error) {
 	decoder := json.NewDecoder(r)
@@ -115,9 +123,7 @@ func NewDefinitionFromJSON(r io.Reader) (d Definition, err error) {
 
 	// if JSON definition doesn't have a raw data section, add it
 	if len(d.Raw) == 0 {
-		var buf bytes.Buffer
-		populateRaw(&d, &buf)
-		d.Raw = buf.Bytes()
+		d.PopulateRaw()
 	}
 
 	return d, nil
@@ -143,23 +149,40 @@ func writeFilesIfExists(w io.Writer, f []Files) {
 			fmt.Fprintln(w)
 
 			for _, ft := range f.Files {
-				fmt.Fprintf(w, "\t%s\t%s\n", ft.Src, ft.Dst)
+				fmt.Fprintf(w, "\t%s\t%s\n", quoteFilePath(ft.Src), quoteFilePath(ft.Dst))
 			}
 			fmt.Fprintln(w)
 		}
 	}
 }
 
+// quoteFilePath quotes the paths of %files sections containing spaces.
+func quoteFilePath(path string) string {
+	if strings.ContainsAny(path, " \t") {
+		return `"` + path + `"`
+	}
+	return path
+}
+
 func writeLabelsIfExists(w io.Writer, l map[string]string) {
 	if len(l) > 0 {
 		fmt.Fprintln(w, "%labels")
-		for k, v := range l {
-			fmt.Fprintf(w, "\t%s %s\n", k, v)
+		for _, k := range sortedKeys(l) {
+			fmt.Fprintf(w, "\t%s %s\n", k, l[k])
 		}
 		fmt.Fprintln(w)
 	}
 }
 
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // populateRaw is a helper func to output a Definition struct
 // into a definition file.
 func populateRaw(d *Definition, w io.Writer) {
@@ -168,13 +191,13 @@ func populateRaw(d *Definition, w io.Writer) {
 		fmt.Fprintf(w, "%s: %s\n", "bootstrap", v)
 	}
 
-	for k, v := range d.Header {
+	for _, k := range sortedKeys(d.Header) {
 		// filter out bootstrap parameter since it should already be added
 		if k == "bootstrap" {
 			continue
 		}
 
-		fmt.Fprintf(w, "%s: %s\n", k, v)
+		fmt.Fprintf(w, "%s: %s\n", k, d.Header[k])
 	}
 	fmt.Fprintln(w)
 
@@ -186,6 +209,7 @@ func populateRaw(d *Definition, w io.Writer) {
 	writeSectionIfExists(w, "runscript", d.ImageData.Runscript)
 	writeSectionIfExists(w, "test", d.ImageData.Test)
 	writeSectionIfExists(w, "startscript", d.ImageData.Startscript)
+	writeSectionIfExists(w, "healthcheck", d.ImageData.Healthcheck)
 	writeSectionIfExists(w, "pre", d.BuildData.Pre)
 	writeSectionIfExists(w, "setup", d.BuildData.Setup)
 	writeSectionIfExists(w, "post", d.BuildData.Post)
@@ -0,0 +1,243 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const argumentsSection = "arguments"
+
+var (
+	// argumentName matches a valid build argument name
+	argumentName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// placeholder matches a {{ name }} build argument placeholder
+	placeholder = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+	// stageHeader matches the first header line of a stage
+	stageHeader = regexp.MustCompile(`(?i)^bootstrap:`)
+)
+
+// argument is a build argument declared in an %arguments section or
+// in a build arguments file.
+type argument struct {
+	name  string
+	value string
+	// set is false for arguments declared without a default value
+	set bool
+}
+
+// parseArgument parses a "name=value" build argument line, the value may
+// be enclosed in double or single quotes, otherwise it ends at the first
+// comment. A line without value declares an argument which must be
+// provided at build time.
+func parseArgument(line string) (argument, error) {
+	split := strings.SplitN(line, "=", 2)
+	arg := argument{name: strings.TrimSpace(strings.Split(split[0], "#")[0])}
+	if !argumentName.MatchString(arg.name) {
+		return arg, fmt.Errorf("invalid build argument name %q", arg.name)
+	}
+	if len(split) == 2 {
+		arg.value = unquoteArgument(strings.TrimSpace(split[1]))
+		arg.set = true
+	}
+	return arg, nil
+}
+
+func unquoteArgument(value string) string {
+	if value != "" && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	return strings.TrimSpace(strings.Split(value, "#")[0])
+}
+
+// formatArgument returns the "name=value" declaration of a build argument,
+// quoting values which wouldn't be parsed back as is otherwise.
+func formatArgument(name, value string) string {
+	if value == "" || strings.ContainsAny(value, " \t#'\"") {
+		if !strings.Contains(value, `"`) {
+			return name + `="` + value + `"`
+		} else if !strings.Contains(value, `'`) {
+			return name + `='` + value + `'`
+		}
+	}
+	return name + "=" + value
+}
+
+// walkArguments calls fn for each line of the raw definition lines, with
+// isArgument set for the argument declarations of %arguments sections.
+// A section ends at the next section or at the header of the next stage.
+func walkArguments(lines []string, fn func(n int, line string, isArgument bool) error) error {
+	inArguments := false
+	for i, line := range lines {
+		trimLine := strings.TrimSpace(line)
+		if strings.HasPrefix(trimLine, "%") {
+			inArguments = getSectionName(trimLine) == argumentsSection
+		} else if stageHeader.MatchString(trimLine) {
+			inArguments = false
+		}
+		isArgument := inArguments && trimLine != "" && !strings.HasPrefix(trimLine, "%") && !strings.HasPrefix(trimLine, "#")
+		if err := fn(i+1, line, isArgument); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseArguments parses build arguments from r, one "name=value" per line.
+// Empty lines and lines starting with # are ignored.
+func ParseArguments(r io.Reader) (map[string]string, error) {
+	args := make(map[string]string)
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		arg, err := parseArgument(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if !arg.set {
+			return nil, fmt.Errorf("line %d: no value for build argument %s", n, arg.name)
+		}
+		args[arg.name] = arg.value
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
+// declaredArguments returns the arguments declared in the %arguments
+// sections of the raw definition lines.
+func declaredArguments(lines []string) (map[string]argument, error) {
+	declared := make(map[string]argument)
+	err := walkArguments(lines, func(n int, line string, isArgument bool) error {
+		if !isArgument {
+			return nil
+		}
+		arg, err := parseArgument(strings.TrimSpace(line))
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		prev, ok := declared[arg.name]
+		if ok && prev.set && arg.set && prev.value != arg.value {
+			return fmt.Errorf("line %d: build argument %s declared with different default values", n, arg.name)
+		}
+		if !ok || arg.set {
+			declared[arg.name] = arg
+		}
+		return nil
+	})
+	return declared, err
+}
+
+// ResolveArguments replaces the {{ name }} placeholders of the raw
+// definition with the value of the corresponding build argument. Values
+// are taken from args first, then from the defaults declared in the
+// %arguments sections of the definition, which apply to all the stages of
+// the definition. The %arguments sections are rewritten with the resolved
+// values, so that the returned definition records the values used and
+// resolves to itself. Placeholders without value are reported as errors.
+func ResolveArguments(raw []byte, args map[string]string) ([]byte, error) {
+	return resolveArguments(raw, args, true)
+}
+
+// resolveArguments resolves the placeholders of the raw definition like
+// ResolveArguments does, placeholders and arguments without value are left
+// unchanged rather than reported as errors when strict is false, so that
+// definitions can be checked without the values provided at build time.
+func resolveArguments(raw []byte, args map[string]string, strict bool) ([]byte, error) {
+	lines := strings.SplitAfter(string(raw), "\n")
+
+	declared, err := declaredArguments(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for name, arg := range declared {
+		if arg.set {
+			values[name] = arg.value
+		}
+	}
+	for name, value := range args {
+		values[name] = value
+	}
+
+	var buf bytes.Buffer
+	var unresolved []string
+	walkArguments(lines, func(n int, line string, isArgument bool) error {
+		if isArgument {
+			// record the resolved value of the argument
+			trimLine := strings.TrimSpace(line)
+			arg, _ := parseArgument(trimLine)
+			value, ok := values[arg.name]
+			if !ok {
+				if !strict {
+					buf.WriteString(line)
+				}
+				unresolved = append(unresolved, fmt.Sprintf("%s (line %d)", arg.name, n))
+				return nil
+			}
+			indent := line[:strings.Index(line, trimLine)]
+			eol := line[len(strings.TrimRight(line, "\r\n")):]
+			buf.WriteString(indent + formatArgument(arg.name, value) + eol)
+			return nil
+		}
+
+		buf.WriteString(placeholder.ReplaceAllStringFunc(line, func(p string) string {
+			name := placeholder.FindStringSubmatch(p)[1]
+			if value, ok := values[name]; ok {
+				return value
+			}
+			unresolved = append(unresolved, fmt.Sprintf("%s (line %d)", name, n))
+			return p
+		}))
+		return nil
+	})
+
+	if len(unresolved) > 0 && strict {
+		return nil, fmt.Errorf("no value for build argument(s): %s", strings.Join(unresolved, ", "))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnusedArguments returns the sorted names of the build arguments of args
+// which are neither declared nor used by the raw definition.
+func UnusedArguments(raw []byte, args map[string]string) []string {
+	used := make(map[string]bool)
+	for _, m := range placeholder.FindAllSubmatch(raw, -1) {
+		used[string(m[1])] = true
+	}
+
+	walkArguments(strings.Split(string(raw), "\n"), func(n int, line string, isArgument bool) error {
+		if arg, err := parseArgument(strings.TrimSpace(line)); isArgument && err == nil {
+			used[arg.name] = true
+		}
+		return nil
+	})
+
+	var unused []string
+	for name := range args {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveArguments(t *testing.T) {
+	tests := []struct {
+		name      string
+		def       string
+		args      map[string]string
+		want      string
+		shouldErr bool
+	}{
+		{
+			name: "no arguments",
+			def:  "Bootstrap: docker\nFrom: alpine\n%post\n    echo hello\n",
+			want: "Bootstrap: docker\nFrom: alpine\n%post\n    echo hello\n",
+		},
+		{
+			name:      "undeclared without arguments",
+			def:       "Bootstrap: docker\nFrom: alpine:{{ tag }}\n",
+			shouldErr: true,
+		},
+		{
+			name: "defaults",
+			def:  "Bootstrap: docker\nFrom: alpine:{{ tag }}\n%arguments\n    tag=3.14\n%post\n    echo {{tag}}\n",
+			want: "Bootstrap: docker\nFrom: alpine:3.14\n%arguments\n    tag=3.14\n%post\n    echo 3.14\n",
+		},
+		{
+			name: "override",
+			def:  "Bootstrap: docker\nFrom: alpine:{{ tag }}\n%arguments\n    # the alpine tag\n    tag=\"3.14\" # comment\n",
+			args: map[string]string{"tag": "3.13"},
+			want: "Bootstrap: docker\nFrom: alpine:3.13\n%arguments\n    # the alpine tag\n    tag=3.13\n",
+		},
+		{
+			name: "required",
+			def:  "Bootstrap: docker\nFrom: {{ image }}\n%arguments\n    image\n",
+			args: map[string]string{"image": "centos:8"},
+			want: "Bootstrap: docker\nFrom: centos:8\n%arguments\n    image=centos:8\n",
+		},
+		{
+			name:      "required missing",
+			def:       "Bootstrap: docker\nFrom: {{ image }}\n%arguments\n    image\n",
+			shouldErr: true,
+		},
+		{
+			name: "undeclared",
+			def:  "Bootstrap: docker\nFrom: {{ image }}\n",
+			args: map[string]string{"image": "centos:8"},
+			want: "Bootstrap: docker\nFrom: centos:8\n",
+		},
+		{
+			name:      "unresolved",
+			def:       "Bootstrap: docker\nFrom: alpine\n%arguments\n    tag=3.14\n%post\n    echo {{ version }}\n",
+			shouldErr: true,
+		},
+		{
+			name:      "invalid name",
+			def:       "Bootstrap: docker\nFrom: alpine\n%arguments\n    3tag=3.14\n",
+			shouldErr: true,
+		},
+		{
+			name: "quoted value",
+			def:  "Bootstrap: docker\nFrom: alpine\n%arguments\n    msg=\"hello\"\n%post\n    echo {{ msg }}\n",
+			args: map[string]string{"msg": "hello world"},
+			want: "Bootstrap: docker\nFrom: alpine\n%arguments\n    msg=\"hello world\"\n%post\n    echo hello world\n",
+		},
+		{
+			name: "multi-stage",
+			def: "Bootstrap: docker\nFrom: golang:{{ go }}\nStage: build\n%arguments\n    go=1.16\n" +
+				"Bootstrap: docker\nFrom: alpine\nStage: final\n%labels\n    go {{ go }}\n",
+			want: "Bootstrap: docker\nFrom: golang:1.16\nStage: build\n%arguments\n    go=1.16\n" +
+				"Bootstrap: docker\nFrom: alpine\nStage: final\n%labels\n    go 1.16\n",
+		},
+		{
+			name:      "conflicting defaults",
+			def:       "Bootstrap: docker\nFrom: alpine\n%arguments\n    go=1.16\nBootstrap: docker\nFrom: alpine\n%arguments\n    go=1.15\n",
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveArguments([]byte(tt.def), tt.args)
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+
+			// the resolved definition resolves to itself
+			again, err := ResolveArguments(got, nil)
+			if err != nil {
+				t.Fatalf("unexpected error resolving resolved definition: %v", err)
+			}
+			if string(again) != string(got) {
+				t.Errorf("resolved definition changed:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestParseArguments(t *testing.T) {
+	args, err := ParseArguments(strings.NewReader("# versions\nGO=1.16\n\nmsg='hello world'\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"GO": "1.16", "msg": "hello world"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("got %v, want %v", args, want)
+	}
+
+	if _, err := ParseArguments(strings.NewReader("GO\n")); err == nil {
+		t.Errorf("unexpected success for argument without value")
+	}
+}
+
+func TestUnusedArguments(t *testing.T) {
+	def := "Bootstrap: docker\nFrom: alpine:{{ tag }}\n%arguments\n    version=1\n"
+	args := map[string]string{"tag": "3.14", "version": "2", "other": "x", "another": "y"}
+
+	got := UnusedArguments([]byte(def), args)
+	if want := []string{"another", "other"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAllArguments(t *testing.T) {
+	def := "Bootstrap: docker\nFrom: golang:{{ go }}\nStage: build\n%arguments\n    go=1.16\n" +
+		"Bootstrap: docker\nFrom: alpine\nStage: final\n%post\n    echo {{ go }}\n"
+
+	defs, err := All(strings.NewReader(def))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(defs) != 2 {
+		t.Fatalf("got %d stages, want 2", len(defs))
+	}
+	if from := defs[0].Header["from"]; from != "golang:1.16" {
+		t.Errorf("got from %q, want golang:1.16", from)
+	}
+	if post := strings.TrimSpace(defs[1].BuildData.Post.Script); post != "echo 1.16" {
+		t.Errorf("got %%post %q, want echo 1.16", post)
+	}
+	if strings.Contains(string(defs[1].Raw), "{{") {
+		t.Errorf("unresolved placeholders in recorded definition:\n%s", defs[1].Raw)
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -309,6 +309,7 @@ func populateDefinition(sections map[string]*types.Script, files *[]types.Files,
 			Runscript:   *sections["runscript"],
 			Test:        *sections["test"],
 			Startscript: *sections["startscript"],
+			Healthcheck: *sections["healthcheck"],
 		},
 		Labels: GetLabels(sections["labels"].Script),
 	}
@@ -360,6 +361,7 @@ func doHeader(h string, d *types.Definition) error {
 	toks := strings.Split(h, "\n")
 	header := make(map[string]string)
 	keyCont, valCont := "", ""
+	var unknownKeys []string
 
 	for _, line := range toks {
 		var key, val string
@@ -401,12 +403,20 @@ func doHeader(h string, d *types.Definition) error {
 				_, ok = validHeaders[tmpKey]
 			}
 			if !ok {
-				return fmt.Errorf("invalid header keyword found: %s", key)
+				unknownKeys = append(unknownKeys, key)
 			}
 		}
 		header[key] = val
 	}
 
+	// header keywords of custom bootstrap agents provided by plugins
+	// are passed through, the plugins being responsible for them
+	if len(unknownKeys) > 0 {
+		if b, ok := header["bootstrap"]; !ok || builtinAgents[b] {
+			return fmt.Errorf("invalid header keyword found: %s", unknownKeys[0])
+		}
+	}
+
 	// only set header if some values are found
 	if len(header) != 0 {
 		d.Header = header
@@ -419,11 +429,25 @@ func doHeader(h string, d *types.Definition) error {
 // and parse it into a Definition struct or return error if
 // the definition file has a bad section.
 func ParseDefinitionFile(r io.Reader) (d types.Definition, err error) {
-	d.Raw, err = ioutil.ReadAll(r)
+	raw, err := ioutil.ReadAll(r)
 	if err != nil {
 		return d, fmt.Errorf("while attempting to read in definition: %v", err)
 	}
 
+	// resolve placeholders with the default build arguments
+	raw, err = ResolveArguments(raw, nil)
+	if err != nil {
+		return d, err
+	}
+
+	return parseDefinition(raw)
+}
+
+// parseDefinition parses the raw definition of a stage, whose
+// placeholders are already resolved.
+func parseDefinition(raw []byte) (d types.Definition, err error) {
+	d.Raw = raw
+
 	s := bufio.NewScanner(bytes.NewReader(d.Raw))
 	s.Split(scanDefinitionFile)
 
@@ -449,13 +473,28 @@ func ParseDefinitionFile(r io.Reader) (d types.Definition, err error) {
 // and parses it into a slice of Definition structs or returns error if
 // an error is encounter while parsing
 func All(r io.Reader) ([]types.Definition, error) {
-	var stages []types.Definition
-
 	raw, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, fmt.Errorf("while attempting to read in definition: %v", err)
 	}
 
+	return parseAll(raw, true)
+}
+
+// parseAll parses the raw definition file into the definitions of its
+// stages, with the placeholders resolved with the default build arguments.
+// Placeholders without default value are left unresolved rather than
+// reported as errors when strict is false, to check definitions using
+// build arguments provided at build time.
+func parseAll(raw []byte, strict bool) ([]types.Definition, error) {
+	var stages []types.Definition
+
+	// resolve placeholders with the default build arguments, for all stages
+	raw, err := resolveArguments(raw, nil, strict)
+	if err != nil {
+		return nil, err
+	}
+
 	// copy raw data for parsing
 	buf := raw
 	rgx := regexp.MustCompile(`(?mi)^bootstrap:`)
@@ -483,7 +522,7 @@ func All(r io.Reader) ([]types.Definition, error) {
 			continue
 		}
 
-		d, err := ParseDefinitionFile(bytes.NewReader(stage))
+		d, err := parseDefinition(stage)
 		if err != nil {
 			if err == errEmptyDefinition {
 				continue
@@ -541,6 +580,7 @@ func isEmpty(d types.Definition) bool {
 // validSections just contains a list of all the valid sections a definition file
 // could contain. If any others are found, an error will generate
 var validSections = map[string]bool{
+	"arguments":   true,
 	"help":        true,
 	"setup":       true,
 	"files":       true,
@@ -551,6 +591,7 @@ var validSections = map[string]bool{
 	"runscript":   true,
 	"test":        true,
 	"startscript": true,
+	"healthcheck": true,
 }
 
 var appSections = map[string]bool{
@@ -563,26 +604,49 @@ var appSections = map[string]bool{
 	"apprun":     true,
 }
 
+// builtinAgents contains the bootstrap agents built into Singularity, any
+// other agent is provided by a plugin
+var builtinAgents = map[string]bool{
+	"library":        true,
+	"oras":           true,
+	"shub":           true,
+	"docker":         true,
+	"docker-archive": true,
+	"docker-daemon":  true,
+	"oci":            true,
+	"oci-archive":    true,
+	"busybox":        true,
+	"debootstrap":    true,
+	"arch":           true,
+	"localimage":     true,
+	"yum":            true,
+	"zypper":         true,
+	"apk":            true,
+	"scratch":        true,
+}
+
 // validHeaders just contains a list of all the valid headers a definition file
 // could contain. If any others are found, an error will generate
 var validHeaders = map[string]bool{
-	"bootstrap":    true,
-	"from":         true,
-	"includecmd":   true,
-	"mirrorurl":    true,
-	"updateurl":    true,
-	"osversion":    true,
-	"include":      true,
-	"library":      true,
-	"registry":     true,
-	"namespace":    true,
-	"stage":        true,
-	"product":      true,
-	"user":         true,
-	"regcode":      true,
-	"productpgp":   true,
-	"registerurl":  true,
-	"modules":      true,
-	"otherurl&n":   true,
-	"fingerprints": true,
+	"bootstrap":      true,
+	"from":           true,
+	"includecmd":     true,
+	"mirrorurl":      true,
+	"updateurl":      true,
+	"osversion":      true,
+	"include":        true,
+	"library":        true,
+	"registry":       true,
+	"namespace":      true,
+	"stage":          true,
+	"product":        true,
+	"user":           true,
+	"regcode":        true,
+	"productpgp":     true,
+	"registerurl":    true,
+	"modules":        true,
+	"otherurl&n":     true,
+	"fingerprints":   true,
+	"keysdir":        true,
+	"allowuntrusted": true,
 }
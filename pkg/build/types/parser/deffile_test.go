@@ -1,4 +1,4 @@
-// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2021, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -189,6 +189,7 @@ func TestParseDefinitionFile(t *testing.T) {
 		{"MultipleFiles", "testdata_good/multiplefiles/multiplefiles", "testdata_good/multiplefiles/multiplefiles.json"},
 		{"QuotedFiles", "testdata_good/quotedfiles/quotedfiles", "testdata_good/quotedfiles/quotedfiles.json"},
 		{"Shebang", "testdata_good/shebang/shebang", "testdata_good/shebang/shebang.json"},
+		{"Healthcheck", "testdata_good/healthcheck/healthcheck", "testdata_good/healthcheck/healthcheck.json"},
 	}
 
 	for _, tt := range tests {
@@ -319,7 +320,11 @@ func TestPopulateDefinition(t *testing.T) {
 
 // Specific tests to cover some corners cases of doHeader()
 func TestDoHeader(t *testing.T) {
-	invalidHeaders := []string{"headerTest", "headerTest: invalid"}
+	invalidHeaders := []string{
+		"headerTest",
+		"headerTest: invalid",
+		"bootstrap: docker\nheaderTest: invalid",
+	}
 	myData := new(types.Definition)
 	myData.Labels = make(map[string]string)
 
@@ -329,6 +334,15 @@ func TestDoHeader(t *testing.T) {
 			t.Fatal("Test succeeded while supposed to fail")
 		}
 	}
+
+	// Unknown keywords are passed through to custom bootstrap agents
+	myerr := doHeader("bootstrap: custom\nrepository: https://example.com", myData)
+	if myerr != nil {
+		t.Fatalf("Unexpected failure with custom bootstrap agent: %v", myerr)
+	}
+	if myData.Header["repository"] != "https://example.com" {
+		t.Fatalf("Custom header keyword not found: %v", myData.Header)
+	}
 }
 
 func TestIsValidDefinition(t *testing.T) {
@@ -0,0 +1,562 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/util/shell"
+	"github.com/hpcng/singularity/pkg/build/types"
+	"github.com/hpcng/singularity/pkg/sylog"
+)
+
+// escapeDirective matches the parser directive setting the escape character
+// of a Dockerfile.
+var escapeDirective = regexp.MustCompile(`^#\s*escape\s*=\s*(\S)\s*$`)
+
+// dockerfileInstruction is an instruction of a Dockerfile, with the line
+// where it starts and its physical lines, continuations included.
+type dockerfileInstruction struct {
+	line    int
+	keyword string
+	args    string
+	lines   []string
+}
+
+// dockerfileStage is a stage of a Dockerfile being converted.
+type dockerfileStage struct {
+	def     types.Definition
+	name    string
+	post    []string
+	env     []string
+	workdir string
+
+	entrypoint      []string
+	entrypointShell bool
+	cmd             []string
+}
+
+// dockerfileConverter converts the instructions of a Dockerfile.
+type dockerfileConverter struct {
+	escape     byte
+	globalArgs map[string]string
+	stages     []*dockerfileStage
+}
+
+// ParseDockerfile converts the Dockerfile read from r into the
+// definitions of the corresponding stages. Each stage bootstraps from its
+// docker base image, RUN instructions are converted to %post, ENV to
+// %environment, COPY and ADD to %files, LABEL to %labels and ENTRYPOINT and
+// CMD to %runscript. Instructions without equivalent, like USER or
+// HEALTHCHECK, are ignored with a warning.
+func ParseDockerfile(r io.Reader) ([]types.Definition, error) {
+	c := &dockerfileConverter{
+		escape:     '\\',
+		globalArgs: make(map[string]string),
+	}
+
+	instructions, err := c.readInstructions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, inst := range instructions {
+		if err := c.convert(inst); err != nil {
+			return nil, fmt.Errorf("line %d: %v", inst.line, err)
+		}
+	}
+	if len(c.stages) == 0 {
+		return nil, fmt.Errorf("no FROM instruction found")
+	}
+
+	defs := make([]types.Definition, 0, len(c.stages))
+	for _, s := range c.stages {
+		defs = append(defs, s.definition())
+	}
+	return defs, nil
+}
+
+// readInstructions splits the Dockerfile in instructions, handling line
+// continuations, comments and the escape parser directive.
+func (c *dockerfileConverter) readInstructions(r io.Reader) ([]dockerfileInstruction, error) {
+	var instructions []dockerfileInstruction
+	var current *dockerfileInstruction
+	directives := true
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), " \t\r")
+		trimLine := strings.TrimSpace(line)
+
+		// parser directives must precede any other line
+		if directives {
+			if m := escapeDirective.FindStringSubmatch(trimLine); m != nil {
+				c.escape = m[1][0]
+				continue
+			}
+			directives = strings.HasPrefix(trimLine, "#") && strings.Contains(trimLine, "=")
+		}
+
+		if strings.HasPrefix(trimLine, "#") || (trimLine == "" && current == nil) {
+			continue
+		}
+
+		if current == nil {
+			keyword := trimLine
+			line = ""
+			if i := strings.IndexAny(trimLine, " \t"); i > 0 {
+				keyword, line = trimLine[:i], strings.TrimSpace(trimLine[i:])
+			}
+			current = &dockerfileInstruction{line: n, keyword: strings.ToUpper(keyword)}
+		}
+		current.lines = append(current.lines, line)
+
+		if strings.HasSuffix(line, string(c.escape)) {
+			current.args += strings.TrimSuffix(line, string(c.escape))
+			continue
+		}
+		current.args = strings.TrimSpace(current.args + line)
+		instructions = append(instructions, *current)
+		current = nil
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		current.args = strings.TrimSpace(current.args)
+		instructions = append(instructions, *current)
+	}
+
+	return instructions, nil
+}
+
+// convert converts the instruction into the current stage.
+func (c *dockerfileConverter) convert(inst dockerfileInstruction) error {
+	if inst.keyword == "FROM" {
+		return c.from(inst)
+	}
+	if len(c.stages) == 0 {
+		if inst.keyword == "ARG" {
+			for _, arg := range c.parseArgs(inst.args) {
+				c.globalArgs[arg[0]] = unquoteWord(arg[1], c.escape)
+			}
+			return nil
+		}
+		return fmt.Errorf("%s instruction found before FROM", inst.keyword)
+	}
+
+	s := c.stages[len(c.stages)-1]
+	switch inst.keyword {
+	case "RUN":
+		return c.run(s, inst)
+	case "ENV":
+		for _, kv := range c.keyValues(inst.args, true) {
+			line := "export " + kv[0] + "=" + kv[1]
+			s.env = append(s.env, line)
+			s.post = append(s.post, line)
+		}
+	case "ARG":
+		for _, arg := range c.parseArgs(inst.args) {
+			name, value := arg[0], arg[1]
+			if v, ok := c.globalArgs[name]; ok && value == "" {
+				value = `"` + shell.Escape(v) + `"`
+			}
+			if value == "" {
+				sylog.Warningf("line %d: build argument %s has no default value, it is left unset", inst.line, name)
+				continue
+			}
+			s.post = append(s.post, name+"="+value)
+		}
+	case "COPY", "ADD":
+		return c.copy(s, inst)
+	case "LABEL":
+		for _, kv := range c.keyValues(inst.args, false) {
+			s.def.Labels[unquoteWord(kv[0], c.escape)] = unquoteWord(kv[1], c.escape)
+		}
+	case "MAINTAINER":
+		s.def.Labels["maintainer"] = inst.args
+	case "WORKDIR":
+		dir := unquoteWord(inst.args, c.escape)
+		if !path.IsAbs(dir) {
+			dir = path.Join("/", s.workdir, dir)
+		}
+		s.workdir = dir
+		quoted := `"` + shell.EscapeDoubleQuotes(dir) + `"`
+		s.post = append(s.post, "mkdir -p "+quoted+" && cd "+quoted)
+	case "ENTRYPOINT":
+		s.entrypoint, s.entrypointShell = c.command(inst.args)
+	case "CMD":
+		s.cmd, _ = c.command(inst.args)
+	case "USER", "HEALTHCHECK", "EXPOSE", "VOLUME", "STOPSIGNAL", "SHELL", "ONBUILD":
+		sylog.Warningf("line %d: %s instruction is not supported, ignored", inst.line, inst.keyword)
+	default:
+		return fmt.Errorf("unknown instruction %s", inst.keyword)
+	}
+
+	return nil
+}
+
+// from starts a new stage.
+func (c *dockerfileConverter) from(inst dockerfileInstruction) error {
+	flags, args := c.flags(inst.args)
+	for _, f := range flags {
+		sylog.Warningf("line %d: FROM option %s is not supported, ignored", inst.line, f)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("FROM instruction requires an image")
+	}
+
+	var err error
+	image := os.Expand(args[0], func(name string) string {
+		value, ok := c.globalArgs[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("no default value for build argument %s", name)
+		}
+		return value
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, prev := range c.stages {
+		if prev.name != "" && strings.EqualFold(prev.name, image) {
+			return fmt.Errorf("FROM the previous stage %s is not supported", image)
+		}
+	}
+
+	s := &dockerfileStage{
+		def: types.Definition{
+			Header: map[string]string{"bootstrap": "docker", "from": image},
+			ImageData: types.ImageData{
+				Labels: make(map[string]string),
+			},
+		},
+	}
+	if image == "scratch" {
+		s.def.Header = map[string]string{"bootstrap": "scratch"}
+	}
+
+	switch {
+	case len(args) == 3 && strings.EqualFold(args[1], "AS"):
+		s.name = args[2]
+		s.def.Header["stage"] = s.name
+	case len(args) != 1:
+		return fmt.Errorf("invalid FROM instruction %q", inst.args)
+	}
+
+	c.stages = append(c.stages, s)
+	return nil
+}
+
+// run converts a RUN instruction into %post commands.
+func (c *dockerfileConverter) run(s *dockerfileStage, inst dockerfileInstruction) error {
+	flags, _ := c.flags(inst.args)
+	for _, f := range flags {
+		sylog.Warningf("line %d: RUN option %s is not supported, ignored", inst.line, f)
+	}
+
+	if cmd, isShell := c.command(inst.args); !isShell {
+		s.post = append(s.post, shell.ArgsQuoted(cmd))
+		return nil
+	}
+
+	// keep the line continuations of shell commands, unless the escape
+	// character isn't the one of the shell
+	if c.escape != '\\' {
+		s.post = append(s.post, stripFlags(inst.args))
+		return nil
+	}
+	lines := append([]string{}, inst.lines...)
+	lines[0] = stripFlags(lines[0])
+	if lines[0] == `\` && len(lines) > 1 {
+		lines = lines[1:]
+	}
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+		if i > 0 {
+			lines[i] = "    " + lines[i]
+		}
+	}
+	s.post = append(s.post, lines...)
+
+	return nil
+}
+
+// copy converts a COPY or ADD instruction into %files entries.
+func (c *dockerfileConverter) copy(s *dockerfileStage, inst dockerfileInstruction) error {
+	flags, args := c.flags(inst.args)
+
+	filesArgs := ""
+	for _, f := range flags {
+		if !strings.HasPrefix(f, "--from=") {
+			sylog.Warningf("line %d: %s option %s is not supported, ignored", inst.line, inst.keyword, f)
+			continue
+		}
+		from := strings.TrimPrefix(f, "--from=")
+		stage, err := c.stageName(from)
+		if err != nil {
+			sylog.Warningf("line %d: %v, %s instruction ignored", inst.line, err, inst.keyword)
+			return nil
+		}
+		filesArgs = "from " + stage
+	}
+
+	// JSON form is required for paths containing spaces
+	if rest := stripFlags(inst.args); strings.HasPrefix(rest, "[") {
+		var paths []string
+		if err := json.Unmarshal([]byte(rest), &paths); err == nil {
+			args = paths
+		}
+	} else {
+		for i := range args {
+			args[i] = unquoteWord(args[i], c.escape)
+		}
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("%s instruction requires a source and a destination", inst.keyword)
+	}
+
+	dst := args[len(args)-1]
+	if !path.IsAbs(dst) {
+		dir := strings.HasSuffix(dst, "/")
+		dst = path.Join("/", s.workdir, dst)
+		if dir {
+			dst += "/"
+		}
+	}
+
+	var transfers []types.FileTransport
+	for _, src := range args[:len(args)-1] {
+		if inst.keyword == "ADD" {
+			if strings.Contains(src, "://") {
+				sylog.Warningf("line %d: ADD of URL %s is not supported, ignored", inst.line, src)
+				continue
+			}
+			if strings.Contains(path.Base(src), ".tar") {
+				sylog.Warningf("line %d: archive %s is copied without being extracted", inst.line, src)
+			}
+		}
+		transfers = append(transfers, types.FileTransport{Src: src, Dst: dst})
+	}
+	if len(transfers) == 0 {
+		return nil
+	}
+
+	for i, f := range s.def.BuildData.Files {
+		if f.Args == filesArgs {
+			s.def.BuildData.Files[i].Files = append(f.Files, transfers...)
+			return nil
+		}
+	}
+	s.def.BuildData.Files = append(s.def.BuildData.Files, types.Files{Args: filesArgs, Files: transfers})
+
+	return nil
+}
+
+// stageName returns the name of the stage referenced by name or index
+// by a --from option, naming the stage if needed.
+func (c *dockerfileConverter) stageName(from string) (string, error) {
+	if i, err := strconv.Atoi(from); err == nil && i >= 0 && i < len(c.stages)-1 {
+		s := c.stages[i]
+		if s.name == "" {
+			s.name = fmt.Sprintf("stage%d", i)
+			s.def.Header["stage"] = s.name
+		}
+		return s.name, nil
+	}
+	for _, s := range c.stages[:len(c.stages)-1] {
+		if s.name != "" && strings.EqualFold(s.name, from) {
+			return s.name, nil
+		}
+	}
+	return "", fmt.Errorf("copying files from image %s is not supported", from)
+}
+
+// flags returns the --option flags of the arguments of an instruction,
+// along with the remaining arguments.
+func (c *dockerfileConverter) flags(args string) (flags []string, rest []string) {
+	words := splitWords(args, c.escape)
+	for len(words) > 0 && strings.HasPrefix(words[0], "--") {
+		flags = append(flags, words[0])
+		words = words[1:]
+	}
+	return flags, words
+}
+
+// stripFlags removes the leading --option flags of the arguments of an
+// instruction.
+func stripFlags(args string) string {
+	args = strings.TrimSpace(args)
+	for strings.HasPrefix(args, "--") {
+		end := strings.IndexAny(args, " \t")
+		if end < 0 {
+			return ""
+		}
+		args = strings.TrimSpace(args[end:])
+	}
+	return args
+}
+
+// command returns the command of a RUN, CMD or ENTRYPOINT instruction,
+// commands in shell form are run with /bin/sh -c.
+func (c *dockerfileConverter) command(args string) (cmd []string, isShell bool) {
+	args = stripFlags(args)
+	if strings.HasPrefix(args, "[") {
+		if err := json.Unmarshal([]byte(args), &cmd); err == nil {
+			return cmd, false
+		}
+	}
+	return []string{"/bin/sh", "-c", args}, true
+}
+
+// parseArgs returns the names of the build arguments declared by an ARG
+// instruction, with their default value as written.
+func (c *dockerfileConverter) parseArgs(args string) [][2]string {
+	var declared [][2]string
+	for _, word := range splitWords(args, c.escape) {
+		split := strings.SplitN(word, "=", 2)
+		if len(split) == 2 {
+			declared = append(declared, [2]string{split[0], split[1]})
+		} else {
+			declared = append(declared, [2]string{split[0], ""})
+		}
+	}
+	return declared
+}
+
+// keyValues returns the key=value pairs of an ENV or LABEL instruction,
+// values are returned as written. The legacy "ENV key value" form is
+// supported if legacy is true.
+func (c *dockerfileConverter) keyValues(args string, legacy bool) [][2]string {
+	words := splitWords(args, c.escape)
+	if len(words) == 0 {
+		return nil
+	}
+	if legacy && !strings.Contains(words[0], "=") {
+		value := strings.TrimSpace(strings.TrimPrefix(args, words[0]))
+		return [][2]string{{words[0], `"` + shell.EscapeDoubleQuotes(value) + `"`}}
+	}
+
+	var kvs [][2]string
+	for _, word := range words {
+		split := strings.SplitN(word, "=", 2)
+		if len(split) == 2 {
+			kvs = append(kvs, [2]string{split[0], split[1]})
+		}
+	}
+	return kvs
+}
+
+// splitWords splits s into words separated by spaces outside of quotes,
+// quotes and escape characters are kept.
+func splitWords(s string, escape byte) []string {
+	var words []string
+	var word strings.Builder
+	var quote byte
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == escape && quote != '\'' && i+1 < len(s):
+			word.WriteByte(ch)
+			i++
+			word.WriteByte(s[i])
+		case quote != 0:
+			word.WriteByte(ch)
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+			word.WriteByte(ch)
+		case ch == ' ' || ch == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+			}
+			inWord = false
+			continue
+		default:
+			word.WriteByte(ch)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words
+}
+
+// unquoteWord removes the quotes and escape characters of a word.
+func unquoteWord(s string, escape byte) string {
+	var word strings.Builder
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == escape && quote != '\'' && i+1 < len(s):
+			i++
+			word.WriteByte(s[i])
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+		default:
+			word.WriteByte(ch)
+		}
+	}
+
+	return word.String()
+}
+
+// definition returns the definition of the stage.
+func (s *dockerfileStage) definition() types.Definition {
+	d := s.def
+	d.Environment.Script = indentScript(s.env)
+	d.BuildData.Post.Script = indentScript(s.post)
+
+	var runscript []string
+	if s.workdir != "" {
+		runscript = append(runscript, `cd "`+shell.EscapeDoubleQuotes(s.workdir)+`"`)
+	}
+	switch {
+	case len(s.entrypoint) > 0 && s.entrypointShell:
+		// arguments and CMD are ignored with an ENTRYPOINT in shell form
+		runscript = append(runscript, "exec "+shell.ArgsQuoted(s.entrypoint))
+	case len(s.entrypoint) > 0:
+		if len(s.cmd) > 0 {
+			runscript = append(runscript, "if [ $# -eq 0 ]; then", "    set -- "+shell.ArgsQuoted(s.cmd), "fi")
+		}
+		runscript = append(runscript, "exec "+shell.ArgsQuoted(s.entrypoint)+` "$@"`)
+	case len(s.cmd) > 0:
+		runscript = append(runscript, "if [ $# -eq 0 ]; then", "    set -- "+shell.ArgsQuoted(s.cmd), "fi", `exec "$@"`)
+	default:
+		// keep the runscript of the base image
+		runscript = nil
+	}
+	d.Runscript.Script = indentScript(runscript)
+
+	d.PopulateRaw()
+	return d
+}
+
+func indentScript(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return "    " + strings.Join(lines, "\n    ")
+}
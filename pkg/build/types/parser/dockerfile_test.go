@@ -0,0 +1,119 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hpcng/singularity/pkg/build/types"
+)
+
+const testDockerfile = `# syntax=docker/dockerfile:1
+ARG GO_VERSION=1.16
+
+FROM golang:${GO_VERSION} AS build
+WORKDIR /src
+COPY go.mod main.go ./
+RUN --mount=type=cache,target=/root/.cache \
+    go build -o /app .
+
+FROM alpine:3.14
+LABEL org.opencontainers.image.title="my app" version=1.0
+ENV APP_HOME=/opt/app \
+    PATH="/opt/app:$PATH"
+ARG GO_VERSION
+RUN ["apk", "add", "--no-cache", "ca-certificates"]
+COPY --from=build /app /opt/app/app
+USER nobody
+EXPOSE 8080
+ENTRYPOINT ["/opt/app/app"]
+CMD ["--port", "8080"]
+`
+
+func TestParseDockerfile(t *testing.T) {
+	defs, err := ParseDockerfile(strings.NewReader(testDockerfile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(defs) != 2 {
+		t.Fatalf("got %d stages, want 2", len(defs))
+	}
+
+	build, final := defs[0], defs[1]
+
+	if want := map[string]string{"bootstrap": "docker", "from": "golang:1.16", "stage": "build"}; !reflect.DeepEqual(build.Header, want) {
+		t.Errorf("got header %v, want %v", build.Header, want)
+	}
+	wantPost := "    mkdir -p \"/src\" && cd \"/src\"\n    go build -o /app ."
+	if build.BuildData.Post.Script != wantPost {
+		t.Errorf("got %%post:\n%s\nwant:\n%s", build.BuildData.Post.Script, wantPost)
+	}
+	wantFiles := []types.Files{{Files: []types.FileTransport{{Src: "go.mod", Dst: "/src/"}, {Src: "main.go", Dst: "/src/"}}}}
+	if !reflect.DeepEqual(build.BuildData.Files, wantFiles) {
+		t.Errorf("got files %v, want %v", build.BuildData.Files, wantFiles)
+	}
+
+	if want := map[string]string{"bootstrap": "docker", "from": "alpine:3.14"}; !reflect.DeepEqual(final.Header, want) {
+		t.Errorf("got header %v, want %v", final.Header, want)
+	}
+	wantLabels := map[string]string{"org.opencontainers.image.title": "my app", "version": "1.0"}
+	if !reflect.DeepEqual(final.Labels, wantLabels) {
+		t.Errorf("got labels %v, want %v", final.Labels, wantLabels)
+	}
+	wantEnv := "    export APP_HOME=/opt/app\n    export PATH=\"/opt/app:$PATH\""
+	if final.Environment.Script != wantEnv {
+		t.Errorf("got %%environment:\n%s\nwant:\n%s", final.Environment.Script, wantEnv)
+	}
+	wantPost = wantEnv + "\n    GO_VERSION=\"1.16\"\n    \"apk\" \"add\" \"--no-cache\" \"ca-certificates\""
+	if final.BuildData.Post.Script != wantPost {
+		t.Errorf("got %%post:\n%s\nwant:\n%s", final.BuildData.Post.Script, wantPost)
+	}
+	wantFiles = []types.Files{{Args: "from build", Files: []types.FileTransport{{Src: "/app", Dst: "/opt/app/app"}}}}
+	if !reflect.DeepEqual(final.BuildData.Files, wantFiles) {
+		t.Errorf("got files %v, want %v", final.BuildData.Files, wantFiles)
+	}
+	wantRunscript := "    if [ $# -eq 0 ]; then\n        set -- \"--port\" \"8080\"\n    fi\n    exec \"/opt/app/app\" \"$@\""
+	if final.Runscript.Script != wantRunscript {
+		t.Errorf("got %%runscript:\n%s\nwant:\n%s", final.Runscript.Script, wantRunscript)
+	}
+
+	// the stages must make a valid definition file
+	var raw bytes.Buffer
+	for _, d := range defs {
+		raw.Write(d.Raw)
+	}
+	stages, err := All(&raw)
+	if err != nil {
+		t.Fatalf("could not parse converted definition: %v", err)
+	}
+	if len(stages) != 2 || stages[1].BuildData.Post.Script == "" {
+		t.Errorf("unexpected converted definition %+v", stages)
+	}
+}
+
+func TestParseDockerfileErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+	}{
+		{"no FROM", "RUN true\n"},
+		{"empty", "# comment\n"},
+		{"FROM stage", "FROM alpine AS base\nFROM base\n"},
+		{"unknown instruction", "FROM alpine\nINSTALL curl\n"},
+		{"undefined argument", "FROM alpine:${TAG}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseDockerfile(strings.NewReader(tt.dockerfile)); err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// sectionOrder is the canonical order of the sections of a stage, which
+// follows the order in which they are used by the build.
+var sectionOrder = map[string]int{
+	"arguments":   0,
+	"pre":         1,
+	"setup":       2,
+	"files":       3,
+	"environment": 4,
+	"post":        5,
+	"runscript":   6,
+	"startscript": 7,
+	"healthcheck": 8,
+	"test":        9,
+	"labels":      10,
+	"help":        11,
+}
+
+// appSectionOrder is the canonical order of the sections of an app, which
+// mirrors the order of the corresponding sections of the stage. Apps come
+// after the sections of the stage, in the order they first appear.
+var appSectionOrder = map[string]int{
+	"appfiles":   0,
+	"appenv":     1,
+	"appinstall": 2,
+	"apprun":     3,
+	"apptest":    4,
+	"applabels":  5,
+	"apphelp":    6,
+}
+
+// commentSections contains the sections which aren't shell scripts but
+// whose comment lines are ignored by the build, unlike %help sections
+// where they are part of the help text.
+var commentSections = map[string]bool{
+	"arguments": true,
+	"files":     true,
+	"labels":    true,
+	"appfiles":  true,
+	"applabels": true,
+}
+
+// formatSection is a section of a definition file being formatted.
+type formatSection struct {
+	// comments are the comment lines just above the section
+	comments []string
+	line     string
+	name     string
+	rank     int
+	body     []string
+}
+
+// formatStage is a stage of a definition file being formatted.
+type formatStage struct {
+	header   []string
+	hasKeys  bool
+	sections []*formatSection
+	apps     []string
+	// comments is the number of comment lines ending the header
+	comments int
+}
+
+// Format returns the raw definition file in canonical format: header
+// values are aligned, sections are sorted in canonical order and separated
+// by a blank line, and leading and trailing blank lines of sections are
+// removed. Comments and section contents are preserved, comment lines just
+// above a section or a stage move with it, unless they end a section which
+// isn't a shell script, like %help, where they are content. Sections defined
+// more than once are kept in the order they are defined so that the
+// definition is unchanged. Definitions which can't be parsed are reported
+// as errors, build arguments without default value are accepted.
+func Format(raw []byte) ([]byte, error) {
+	if _, err := parseAll(raw, false); err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(raw), "\r\n", "\n"), "\n")
+
+	stage := &formatStage{}
+	stages := []*formatStage{stage}
+	var section *formatSection
+
+	for _, line := range lines {
+		// comments before the first Bootstrap keyword belong to the first stage
+		if stageHeader.MatchString(line) && (stage.hasKeys || section != nil) {
+			comments := stage.takeComments(section)
+			stage = &formatStage{header: trimLines(comments)}
+			stages = append(stages, stage)
+			section = nil
+		}
+
+		trimLine := strings.TrimSpace(line)
+		if strings.HasPrefix(trimLine, "%") {
+			comments := stage.takeComments(section)
+			section = stage.newSection(trimLine)
+			section.comments = trimLines(comments)
+			continue
+		}
+
+		if section != nil {
+			section.body = append(section.body, line)
+		} else if trimLine == "" {
+			stage.comments = 0
+		} else {
+			stage.header = append(stage.header, trimLine)
+			stage.hasKeys = stage.hasKeys || !strings.HasPrefix(trimLine, "#")
+			if strings.HasPrefix(trimLine, "#") {
+				stage.comments++
+			} else {
+				stage.comments = 0
+			}
+		}
+	}
+
+	var blocks []string
+	for _, s := range stages {
+		blocks = append(blocks, s.format()...)
+	}
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+
+	return []byte(strings.Join(blocks, "\n\n") + "\n"), nil
+}
+
+// takeComments removes and returns the comment lines ending the section,
+// or the header of the stage if section is nil, which are just above the
+// next section or stage. Only shell script sections, where they are shell
+// comments, give their comment lines away, the comment lines of other
+// sections are part of their content.
+func (s *formatStage) takeComments(section *formatSection) []string {
+	if section == nil {
+		n := len(s.header) - s.comments
+		comments := s.header[n:]
+		s.header = s.header[:n]
+		s.comments = 0
+		return comments
+	}
+
+	if !scriptSections[section.name] {
+		return nil
+	}
+
+	n := len(section.body)
+	for n > 0 {
+		line := strings.TrimSpace(section.body[n-1])
+		if !strings.HasPrefix(line, "#") || strings.HasPrefix(line, "#!") {
+			break
+		}
+		n--
+	}
+	comments := section.body[n:]
+	section.body = section.body[:n]
+	return comments
+}
+
+// trimLines returns the lines without leading and trailing spaces.
+func trimLines(lines []string) []string {
+	trimmed := make([]string, 0, len(lines))
+	for _, line := range lines {
+		trimmed = append(trimmed, strings.TrimSpace(line))
+	}
+	return trimmed
+}
+
+// newSection adds the section started by line to the stage.
+func (s *formatStage) newSection(line string) *formatSection {
+	fields := strings.Fields(strings.TrimPrefix(line, "%"))
+	name := strings.ToLower(fields[0])
+	fields[0] = "%" + name
+
+	section := &formatSection{line: strings.Join(fields, " "), name: name}
+	if order, ok := sectionOrder[name]; ok {
+		section.rank = order
+	} else {
+		// app sections are validated by the parser and have an app name
+		app := -1
+		for i, a := range s.apps {
+			if a == fields[1] {
+				app = i
+			}
+		}
+		if app < 0 {
+			app = len(s.apps)
+			s.apps = append(s.apps, fields[1])
+		}
+		section.rank = len(sectionOrder) + app*len(appSectionOrder) + appSectionOrder[name]
+	}
+
+	s.sections = append(s.sections, section)
+	return section
+}
+
+// format returns the formatted header and sections of the stage.
+func (s *formatStage) format() []string {
+	var blocks []string
+
+	// align the values of the header keywords, comments and continuation
+	// lines are preserved
+	width := 0
+	continued := false
+	for _, line := range s.header {
+		if !continued && !strings.HasPrefix(line, "#") {
+			width = max(width, len(strings.TrimSpace(strings.SplitN(line, ":", 2)[0])))
+		}
+		continued = !strings.HasPrefix(line, "#") && strings.HasSuffix(line, "\\")
+	}
+
+	var header []string
+	continued = false
+	for _, line := range s.header {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			header = append(header, line)
+			continued = false
+			continue
+		case continued:
+			header = append(header, strings.Repeat(" ", width+2)+line)
+		default:
+			split := strings.SplitN(line, ":", 2)
+			key := strings.TrimSpace(split[0]) + ":"
+			header = append(header, strings.TrimSpace(fmt.Sprintf("%-*s %s", width+1, key, strings.TrimSpace(split[1]))))
+		}
+		continued = strings.HasSuffix(line, "\\")
+	}
+	if len(header) > 0 {
+		blocks = append(blocks, strings.Join(header, "\n"))
+	}
+
+	sort.SliceStable(s.sections, func(i, j int) bool {
+		return s.sections[i].rank < s.sections[j].rank
+	})
+	for _, section := range s.sections {
+		body := section.body
+		for len(body) > 0 && strings.TrimSpace(body[0]) == "" {
+			body = body[1:]
+		}
+		for len(body) > 0 && strings.TrimSpace(body[len(body)-1]) == "" {
+			body = body[:len(body)-1]
+		}
+		section.body = body
+	}
+	s.placeComments()
+
+	for _, section := range s.sections {
+		lines := append(append(section.comments, section.line), section.body...)
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+
+	return blocks
+}
+
+// placeComments moves the comment lines above the sorted sections which
+// would be read back as part of the previous section, when it's not a
+// shell script. They end the previous section after a blank line if its
+// comment lines are ignored, otherwise they start the section itself if
+// its comment lines are ignored or shell comments, otherwise they go above
+// the first section of the stage.
+func (s *formatStage) placeComments() {
+	for i := 1; i < len(s.sections); i++ {
+		prev, section := s.sections[i-1], s.sections[i]
+		if len(section.comments) == 0 || scriptSections[prev.name] {
+			continue
+		}
+
+		switch {
+		case commentSections[prev.name]:
+			comments := indentLines(section.comments, prev.body)
+			prev.body = append(append(prev.body, ""), comments...)
+		case commentSections[section.name] || scriptSections[section.name]:
+			comments := indentLines(section.comments, section.body)
+			section.body = append(comments, section.body...)
+		default:
+			first := s.sections[0]
+			first.comments = append(first.comments, section.comments...)
+		}
+		section.comments = nil
+	}
+}
+
+// indentLines returns the lines indented like the first line of body.
+func indentLines(lines, body []string) []string {
+	indent := ""
+	if len(body) > 0 {
+		indent = body[0][:len(body[0])-len(strings.TrimLeft(body[0], " \t"))]
+	}
+	indented := make([]string, 0, len(lines))
+	for _, line := range lines {
+		indented = append(indented, indent+line)
+	}
+	return indented
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hpcng/singularity/pkg/build/types"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		def       string
+		want      string
+		shouldErr bool
+	}{
+		{
+			name: "canonical",
+			def: "# preamble\n\nBootstrap:   docker\nFrom: alpine # comment\n# registry\nRegistry: quay\\\n  .io\n\n" +
+				"%Runscript\n    exec app\n\n\n%apprun app\n    exec app\n%labels\n    Author me\n" +
+				"%post   -c /bin/bash\n\n    # install\n    apk add app  \n\n%appenv app\n    export A=b\n%post\n    true\n",
+			want: "# preamble\nBootstrap: docker\nFrom:      alpine # comment\n# registry\nRegistry:  quay\\\n           .io\n\n" +
+				"%post -c /bin/bash\n    # install\n    apk add app  \n\n%post\n    true\n\n%runscript\n    exec app\n\n" +
+				"%labels\n    Author me\n\n%appenv app\n    export A=b\n\n%apprun app\n    exec app\n",
+		},
+		{
+			name: "stages",
+			def:  "Bootstrap: docker\nFrom: golang\nStage: build\n%post\n    go build\nBootstrap: scratch\n%files from build\n    /app\n",
+			want: "Bootstrap: docker\nFrom:      golang\nStage:     build\n\n%post\n    go build\n\nBootstrap: scratch\n\n%files from build\n    /app\n",
+		},
+		{
+			name: "comments",
+			def:  "Bootstrap: docker\nFrom: alpine\n# run the app\n%runscript\n    exec app\n\n    # install the app\n    # with apk\n%post\n    apk add app\n# next stage\nBootstrap: scratch\n",
+			want: "Bootstrap: docker\nFrom:      alpine\n\n# install the app\n# with apk\n%post\n    apk add app\n\n# run the app\n%runscript\n    exec app\n\n# next stage\nBootstrap: scratch\n",
+		},
+		{
+			name: "help comments",
+			def:  "Bootstrap: docker\nFrom: alpine\n%help\n    Usage: run it\n    # Notes\n%post\n    true\n    # done\n%labels\n    Author me\n",
+			want: "Bootstrap: docker\nFrom:      alpine\n\n%post\n    true\n\n# done\n%labels\n    Author me\n\n%help\n    Usage: run it\n    # Notes\n",
+		},
+		{
+			name: "arguments without default",
+			def:  "Bootstrap: docker\nFrom: alpine:{{ tag }}\n%post\n    echo {{ tag }}\n%arguments\n    tag\n",
+			want: "Bootstrap: docker\nFrom:      alpine:{{ tag }}\n\n%arguments\n    tag\n\n%post\n    echo {{ tag }}\n",
+		},
+		{
+			name:      "invalid",
+			def:       "Bootstrap: docker\nFrom: alpine\n%postinstall\n    true\n",
+			shouldErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format([]byte(tt.def))
+			if tt.shouldErr {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFormatDefinitions checks that formatting the test definitions is
+// idempotent and doesn't change what they define.
+func TestFormatDefinitions(t *testing.T) {
+	paths, err := filepath.Glob("testdata_good/*/*")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, path := range paths {
+		if filepath.Ext(path) == ".json" || filepath.Base(path) == "result" {
+			continue
+		}
+		t.Run(path, func(t *testing.T) {
+			raw, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("could not read %s: %v", path, err)
+			}
+
+			formatted, err := Format(raw)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			again, err := Format(formatted)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(formatted, again) {
+				t.Errorf("formatting is not idempotent:\n%s\n%s", formatted, again)
+			}
+
+			checkSameDefinitions(t, raw, formatted)
+		})
+	}
+}
+
+// TestFormatRoundTrip checks that formatting is idempotent and doesn't
+// change what the definitions define.
+func TestFormatRoundTrip(t *testing.T) {
+	defs := []string{
+		"Bootstrap: docker\nFrom: alpine\n%help\n    Usage: run it\n    # Notes\n%post\n    apk add app\n    # cleanup\n%labels\n    Author me\n    # Version 1\n%runscript\n    exec app\n",
+		"Bootstrap: docker\nFrom: alpine\n%environment\n    export A=b\n    # C\n%apphelp app\n    app help\n    # more\n%apprun app\n    exec app\n%files\n    /a /b\n    # /c\n%test\n    true\n",
+		"Bootstrap: docker\nFrom: golang\nStage: build\n%post\n    go build\n# final stage\nBootstrap: docker\nFrom: alpine\n%files from build\n    /app\n%help\n    # only a comment\n",
+		"Bootstrap: docker\nFrom: alpine\n# the app\n%apprun app\n    exec app\n%help\n    help\n# app help\n%apphelp other\n    other\n%post\n    true\n    # labels\n%labels\n    A b\n",
+	}
+
+	for _, def := range defs {
+		formatted, err := Format([]byte(def))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		checkSameDefinitions(t, []byte(def), formatted)
+
+		again, err := Format(formatted)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(formatted, again) {
+			t.Errorf("formatting is not idempotent:\n%s\n%s", formatted, again)
+		}
+	}
+}
+
+// checkSameDefinitions checks that the formatted definition defines the
+// same stages as the raw definition. Blank lines and comment lines are not
+// compared, except in help texts, as Format removes the surrounding blank
+// lines of sections and moves comment lines with the sections they are
+// above.
+func checkSameDefinitions(t *testing.T, raw, formatted []byte) {
+	t.Helper()
+
+	want, err := All(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := All(bytes.NewReader(formatted))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d stages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := normalizeDefinition(got[i]), normalizeDefinition(want[i])
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("formatted definition of stage %d differs:\ngot:\n%+v\nwant:\n%+v", i, g, w)
+		}
+	}
+}
+
+func normalizeDefinition(d types.Definition) types.Definition {
+	d.Raw = nil
+
+	d.Help = normalizeScript(d.Help, false)
+	d.Environment = normalizeScript(d.Environment, true)
+	d.Runscript = normalizeScript(d.Runscript, true)
+	d.ImageScripts.Test = normalizeScript(d.ImageScripts.Test, true)
+	d.Startscript = normalizeScript(d.Startscript, true)
+	d.Healthcheck = normalizeScript(d.Healthcheck, true)
+	d.BuildData.Pre = normalizeScript(d.BuildData.Pre, true)
+	d.BuildData.Setup = normalizeScript(d.BuildData.Setup, true)
+	d.BuildData.Post = normalizeScript(d.BuildData.Post, true)
+	d.BuildData.Test = normalizeScript(d.BuildData.Test, true)
+
+	custom := make(map[string]string)
+	for k, v := range d.CustomData {
+		name := strings.Fields(k)[0]
+		comments := scriptSections[name] || commentSections[name]
+		custom[k] = normalizeScript(types.Script{Script: v}, comments).Script
+	}
+	d.CustomData = custom
+
+	return d
+}
+
+func normalizeScript(s types.Script, comments bool) types.Script {
+	var lines []string
+	for _, line := range strings.Split(s.Script, "\n") {
+		trimLine := strings.TrimSpace(line)
+		if trimLine == "" {
+			continue
+		}
+		if comments && strings.HasPrefix(trimLine, "#") && !strings.HasPrefix(trimLine, "#!") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	s.Script = strings.Join(lines, "\n")
+	return s
+}
@@ -0,0 +1,480 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hpcng/singularity/internal/pkg/util/shell/interpreter"
+)
+
+// Severities of the diagnostics reported by Lint.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is a problem found in a definition file. Line is the line of
+// the definition file where the problem is located, or 0 if it applies
+// to the whole file.
+type Diagnostic struct {
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d: %s: %s", d.Line, d.Severity, d.Message)
+}
+
+// agentHeaders contains the header keywords required and accepted by each
+// built-in bootstrap agent, in addition to the bootstrap and stage keywords.
+var agentHeaders = map[string]struct {
+	required []string
+	optional []string
+}{
+	"library":        {required: []string{"from"}, optional: []string{"library", "fingerprints"}},
+	"oras":           {required: []string{"from"}},
+	"shub":           {required: []string{"from"}},
+	"docker":         {required: []string{"from"}, optional: []string{"registry", "namespace"}},
+	"docker-archive": {required: []string{"from"}},
+	"docker-daemon":  {required: []string{"from"}},
+	"oci":            {required: []string{"from"}},
+	"oci-archive":    {required: []string{"from"}},
+	"localimage":     {required: []string{"from"}, optional: []string{"fingerprints"}},
+	"busybox":        {required: []string{"mirrorurl"}},
+	"debootstrap":    {required: []string{"mirrorurl", "osversion"}, optional: []string{"include"}},
+	"yum":            {required: []string{"mirrorurl"}, optional: []string{"osversion", "updateurl", "include"}},
+	"apk":            {required: []string{"mirrorurl"}, optional: []string{"osversion", "updateurl", "include", "keysdir", "allowuntrusted"}},
+	"zypper": {optional: []string{
+		"mirrorurl", "updateurl", "osversion", "include", "product", "user",
+		"regcode", "productpgp", "registerurl", "modules", "otherurl&n",
+	}},
+	"arch":    {},
+	"scratch": {},
+}
+
+// scriptSections contains the sections holding shell scripts.
+var scriptSections = map[string]bool{
+	"pre":         true,
+	"setup":       true,
+	"post":        true,
+	"test":        true,
+	"runscript":   true,
+	"startscript": true,
+	"healthcheck": true,
+	"environment": true,
+	"appinstall":  true,
+	"apprun":      true,
+	"apptest":     true,
+	"appenv":      true,
+}
+
+// shells contains the interpreters whose scripts are checked by Lint.
+var shells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"dash": true,
+	"ash":  true,
+	"ksh":  true,
+}
+
+// trailingDigits matches the index of numbered header keywords.
+var trailingDigits = regexp.MustCompile(`\d+$`)
+
+// errorLine matches the line number reported by ResolveArguments errors.
+var errorLine = regexp.MustCompile(`line (\d+)`)
+
+// lintSection is a section of a definition file being linted.
+type lintSection struct {
+	line int
+	name string
+	args []string
+	body []string
+}
+
+// lintStage is a stage of a definition file being linted.
+type lintStage struct {
+	line       int
+	name       string
+	header     map[string]int
+	headerVals map[string]string
+	sections   map[string]int
+	// continued is true when the last header line continues on the next line
+	continued bool
+}
+
+type linter struct {
+	diagnostics []Diagnostic
+	stages      []*lintStage
+}
+
+// checkPlaceholders reports the placeholders of the raw definition which
+// refer to build arguments not declared in %arguments sections.
+func (l *linter) checkPlaceholders(raw []byte) {
+	lines := strings.Split(string(raw), "\n")
+
+	declared, err := declaredArguments(lines)
+	if err != nil {
+		return
+	}
+	walkArguments(lines, func(n int, line string, isArgument bool) error {
+		if isArgument {
+			return nil
+		}
+		for _, m := range placeholder.FindAllStringSubmatch(line, -1) {
+			if _, ok := declared[m[1]]; !ok {
+				l.report(n, SeverityError, "build argument %s is not declared in %%arguments", m[1])
+			}
+		}
+		return nil
+	})
+}
+
+func (l *linter) report(line int, severity, format string, a ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{
+		Line:     line,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// Lint checks the raw definition file and returns the problems found,
+// sorted by line. Host files are resolved relative to the current working
+// directory, like the build does.
+func Lint(raw []byte) []Diagnostic {
+	l := &linter{}
+
+	// build arguments without default value are provided at build time
+	resolved, err := resolveArguments(raw, nil, false)
+	if err != nil {
+		line := 0
+		if m := errorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		l.report(line, SeverityError, "%v", err)
+		resolved = raw
+	} else {
+		l.checkPlaceholders(raw)
+	}
+
+	// anything before the first Bootstrap keyword belongs to the first stage
+	stage := l.newStage(1)
+	var section *lintSection
+	inHeader := true
+	lines := strings.Split(string(resolved), "\n")
+
+	for i, line := range lines {
+		n := i + 1
+		trimLine := strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+
+		if stageHeader.MatchString(line) {
+			l.endSection(section)
+			section = nil
+			if len(l.stages) == 1 && inHeader && len(stage.header) == 0 {
+				stage.line = n
+			} else {
+				if inHeader {
+					l.endHeader(stage)
+				}
+				stage = l.newStage(n)
+			}
+			inHeader = true
+		} else if strings.HasPrefix(trimLine, "%") {
+			l.endSection(section)
+			if inHeader {
+				l.endHeader(stage)
+				inHeader = false
+			}
+			section = l.newSection(stage, n, trimLine)
+			continue
+		}
+
+		if inHeader {
+			l.headerLine(stage, n, trimLine)
+		} else if section != nil {
+			section.body = append(section.body, line)
+		}
+	}
+	l.endSection(section)
+	if inHeader {
+		l.endHeader(stage)
+	}
+
+	// report parser errors not covered by the checks above
+	if !l.hasErrors() {
+		if _, err := parseAll(raw, false); err != nil {
+			l.report(0, SeverityError, "%v", err)
+		}
+	}
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		return l.diagnostics[i].Line < l.diagnostics[j].Line
+	})
+
+	return l.diagnostics
+}
+
+func (l *linter) hasErrors() bool {
+	for _, d := range l.diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *linter) newStage(line int) *lintStage {
+	s := &lintStage{
+		line:       line,
+		header:     make(map[string]int),
+		headerVals: make(map[string]string),
+		sections:   make(map[string]int),
+	}
+	l.stages = append(l.stages, s)
+	return s
+}
+
+// headerLine records the header keyword defined by line, continuation
+// lines are ignored.
+func (l *linter) headerLine(s *lintStage, n int, line string) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		s.continued = false
+		return
+	}
+	line = strings.Split(line, "#")[0]
+
+	// continuation of the previous header line
+	continued := s.continued
+	s.continued = strings.HasSuffix(strings.TrimSpace(line), "\\")
+	if continued {
+		return
+	}
+
+	split := strings.SplitN(line, ":", 2)
+	if len(split) == 1 {
+		l.report(n, SeverityError, "header keyword %s has no value", strings.TrimSpace(split[0]))
+		return
+	}
+	key := strings.ToLower(strings.TrimSpace(split[0]))
+	if prev, ok := s.header[key]; ok {
+		l.report(n, SeverityWarning, "header keyword %s already defined at line %d", key, prev)
+	}
+	s.header[key] = n
+	s.headerVals[key] = strings.TrimSpace(split[1])
+}
+
+// headerKey returns the key of validHeaders matching the header keyword.
+func headerKey(key string) string {
+	if validHeaders[key] {
+		return key
+	}
+	if tmpKey := trailingDigits.ReplaceAllString(key, "&n"); tmpKey != key && validHeaders[tmpKey] {
+		return tmpKey
+	}
+	return ""
+}
+
+// endHeader checks the header keywords of the stage against the ones
+// of its bootstrap agent.
+func (l *linter) endHeader(s *lintStage) {
+	if s == nil {
+		return
+	}
+
+	if name, ok := s.headerVals["stage"]; ok {
+		for _, other := range l.stages[:len(l.stages)-1] {
+			if other.name == name {
+				l.report(s.header["stage"], SeverityError, "stage %s already defined at line %d", name, other.line)
+			}
+		}
+		s.name = name
+	}
+
+	agent, ok := s.headerVals["bootstrap"]
+	if !ok {
+		l.report(s.line, SeverityError, "missing Bootstrap header keyword")
+		return
+	}
+	if !builtinAgents[agent] {
+		l.report(s.header["bootstrap"], SeverityWarning, "bootstrap agent %s is not built in and must be provided by a plugin", agent)
+		return
+	}
+
+	accepted := map[string]bool{"bootstrap": true, "stage": true}
+	for _, k := range agentHeaders[agent].required {
+		accepted[k] = true
+	}
+	for _, k := range agentHeaders[agent].optional {
+		accepted[k] = true
+	}
+
+	keys := make([]string, 0, len(s.header))
+	for k := range s.header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		vk := headerKey(k)
+		if vk == "" {
+			l.report(s.header[k], SeverityError, "invalid header keyword %s", k)
+		} else if !accepted[vk] {
+			l.report(s.header[k], SeverityWarning, "header keyword %s is not used by the %s bootstrap agent", k, agent)
+		}
+	}
+	for _, k := range agentHeaders[agent].required {
+		if _, ok := s.header[k]; !ok {
+			l.report(s.header["bootstrap"], SeverityError, "the %s bootstrap agent requires the %s header keyword", agent, k)
+		}
+	}
+}
+
+// newSection checks the section started by line.
+func (l *linter) newSection(s *lintStage, n int, line string) *lintSection {
+	fields := strings.Fields(strings.TrimLeft(line, "%"))
+	section := &lintSection{line: n, name: getSectionName(line)}
+	if len(fields) > 1 {
+		section.args = fields[1:]
+	}
+
+	key := section.name
+	switch {
+	case validSections[section.name]:
+		if section.name == "files" {
+			key += " " + strings.Join(section.args, " ")
+		}
+		if prev, ok := s.sections[key]; ok && section.name != "files" {
+			l.report(n, SeverityWarning, "section %%%s already defined at line %d, their contents are concatenated", section.name, prev)
+		}
+	case appSections[section.name]:
+		if len(section.args) == 0 {
+			l.report(n, SeverityError, "app section %%%s has no app name", section.name)
+			break
+		}
+		key += " " + section.args[0]
+		if prev, ok := s.sections[key]; ok {
+			l.report(n, SeverityError, "section %%%s for app %s already defined at line %d", section.name, section.args[0], prev)
+		}
+	default:
+		l.report(n, SeverityError, "unknown section %%%s", section.name)
+		return nil
+	}
+	s.sections[key] = n
+
+	if section.name == "files" {
+		l.checkFilesArgs(s, section)
+	}
+
+	return section
+}
+
+// checkFilesArgs checks that the stage from which files are copied is
+// defined before the stage s.
+func (l *linter) checkFilesArgs(s *lintStage, section *lintSection) {
+	args := strings.Fields(strings.Split(strings.Join(section.args, " "), "#")[0])
+	if len(args) == 0 {
+		return
+	}
+	if len(args) != 2 || args[0] != "from" {
+		l.report(section.line, SeverityError, "invalid %%files arguments %q, expected \"from <stage>\"", strings.Join(args, " "))
+		return
+	}
+	for _, other := range l.stages {
+		if other == s {
+			break
+		}
+		if other.name == args[1] {
+			return
+		}
+	}
+	l.report(section.line, SeverityError, "stage %s is not defined before this stage", args[1])
+}
+
+// endSection checks the body of the section.
+func (l *linter) endSection(section *lintSection) {
+	if section == nil {
+		return
+	}
+
+	if section.name == "files" {
+		if len(section.args) == 0 || strings.HasPrefix(section.args[0], "#") {
+			l.checkHostFiles(section)
+		}
+		return
+	}
+
+	if !scriptSections[section.name] {
+		return
+	}
+	if !isShellScript(section) {
+		return
+	}
+
+	script := strings.Join(section.body, "\n")
+	if line, err := interpreter.CheckSyntax([]byte(script), "%"+section.name); err != nil {
+		n := section.line
+		if line > 0 {
+			n += int(line)
+		}
+		l.report(n, SeverityError, "%%%s: shell syntax error: %v", section.name, err)
+	}
+}
+
+// isShellScript returns whether the script of the section is run by a
+// shell, according to the interpreter set with the -c section argument or
+// to the shebang of the runscript, startscript and healthcheck sections.
+func isShellScript(section *lintSection) bool {
+	for i, arg := range section.args {
+		if arg == "-c" && i+1 < len(section.args) {
+			return shells[filepath.Base(section.args[i+1])]
+		}
+	}
+
+	if section.name != "runscript" && section.name != "startscript" && section.name != "healthcheck" {
+		return true
+	}
+	for _, line := range section.body {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#!") {
+			return true
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+		if len(fields) > 1 && filepath.Base(fields[0]) == "env" {
+			fields = fields[1:]
+		}
+		return len(fields) == 0 || shells[filepath.Base(fields[0])]
+	}
+	return true
+}
+
+// checkHostFiles checks that the sources of a %files section copying
+// files from the host exist.
+func (l *linter) checkHostFiles(section *lintSection) {
+	for i, line := range section.body {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// sources using build arguments are only known at build time
+		if placeholder.MatchString(line) {
+			continue
+		}
+		src := strings.Trim(fileSplitter.FindString(line), "\"")
+		matches, err := filepath.Glob(src)
+		if err != nil {
+			l.report(section.line+i+1, SeverityError, "invalid source %s: %v", src, err)
+		} else if len(matches) == 0 {
+			l.report(section.line+i+1, SeverityError, "source %s does not exist", src)
+		}
+	}
+}
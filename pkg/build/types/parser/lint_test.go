@@ -0,0 +1,107 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name string
+		def  string
+		want []Diagnostic
+	}{
+		{
+			name: "valid",
+			def: "Bootstrap: docker\nFrom: alpine\nStage: build\n%post -c /bin/sh\n    if true; then echo ok; fi\n" +
+				"Bootstrap: scratch\n%files from build\n    /bin/busybox\n%apprun app\n    exec app\n%runscript -c /usr/bin/python3\n    if True: pass\n" +
+				"%startscript\n    #!/usr/bin/env python\n    print(\"started\")\n",
+		},
+		{
+			name: "headers",
+			def:  "# comment\nBootstrap: debootstrap\nOSVersion: bullseye\nFrom: debian\nFoo: bar\n%post\n    true\n",
+			want: []Diagnostic{
+				{Line: 2, Severity: SeverityError, Message: "the debootstrap bootstrap agent requires the mirrorurl header keyword"},
+				{Line: 4, Severity: SeverityWarning, Message: "header keyword from is not used by the debootstrap bootstrap agent"},
+				{Line: 5, Severity: SeverityError, Message: "invalid header keyword foo"},
+			},
+		},
+		{
+			name: "plugin agent",
+			def:  "Bootstrap: custom\nCustom: value\n",
+			want: []Diagnostic{
+				{Line: 1, Severity: SeverityWarning, Message: "bootstrap agent custom is not built in and must be provided by a plugin"},
+			},
+		},
+		{
+			name: "missing bootstrap",
+			def:  "From: alpine\n%post\n    true\n",
+			want: []Diagnostic{
+				{Line: 1, Severity: SeverityError, Message: "missing Bootstrap header keyword"},
+			},
+		},
+		{
+			name: "sections",
+			def: "Bootstrap: docker\nFrom: alpine\n%post\n    true\n%postinstall\n    true\n%apprun\n    true\n" +
+				"%apprun app\n    true\n%apprun app\n    true\n%post\n    false\n",
+			want: []Diagnostic{
+				{Line: 5, Severity: SeverityError, Message: "unknown section %postinstall"},
+				{Line: 7, Severity: SeverityError, Message: "app section %apprun has no app name"},
+				{Line: 11, Severity: SeverityError, Message: "section %apprun for app app already defined at line 9"},
+				{Line: 13, Severity: SeverityWarning, Message: "section %post already defined at line 3, their contents are concatenated"},
+			},
+		},
+		{
+			name: "files",
+			def:  "Bootstrap: docker\nFrom: alpine\n%files\n    lint_test.go /\n    missing.txt /\n%files from build\n    /bin/sh\n",
+			want: []Diagnostic{
+				{Line: 5, Severity: SeverityError, Message: "source missing.txt does not exist"},
+				{Line: 6, Severity: SeverityError, Message: "stage build is not defined before this stage"},
+			},
+		},
+		{
+			name: "shell syntax",
+			def:  "Bootstrap: docker\nFrom: alpine\n%post\n    echo ok\n    if true; then\n        echo ko\n%environment\n    export A=\"b\n",
+			want: []Diagnostic{
+				{Line: 5, Severity: SeverityError, Message: "%post: shell syntax error: if statement must end with \"fi\""},
+				{Line: 8, Severity: SeverityError, Message: "%environment: shell syntax error: reached EOF without closing quote \""},
+			},
+		},
+		{
+			// arguments without default value are provided at build time
+			name: "arguments",
+			def:  "Bootstrap: docker\nFrom: alpine:{{ tag }}\n%arguments\n    tag\n    src\n%files\n    {{ src }} /\n%post\n    echo {{ tag }}\n",
+		},
+		{
+			name: "undeclared arguments",
+			def:  "Bootstrap: docker\nFrom: alpine:{{ tag }}\n%arguments\n    tag=3.14\n%post\n    echo {{ undefined }} {{ tag }}\n",
+			want: []Diagnostic{
+				{Line: 6, Severity: SeverityError, Message: "build argument undefined is not declared in %arguments"},
+			},
+		},
+		{
+			name: "invalid arguments",
+			def:  "Bootstrap: docker\nFrom: alpine\n%arguments\n    tag=1\n    tag=2\n",
+			want: []Diagnostic{
+				{Line: 5, Severity: SeverityError, Message: "line 5: build argument tag declared with different default values"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Lint([]byte(tt.def))
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got diagnostics:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
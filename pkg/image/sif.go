@@ -20,6 +20,15 @@ const (
 	SIFDescOCIConfigJSON = "oci-config.json"
 	// SIFDescInspectMetadataJSON is the name of the SIF descriptor holding the container metadata.
 	SIFDescInspectMetadataJSON = "inspect-metadata.json"
+	// SIFDescSBOMJSON is the name of the SIF descriptor holding the CycloneDX
+	// software bill of materials of the container.
+	SIFDescSBOMJSON = "sbom.cdx.json"
+	// SIFDescProvenanceJSON is the name of the SIF descriptor holding the
+	// in-toto provenance statement of the container build.
+	SIFDescProvenanceJSON = "provenance.intoto.json"
+	// SIFDescBuildLog is the name of the SIF descriptor holding the gzip
+	// compressed output of the build scripts of the container.
+	SIFDescBuildLog = "build-log.gz"
 )
 
 type sifFormat struct{}
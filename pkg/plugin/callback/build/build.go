@@ -0,0 +1,32 @@
+// Copyright (c) 2021, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the URIs of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+
+	"github.com/hpcng/singularity/pkg/build/types"
+)
+
+// ConveyorPacker is the interface a custom bootstrap agent must implement.
+// Get is called first to retrieve the image sources into the bundle, the
+// definition file being available with Bundle.Recipe, then Pack is called to
+// populate the bundle root filesystem.
+type ConveyorPacker interface {
+	Get(context.Context, *types.Bundle) error
+	Pack(context.Context) (*types.Bundle, error)
+}
+
+// BootstrapAgent callback allows plugins to provide custom bootstrap agents.
+// It is called with the value of the Bootstrap header keyword of the
+// definition file being built and must return the ConveyorPacker for this
+// agent, or nil if the plugin doesn't provide it. Built-in bootstrap agents
+// can't be overridden. Header keywords unknown to Singularity are accepted
+// for custom agents and are passed through Bundle.Recipe.Header, plugins are
+// responsible for their validation.
+// This callback is called in:
+// - internal/pkg/build/conveyorPacker.go (build command)
+type BootstrapAgent func(bootstrap string) ConveyorPacker
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/hpcng/singularity/internal/pkg/runtime/engine/config/oci"
 	"github.com/hpcng/singularity/pkg/image"
@@ -104,6 +105,12 @@ type JSONConfig struct {
 	Instance          bool              `json:"instance,omitempty"`
 	InstanceJoin      bool              `json:"instanceJoin,omitempty"`
 	BootInstance      bool              `json:"bootInstance,omitempty"`
+	RestartPolicy     string            `json:"restartPolicy,omitempty"`
+	HealthCmd         string            `json:"healthCmd,omitempty"`
+	HealthInterval    time.Duration     `json:"healthInterval,omitempty"`
+	HealthRetries     int               `json:"healthRetries,omitempty"`
+	LogMaxSize        int64             `json:"logMaxSize,omitempty"`
+	LogMaxFiles       int               `json:"logMaxFiles,omitempty"`
 	RunPrivileged     bool              `json:"runPrivileged,omitempty"`
 	AllowSUID         bool              `json:"allowSUID,omitempty"`
 	KeepPrivs         bool              `json:"keepPrivs,omitempty"`
@@ -334,6 +341,73 @@ func (e *EngineConfig) GetInstanceJoin() bool {
 	return e.JSON.InstanceJoin
 }
 
+// SetRestartPolicy sets the restart policy of the instance start script.
+func (e *EngineConfig) SetRestartPolicy(policy string) {
+	e.JSON.RestartPolicy = policy
+}
+
+// GetRestartPolicy returns the restart policy of the instance start script.
+func (e *EngineConfig) GetRestartPolicy() string {
+	return e.JSON.RestartPolicy
+}
+
+// SetHealthCmd sets the command checking the instance health, overriding
+// the image healthcheck.
+func (e *EngineConfig) SetHealthCmd(cmd string) {
+	e.JSON.HealthCmd = cmd
+}
+
+// GetHealthCmd returns the command checking the instance health.
+func (e *EngineConfig) GetHealthCmd() string {
+	return e.JSON.HealthCmd
+}
+
+// SetHealthInterval sets the interval between two instance health checks.
+func (e *EngineConfig) SetHealthInterval(interval time.Duration) {
+	e.JSON.HealthInterval = interval
+}
+
+// GetHealthInterval returns the interval between two instance health checks.
+func (e *EngineConfig) GetHealthInterval() time.Duration {
+	return e.JSON.HealthInterval
+}
+
+// SetHealthRetries sets the number of consecutive health check failures
+// before the instance is unhealthy.
+func (e *EngineConfig) SetHealthRetries(retries int) {
+	e.JSON.HealthRetries = retries
+}
+
+// GetHealthRetries returns the number of consecutive health check failures
+// before the instance is unhealthy.
+func (e *EngineConfig) GetHealthRetries() int {
+	return e.JSON.HealthRetries
+}
+
+// SetLogMaxSize sets the size in bytes from which the instance log files
+// are rotated, zero disables the rotation.
+func (e *EngineConfig) SetLogMaxSize(size int64) {
+	e.JSON.LogMaxSize = size
+}
+
+// GetLogMaxSize returns the size in bytes from which the instance log
+// files are rotated.
+func (e *EngineConfig) GetLogMaxSize() int64 {
+	return e.JSON.LogMaxSize
+}
+
+// SetLogMaxFiles sets the number of rotated log files kept for each
+// instance log file.
+func (e *EngineConfig) SetLogMaxFiles(files int) {
+	e.JSON.LogMaxFiles = files
+}
+
+// GetLogMaxFiles returns the number of rotated log files kept for each
+// instance log file.
+func (e *EngineConfig) GetLogMaxFiles() int {
+	return e.JSON.LogMaxFiles
+}
+
 // SetBootInstance sets boot flag to execute /sbin/init as main instance process.
 func (e *EngineConfig) SetBootInstance(boot bool) {
 	e.JSON.BootInstance = boot
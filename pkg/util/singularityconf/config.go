@@ -73,6 +73,7 @@ type File struct {
 	DownloadConcurrency     uint     `default:"3" directive:"download concurrency"`
 	DownloadPartSize        uint     `default:"5242880" directive:"download part size"`
 	DownloadBufferSize      uint     `default:"32768" directive:"download buffer size"`
+	SystemCacheDir          string   `directive:"system cache dir"`
 }
 
 const TemplateAsset = `# SINGULARITY.CONF
@@ -463,4 +464,14 @@ download part size = {{ .DownloadPartSize }}
 # This option specifies the transfer buffer size when concurrent downloads
 # are enabled.
 download buffer size = {{ .DownloadBufferSize }}
+
+# SYSTEM CACHE DIR: [STRING]
+# DEFAULT: Undefined
+# Path to a read-only image cache, administered for all users, that is
+# consulted before the user cache. Images found in this cache are used in
+# place and never copied to the user cache. The directory is populated like a
+# user cache, e.g. with 'SINGULARITY_CACHEDIR=<path> singularity pull ...',
+# and its content must then be made readable by all users.
+# system cache dir =
+{{ if ne .SystemCacheDir "" }}system cache dir = {{ .SystemCacheDir }}{{ end }}
 `